@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/chzyer/flow"
@@ -30,6 +31,7 @@ type Client struct {
 	dcCli *dchan.Client
 	dcIn  packet.Chan
 	dcOut packet.Chan
+	sched atomic.Value // string, kept in relogin
 
 	needLoginChan chan struct{}
 }
@@ -43,6 +45,7 @@ func New(cfg *Config, f *flow.Flow) *Client {
 		HTTP:          NewHTTP(cfg.Host, cfg.UserName, cfg.Password, []byte(cfg.AesKey)),
 		needLoginChan: make(chan struct{}, 1),
 	}
+	cli.sched.Store(cfg.Sched)
 	http.DefaultClient.Timeout = 10 * time.Second
 	return cli
 }
@@ -82,22 +85,36 @@ loop:
 
 func (c *Client) initDataChannel(remoteCfg *uc.AuthResponse) (err error) {
 	port := remoteCfg.DataChannel
-	session := packet.NewSessionCli(remoteCfg.UserId, []byte(remoteCfg.Token))
+	l2Version := remoteCfg.L2Version
+	if l2Version == 0 {
+		// the server is too old to tell us
+		l2Version = packet.VersionCFB
+	}
+	session := packet.NewSessionCli(remoteCfg.UserId, []byte(remoteCfg.Token), l2Version)
+	if rekey, err := c.cfg.GetRekey(); err == nil {
+		session.SetRekey(rekey)
+	}
 
 	if c.dcCli != nil {
 		c.dcCli.Close()
 		c.dcCli = nil
 	}
 
+	opt, err := c.cfg.GetChannelOption(remoteCfg.ChannelOpt)
+	if err != nil {
+		return err
+	}
 	delegate := &DchanDelegate{c}
-	dcCli, err := dchan.NewClient(c.flow, session, delegate, remoteCfg.ChannelType, c.dcIn.Recv(), c.dcOut.Send())
+	dcCli, err := dchan.NewClient(c.flow, session, delegate, remoteCfg.ChannelType, opt, c.dcIn.Recv(), c.dcOut.Send())
 	if err != nil {
 		return err
 	}
+	sched, _ := dchan.NewScheduler(c.GetScheduler())
+	dcCli.SetScheduler(sched)
 	dcCli.AddHost(c.cfg.GetHostName(), port)
 	c.dcCli = dcCli
 	dcCli.Run()
-	logex.Info("datachannel inited:", dcCli.Ports())
+	logex.Info("datachannel inited:", dcCli.Endpoints())
 	return nil
 }
 
@@ -129,6 +146,7 @@ func (c *Client) initTun(remoteCfg *uc.AuthResponse) (in, out chan []byte, err e
 }
 
 func (c *Client) onRelogin(remoteCfg *uc.AuthResponse) error {
+	c.tun.ConfigUpdate(remoteCfg)
 	if err := c.initDataChannel(remoteCfg); err != nil {
 		return logex.Trace(err)
 	}
@@ -208,6 +226,9 @@ func (c *Client) runPprof() {
 
 func (c *Client) Run() {
 	go c.runPprof()
+	if c.cfg.Metrics != "" {
+		go c.runMetrics()
+	}
 	if err := c.runShell(); err != nil {
 		c.flow.Error(err)
 		return
@@ -240,8 +261,8 @@ func (c *Client) runShell() error {
 // -----------------------------------------------------------------------------
 // controller
 
-func (c *Client) OnNewDC(ports []int) {
-	c.dcCli.UpdateRemoteAddrs(c.cfg.GetHostName(), ports)
+func (c *Client) OnNewDC(eps []dchan.Endpoint) {
+	c.dcCli.UpdateRemoteAddrs(c.cfg.GetHostName(), eps)
 }
 
 func (c *Client) SaveRoute() error {
@@ -1,7 +1,7 @@
 package clish
 
 import (
-	"fmt"
+	"strings"
 
 	"github.com/chzyer/flagly"
 	"github.com/chzyer/logex"
@@ -9,14 +9,16 @@ import (
 	"github.com/chzyer/next/dchan"
 	"github.com/chzyer/next/ip"
 	"github.com/chzyer/next/route"
-	"github.com/chzyer/readline"
+	"github.com/chzyer/next/util/shellout"
 )
 
 type Client interface {
-	GetDataChannelStat() (string, error)
+	GetDataChannelStat() ([]*dchan.ChannelStat, error)
 	ShowControllerStage() ([]controller.StageInfo, error)
 	GetController() (*controller.Client, error)
 	GetDchan() (*dchan.Client, error)
+	GetScheduler() string
+	SetScheduler(name string) error
 	GetRoute() (*route.Route, error)
 	SaveRoute() error
 	Relogin()
@@ -40,7 +42,7 @@ func (sh *ShellDig) FlaglyDesc() string {
 	return "DNS lookup utility"
 }
 
-func (sh *ShellDig) FlaglyHandle(c Client, rl *readline.Instance) error {
+func (sh *ShellDig) FlaglyHandle(c Client, out *shellout.Output) error {
 	if sh.Host == "" {
 		return flagly.Error("host is required")
 	}
@@ -48,10 +50,7 @@ func (sh *ShellDig) FlaglyHandle(c Client, rl *readline.Instance) error {
 	if err != nil {
 		return flagly.Error(err.Error())
 	}
-	for _, addr := range addrs {
-		fmt.Fprintln(rl, addr)
-	}
-	return nil
+	return out.Result(addrs, strings.Join(addrs, "\n"))
 }
 
 type ShellPing struct{}
@@ -1,6 +1,10 @@
 package clish
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/chzyer/next/util/shellout"
+)
 
 type Controller struct {
 	Stage *ControllerStage `flagly:"handler"`
@@ -8,10 +12,19 @@ type Controller struct {
 
 type ControllerStage struct{}
 
-func (*ControllerStage) FlaglyHandle(c Client) error {
+type stageEntry struct {
+	ReqId uint32 `json:"req_id"`
+	Type  string `json:"type"`
+}
+
+func (*ControllerStage) FlaglyHandle(c Client, out *shellout.Output) error {
 	info, err := c.ShowControllerStage()
 	if err != nil {
 		return err
 	}
-	return fmt.Errorf("staging: %v", len(info))
+	entries := make([]stageEntry, len(info))
+	for idx, i := range info {
+		entries[idx] = stageEntry{i.ReqId, i.DataType.String()}
+	}
+	return out.Result(entries, fmt.Sprintf("staging: %v", len(info)))
 }
@@ -1,11 +1,11 @@
 package clish
 
 import (
-	"bytes"
-	"fmt"
 	"strings"
 
 	"github.com/chzyer/flagly"
+	"github.com/chzyer/next/dchan"
+	"github.com/chzyer/next/util/shellout"
 )
 
 type Dchan struct {
@@ -13,41 +13,76 @@ type Dchan struct {
 	Close  *DchanClose  `flagly:"handler"`
 	List   *DchanList   `flagly:"handler"`
 	Speed  *DchanSpeed  `flagly:"handler"`
+	Sched  *DchanSched  `flagly:"handler"`
+}
+
+type DchanSched struct {
+	Name string `type:"[0]"`
+}
+
+type dchanSched struct {
+	Name string `json:"name"`
+}
+
+func (d *DchanSched) FlaglyDesc() string {
+	return "show or set the scheduler: " + strings.Join(dchan.SchedulerNames, ", ")
+}
+
+func (d *DchanSched) FlaglyHandle(c Client, out *shellout.Output) error {
+	if d.Name == "" {
+		name := c.GetScheduler()
+		return out.Result(&dchanSched{name}, "scheduler: "+name)
+	}
+	if err := c.SetScheduler(d.Name); err != nil {
+		return flagly.Error(err.Error())
+	}
+	return out.Message("scheduler is set to '%v'", d.Name)
 }
 
 type DchanSpeed struct{}
 
-func (DchanSpeed) FlaglyHandle(c Client) error {
+type dchanSpeed struct {
+	Upload        int64 `json:"upload"`
+	Download      int64 `json:"download"`
+	TotalUpload   int64 `json:"total_upload"`
+	TotalDownload int64 `json:"total_download"`
+}
+
+func (DchanSpeed) FlaglyHandle(c Client, out *shellout.Output) error {
 	ch, err := c.GetDchan()
 	if err != nil {
 		return err
 	}
 	info := ch.GetSpeedInfo()
-	return fmt.Errorf("upload:   %v/s\ndownload: %v/s", info.Upload, info.Download)
+	return out.Result(&dchanSpeed{
+		Upload:        int64(info.Upload),
+		Download:      int64(info.Download),
+		TotalUpload:   int64(info.TotalUpload),
+		TotalDownload: int64(info.TotalDownload),
+	}, "upload:   "+info.Upload.String()+"/s\ndownload: "+info.Download.String()+"/s")
 }
 
 type DchanUseful struct{}
 
-func (DchanUseful) FlaglyHandle(c Client) error {
-	ch, err := c.GetDchan()
+func (DchanUseful) FlaglyHandle(c Client, out *shellout.Output) error {
+	stats, err := c.GetDataChannelStat()
 	if err != nil {
 		return err
 	}
-	chs := ch.GetUsefulChan()
-	buf := bytes.NewBuffer(nil)
-	for _, ch := range chs {
-		buf.WriteString(fmt.Sprintf("%v: %v\n",
-			ch.Name(), ch.GetStat().String(),
-		))
+	useful := make([]*dchan.ChannelStat, 0, len(stats))
+	for _, stat := range stats {
+		if stat.Useful {
+			useful = append(useful, stat)
+		}
 	}
-	return fmt.Errorf("%v", strings.TrimSpace(buf.String()))
+	return out.Result(useful, dchan.FormatChannelStats(useful))
 }
 
 type DchanClose struct {
 	Name string `type:"[0]"`
 }
 
-func (d *DchanClose) FlaglyHandle(c Client) error {
+func (d *DchanClose) FlaglyHandle(c Client, out *shellout.Output) error {
 	if d.Name == "" {
 		return flagly.Error("name is both required")
 	}
@@ -55,16 +90,18 @@ func (d *DchanClose) FlaglyHandle(c Client) error {
 	if err != nil {
 		return err
 	}
-	return ch.CloseChannel(d.Name)
+	if err := ch.CloseChannel(d.Name); err != nil {
+		return err
+	}
+	return out.Message("channel '%v' closed", d.Name)
 }
 
 type DchanList struct{}
 
-func (DchanList) FlaglyHandle(c Client) error {
-	stat, err := c.GetDataChannelStat()
+func (DchanList) FlaglyHandle(c Client, out *shellout.Output) error {
+	stats, err := c.GetDataChannelStat()
 	if err != nil {
 		return err
 	}
-
-	return fmt.Errorf("%v", strings.TrimSpace(stat))
+	return out.Result(stats, dchan.FormatChannelStats(stats))
 }
@@ -8,7 +8,7 @@ import (
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
 	"github.com/chzyer/next/util"
-	"github.com/chzyer/readline"
+	"github.com/chzyer/next/util/shellout"
 )
 
 type ShellDebug struct {
@@ -26,7 +26,7 @@ type Flower interface {
 	GetFlow() *flow.Flow
 }
 
-func (d *DebugFlow) FlaglyHandle(c Client, h *flagly.Handler) error {
+func (d *DebugFlow) FlaglyHandle(c Client, out *shellout.Output) error {
 	if d.Name == "" {
 		return flagly.Error("name is required")
 	}
@@ -48,7 +48,8 @@ func (d *DebugFlow) FlaglyHandle(c Client, h *flagly.Handler) error {
 	if flower == nil {
 		return fmt.Errorf("%v is not found", d.Name)
 	}
-	return fmt.Errorf(string(flower.GetFlow().GetDebug()))
+	debug := string(flower.GetFlow().GetDebug())
+	return out.Result(debug, debug)
 }
 
 type Login struct{}
@@ -61,7 +62,7 @@ type ShellDebugGoroutine struct {
 	Find string `type:"[0]"`
 }
 
-func (s ShellDebugGoroutine) FlaglyHandle(rl *readline.Instance) error {
+func (s ShellDebugGoroutine) FlaglyHandle(out *shellout.Output) error {
 	var ret string
 	if s.Find == "" {
 		ret = string(util.GetRuntimeStackInfo())
@@ -69,14 +70,14 @@ func (s ShellDebugGoroutine) FlaglyHandle(rl *readline.Instance) error {
 		sp := util.FindRuntimeStack(s.Find)
 		ret = strings.Join(sp, "\n\n")
 	}
-	return fmt.Errorf(ret)
+	return out.Result(ret, ret)
 }
 
 type ShellDebugLog struct {
 	Level string `type:"[0]" select:"debug,info,warn,error"`
 }
 
-func (s ShellDebugLog) FlaglyHandle() error {
+func (s ShellDebugLog) FlaglyHandle(out *shellout.Output) error {
 	var level int
 	switch s.Level {
 	case "debug":
@@ -88,15 +89,22 @@ func (s ShellDebugLog) FlaglyHandle() error {
 	case "error":
 		level = 3
 	default:
-		return fmt.Errorf("current log level: %v", logex.DebugLevel)
+		return out.Result(debugLevel{logex.DebugLevel},
+			fmt.Sprintf("current log level: %v", logex.DebugLevel))
 	}
 
 	if level == -1 {
-		return fmt.Errorf("current log level: %v", logex.DebugLevel)
+		return out.Result(debugLevel{logex.DebugLevel},
+			fmt.Sprintf("current log level: %v", logex.DebugLevel))
 	}
 	if level > 3 {
 		return flagly.Errorf(fmt.Sprintf("invalid level: %v", level))
 	}
 	logex.DebugLevel = level
-	return fmt.Errorf("log level set to %v", logex.DebugLevel)
+	return out.Result(debugLevel{logex.DebugLevel},
+		fmt.Sprintf("log level set to %v", logex.DebugLevel))
+}
+
+type debugLevel struct {
+	Level int `json:"level"`
 }
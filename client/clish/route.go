@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"net"
-	"strings"
 	"time"
 
 	"github.com/chzyer/flagly"
@@ -12,7 +11,7 @@ import (
 	"github.com/chzyer/next/ip"
 	"github.com/chzyer/next/route"
 	"github.com/chzyer/next/util"
-	"github.com/chzyer/readline"
+	"github.com/chzyer/next/util/shellout"
 )
 
 type ShellRoute struct {
@@ -29,7 +28,7 @@ type ShellRouteRemove struct {
 	CIDR string `type:"[0]"`
 }
 
-func (arg *ShellRouteRemove) FlaglyHandle(c Client) error {
+func (arg *ShellRouteRemove) FlaglyHandle(c Client, out *shellout.Output) error {
 	if arg.CIDR == "" {
 		return flagly.Error("CIDR is empty")
 	}
@@ -43,31 +42,49 @@ func (arg *ShellRouteRemove) FlaglyHandle(c Client) error {
 	if err := c.SaveRoute(); err != nil {
 		return err
 	}
-	return fmt.Errorf("item '%v' removed", arg.CIDR)
+	return out.Message("item '%v' removed", arg.CIDR)
 }
 
 // -----------------------------------------------------------------------------
 
 type ShellRouteShow struct{}
 
-func (ShellRouteShow) FlaglyHandle(c Client, rl *readline.Instance) error {
+type routeItem struct {
+	CIDR    string     `json:"cidr"`
+	Comment string     `json:"comment"`
+	Expired *time.Time `json:"expired,omitempty"`
+}
+
+type routeTable struct {
+	Items          []routeItem `json:"items"`
+	EphemeralItems []routeItem `json:"ephemeral_items"`
+}
+
+func (ShellRouteShow) FlaglyHandle(c Client, out *shellout.Output) error {
 	route, err := c.GetRoute()
 	if err != nil {
 		return err
 	}
+	var table routeTable
+	buf := bytes.NewBuffer(nil)
 	eis := route.GetEphemeralItems()
+	table.EphemeralItems = make([]routeItem, 0, len(eis))
 	if len(eis) > 0 {
-		fmt.Fprintln(rl, "EphemeralItem:")
+		fmt.Fprintln(buf, "EphemeralItem:")
 		for _, ei := range eis {
-			fmt.Fprintf(rl, "\t%v:\t%v\t\t%v\n", ei.Expired, ei.CIDR, ei.Comment)
+			expired := ei.Expired
+			table.EphemeralItems = append(table.EphemeralItems,
+				routeItem{ei.CIDR, ei.Comment, &expired})
+			fmt.Fprintf(buf, "\t%v:\t%v\t\t%v\n", ei.Expired, ei.CIDR, ei.Comment)
 		}
 
 	}
 	items := route.GetItems()
+	table.Items = make([]routeItem, 0, len(items))
 
 	if len(items) > 0 {
 		if len(eis) > 0 {
-			fmt.Fprintln(rl)
+			fmt.Fprintln(buf)
 		}
 		max := 0
 		for _, item := range items {
@@ -76,14 +93,15 @@ func (ShellRouteShow) FlaglyHandle(c Client, rl *readline.Instance) error {
 			}
 		}
 
-		fmt.Fprintln(rl, "Item:")
+		fmt.Fprintln(buf, "Item:")
 		for _, item := range items {
-			fmt.Fprintf(rl, "\t%v\t%v\n",
+			table.Items = append(table.Items, routeItem{CIDR: item.CIDR, Comment: item.Comment})
+			fmt.Fprintf(buf, "\t%v\t%v\n",
 				util.FillString(item.CIDR, max, " "), item.Comment,
 			)
 		}
 	}
-	return nil
+	return out.Result(&table, buf.String())
 }
 
 // -----------------------------------------------------------------------------
@@ -97,7 +115,12 @@ func (arg *ShellRouteAddDomain) FlaglyDesc() string {
 	return "add a route by domain with duration"
 }
 
-func (arg *ShellRouteAddDomain) FlaglyHandle(c Client, rl *readline.Instance) error {
+type routeAdded struct {
+	CIDR  string `json:"cidr"`
+	Added bool   `json:"added"`
+}
+
+func (arg *ShellRouteAddDomain) FlaglyHandle(c Client, out *shellout.Output) error {
 	if arg.Host == "" {
 		return flagly.Error("host is required")
 	}
@@ -111,17 +134,21 @@ func (arg *ShellRouteAddDomain) FlaglyHandle(c Client, rl *readline.Instance) er
 	cfg := &ShellRouteAdd{
 		Duration: arg.Duration,
 	}
+	ret := make([]routeAdded, 0, len(ips))
+	buf := bytes.NewBuffer(nil)
 	for _, ip := range ips {
 		cfg.CIDR = ip.String()
-		if err := cfg.FlaglyHandle(c); err != nil {
+		if _, err := cfg.add(c); err != nil {
 			if logex.Equal(route.ErrRouteItemExists, err) {
-				fmt.Fprintf(rl, "ip %v is exists! ignore\n", cfg.CIDR)
+				ret = append(ret, routeAdded{cfg.CIDR, false})
+				fmt.Fprintf(buf, "ip %v is exists! ignore\n", cfg.CIDR)
 				continue
 			}
 		}
-		fmt.Fprintf(rl, "ip %v is added!\n", cfg.CIDR)
+		ret = append(ret, routeAdded{cfg.CIDR, true})
+		fmt.Fprintf(buf, "ip %v is added!\n", cfg.CIDR)
 	}
-	return nil
+	return out.Result(ret, buf.String())
 }
 
 // -----------------------------------------------------------------------------
@@ -134,35 +161,43 @@ type ShellRouteAdd struct {
 	Comment string `type:"[1]"`
 }
 
-func (arg *ShellRouteAdd) FlaglyHandle(c Client) (err error) {
+func (arg *ShellRouteAdd) FlaglyHandle(c Client, out *shellout.Output) error {
 	if arg.CIDR == "" {
 		return flagly.Error("CIDR is empty")
 	}
 	if !arg.Force && arg.Comment == "" && arg.Duration == 0 {
 		return flagly.Error("comment is empty")
 	}
+	msg, err := arg.add(c)
+	if err != nil {
+		return err
+	}
+	return out.Message("%v", msg)
+}
+
+func (arg *ShellRouteAdd) add(c Client) (string, error) {
 	if arg.Duration == 0 {
 		item, err := route.NewItemCIDR(arg.CIDR, arg.Comment)
 		if err != nil {
-			return flagly.Error(err.Error())
+			return "", flagly.Error(err.Error())
 		}
 		routeTable, err := c.GetRoute()
 		if err != nil {
-			return err
+			return "", err
 		}
 		err = routeTable.AddItem(item)
 		if err != nil {
-			return err
+			return "", err
 		}
 		err = c.SaveRoute()
 		if err != nil {
-			return err
+			return "", err
 		}
-		return fmt.Errorf("route item '%v' added", arg.CIDR)
+		return fmt.Sprintf("route item '%v' added", arg.CIDR), nil
 	} else {
 		item, err := route.NewItemCIDR(arg.CIDR, arg.Comment)
 		if err != nil {
-			return err
+			return "", err
 		}
 		ei := &route.EphemeralItem{
 			Item:    item,
@@ -170,19 +205,19 @@ func (arg *ShellRouteAdd) FlaglyHandle(c Client) (err error) {
 		}
 		routeTable, err := c.GetRoute()
 		if err != nil {
-			return err
+			return "", err
 		}
 		err = routeTable.AddEphemeralItem(ei)
 		if err != nil {
-			return err
+			return "", err
 		}
 		err = c.SaveRoute()
 		if err != nil {
-			return err
+			return "", err
 		}
-		return fmt.Errorf("ephemeral item '%v' added, expired in: %v",
+		return fmt.Sprintf("ephemeral item '%v' added, expired in: %v",
 			ei.CIDR, ei.Expired,
-		)
+		), nil
 	}
 }
 
@@ -191,7 +226,12 @@ type ShellRouteGet struct {
 	Host string `type:"[0]" name:"ip/host"`
 }
 
-func (s *ShellRouteGet) FlaglyHandle(c Client) error {
+type routeMatch struct {
+	CIDR  string `json:"cidr"`
+	Match bool   `json:"match"`
+}
+
+func (s *ShellRouteGet) FlaglyHandle(c Client, out *shellout.Output) error {
 	if s.Host == "" {
 		return flagly.Error("Host is required")
 	}
@@ -212,6 +252,7 @@ func (s *ShellRouteGet) FlaglyHandle(c Client) error {
 		}
 	}
 
+	ret := make([]routeMatch, 0, len(cidrs))
 	buf := bytes.NewBuffer(nil)
 	for _, cidr := range cidrs {
 		_, ipnet, err := net.ParseCIDR(cidr)
@@ -223,6 +264,7 @@ func (s *ShellRouteGet) FlaglyHandle(c Client) error {
 			return err
 		}
 		item := routeTable.Match(ipnet)
+		ret = append(ret, routeMatch{cidr, item != nil})
 		buf.WriteString(util.FillString(cidr, max, " ") + "    ")
 		if item != nil {
 			buf.WriteString("ok\n")
@@ -231,5 +273,5 @@ func (s *ShellRouteGet) FlaglyHandle(c Client) error {
 		}
 	}
 
-	return fmt.Errorf(strings.TrimSpace(buf.String()))
+	return out.Result(ret, buf.String())
 }
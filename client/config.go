@@ -1,12 +1,16 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
+	"github.com/chzyer/next/dchan"
+	"github.com/chzyer/next/packet"
 )
 
 type Config struct {
@@ -20,9 +24,22 @@ type Config struct {
 	AesKey    string `name:"key"`
 	RouteFile string `default:"routes.conf"`
 	Pprof     string `default:":10060"`
+	Metrics   string `desc:"listen address of prometheus metrics, e.g. localhost:9101"`
 
 	Sock string `desc:"unixsock for interactive with" default:"/tmp/next.sock"`
 
+	// override the tuning pushed by server
+	KCP    string `name:"kcp" desc:"tuning of udp channel: normal, fast, or nodelay,interval,resend,nc"`
+	KCPWnd string `name:"kcpwnd" desc:"send,receive window of udp channel, e.g. 256,1024"`
+	KCPMTU int    `name:"kcpmtu" desc:"mtu of udp channel"`
+	WSURL  string `name:"wsurl" desc:"dial the url for ws channel if the server is behind a reverse proxy, e.g. wss://example.com/next"`
+	Pin    string `name:"pin" desc:"sha256 fingerprint of the server cert for tls and quic channel, the one pushed by server is used if empty"`
+
+	RekeySize     string        `name:"rekeysize" desc:"rotate the key of data channel after sending the bytes" default:"1G"`
+	RekeyInterval time.Duration `name:"rekeyinterval" desc:"rotate the key of data channel after the interval" default:"10m"`
+
+	Sched string `name:"sched" desc:"scheduler of data channels: flow, ready, rtt, wrr, redundant" default:"flow"`
+
 	Host2 string `name:"host"`
 	Host  string `type:"[0]"`
 }
@@ -67,12 +84,59 @@ func (c *Config) FlaglyVerify() error {
 	if c.Password == "" {
 		return fmt.Errorf("password is missing")
 	}
+	if _, err := dchan.ParseKCP(c.KCP, c.KCPWnd, c.KCPMTU); err != nil {
+		return logex.Trace(err)
+	}
+	if c.WSURL != "" && !dchan.IsWsURL(c.WSURL) {
+		return fmt.Errorf("invalid wsurl: %v", c.WSURL)
+	}
+	if _, err := c.GetRekey(); err != nil {
+		return logex.Trace(err)
+	}
+	if err := dchan.CheckScheduler(c.Sched); err != nil {
+		return logex.Trace(err)
+	}
+	pin, err := dchan.ParseFingerprint(c.Pin)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	c.Pin = pin
 
 	flow.DefaultDebug = c.DebugFlow
 	logex.ShowCode = c.DebugStack
 	return nil
 }
 
+func (c *Config) GetRekey() (packet.Rekey, error) {
+	return packet.ParseRekey(c.RekeySize, c.RekeyInterval)
+}
+
+// GetChannelOption returns the option pushed by server, the kcp tuning, the
+// url of ws channel and the pinned cert are replaced if they are set in client.
+func (c *Config) GetChannelOption(remote json.RawMessage) (*dchan.ChannelOption, error) {
+	var opt dchan.ChannelOption
+	if len(remote) > 0 {
+		if err := json.Unmarshal(remote, &opt); err != nil {
+			return nil, logex.Trace(err)
+		}
+	}
+	kcp, _ := dchan.ParseKCP(c.KCP, c.KCPWnd, c.KCPMTU)
+	if kcp == nil && c.WSURL == "" && c.Pin == "" {
+		if len(remote) == 0 {
+			return nil, nil
+		}
+		return &opt, nil
+	}
+	if kcp != nil {
+		opt.KCP = kcp
+	}
+	opt.URL = c.WSURL
+	if c.Pin != "" {
+		opt.Fingerprint = c.Pin
+	}
+	return &opt, nil
+}
+
 func (c *Config) FlaglyHandle(f *flow.Flow) error {
 	New(c, f).Run()
 	return nil
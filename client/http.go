@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/chzyer/logex"
+	"github.com/chzyer/next/crypto"
 	"github.com/chzyer/next/mchan"
+	"github.com/chzyer/next/packet"
 	"github.com/chzyer/next/uc"
 	"github.com/chzyer/next/util"
 	"github.com/chzyer/next/util/clock"
@@ -82,12 +84,37 @@ func (c *HTTP) Login(onLogin func(*uc.AuthResponse) error) error {
 }
 
 func (c *HTTP) doLogin(username string, password string) (*uc.AuthResponse, error) {
-	req := uc.NewAuthRequest(
-		username, c.clock.Unix(), []byte(password), c.AesKey)
+	srp := crypto.NewSRPClient()
+	var challenge uc.AuthChallenge
+	if err := c.httpReq(&challenge, "/auth/challenge", &uc.AuthChallengeRequest{
+		UserName: username,
+		Public:   srp.A,
+	}); err != nil {
+		return nil, err
+	}
+
+	x := challenge.KDF.Private(challenge.Salt, password)
+	proof, err := srp.Proof(x, challenge.Public)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	req := &uc.AuthRequest{
+		Id:        challenge.Id,
+		Proof:     proof,
+		L2Version: packet.VersionAEAD,
+	}
+	if challenge.KDF != uc.KDFCurrent {
+		// server can't do it without the password
+		req.Upgrade = uc.NewVerifierUpgrade(password).Seal(srp.K)
+	}
 	var ret uc.AuthResponse
 	if err := c.httpReq(&ret, "/auth", req); err != nil {
 		return nil, err
 	}
+	// make sure we are talking to the server which knows our verifier
+	if err := srp.Verify(ret.Proof); err != nil {
+		return nil, logex.Trace(err)
+	}
 	return &ret, nil
 }
 
@@ -0,0 +1,23 @@
+package client
+
+import (
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/metrics"
+)
+
+func (c *Client) runMetrics() {
+	logex.Info("listen metrics at", c.cfg.Metrics)
+	if err := metrics.ListenAndServe(c.flow, c.cfg.Metrics, c); err != nil {
+		c.flow.Error(err)
+	}
+}
+
+func (c *Client) CollectMetrics(w *metrics.Writer) {
+	if dcCli := c.dcCli; dcCli != nil {
+		dcCli.WriteMetrics(w)
+	}
+	if ctl := c.ctl; ctl != nil {
+		w.Gauge("next_controller_stage", "requests waiting for reply",
+			float64(ctl.StageLen()))
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/chzyer/next/controller"
 	"github.com/chzyer/next/dchan"
 	"github.com/chzyer/next/route"
+	"github.com/chzyer/next/util/shellout"
 	"github.com/chzyer/readline"
 	"github.com/google/shlex"
 )
@@ -86,7 +87,8 @@ func (s *Shell) handleConn(conn net.Conn) {
 	defer rl.Close()
 
 	var client clish.Client = s.client
-	fset.Context(rl, &client)
+	out := shellout.New(rl, rl.Stderr())
+	fset.Context(rl, &client, out)
 
 	if rl.Config.FuncIsTerminal() {
 		fmt.Fprintln(rl, Slogan)
@@ -108,10 +110,9 @@ func (s *Shell) handleConn(conn net.Conn) {
 			continue
 		}
 
-		if err := fset.Run(args); err != nil {
-			fmt.Fprintln(rl.Stderr(), err)
-			continue
-		}
+		args, isJSON := shellout.ParseArgs(args)
+		out.Reset(isJSON)
+		out.Done(fset.Run(args))
 	}
 }
 
@@ -128,12 +129,12 @@ func (s *Shell) loop() {
 // Shell Delegate
 // -----------------------------------------------------------------------------
 
-func (c *Client) GetDataChannelStat() (string, error) {
+func (c *Client) GetDataChannelStat() ([]*dchan.ChannelStat, error) {
 	dc, err := c.GetDchan()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return dc.GetStats(), nil
+	return dc.GetChannelStats(), nil
 }
 
 func (c *Client) ShowControllerStage() ([]controller.StageInfo, error) {
@@ -151,6 +152,24 @@ func (c *Client) GetController() (*controller.Client, error) {
 	return c.ctl, nil
 }
 
+func (c *Client) GetScheduler() string {
+	return c.sched.Load().(string)
+}
+
+// SetScheduler replaces the scheduler of data channels, it's kept in
+// relogin.
+func (c *Client) SetScheduler(name string) error {
+	sched, err := dchan.NewScheduler(name)
+	if err != nil {
+		return err
+	}
+	c.sched.Store(name)
+	if dc := c.dcCli; dc != nil {
+		dc.SetScheduler(sched)
+	}
+	return nil
+}
+
 func (c *Client) GetDchan() (*dchan.Client, error) {
 	if c.dcCli == nil {
 		return nil, ErrNotReady
@@ -4,13 +4,15 @@ import (
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
 	"github.com/chzyer/next/ip"
+	"github.com/chzyer/next/route"
 	"github.com/chzyer/next/uc"
 	"github.com/chzyer/tunnel"
 )
 
 type Tun struct {
-	tun  *tunnel.Instance
-	flow *flow.Flow
+	tun   *tunnel.Instance
+	flow  *flow.Flow
+	inet6 string
 }
 
 func newTun(f *flow.Flow, remoteCfg *uc.AuthResponse, cfg *Config) (*Tun, error) {
@@ -30,17 +32,41 @@ func newTun(f *flow.Flow, remoteCfg *uc.AuthResponse, cfg *Config) (*Tun, error)
 	if err != nil {
 		return nil, logex.Trace(err)
 	}
+	if remoteCfg.INet6 != "" {
+		if err := setAddr6(tun.Name, remoteCfg); err != nil {
+			tun.Close()
+			return nil, logex.Trace(err)
+		}
+	}
 	t := &Tun{
-		tun: tun,
+		tun:   tun,
+		inet6: remoteCfg.INet6,
 	}
 	f.ForkTo(&t.flow, t.Close)
 
 	return t, nil
 }
 
-// ConfigUpdate: non-test
-func (t *Tun) ConfigUpdate(remoteCfg *uc.AuthResponse) {
+func setAddr6(devName string, remoteCfg *uc.AuthResponse) error {
+	ipnet, err := ip.ParseCIDR6(remoteCfg.Gateway6)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	ipnet.IP = ip.ParseIP6(remoteCfg.INet6)
+	return route.SetAddr6(devName, ipnet.ToNet())
+}
 
+// ConfigUpdate applies the ipv6 address which is assigned after relogin,
+// like the server enables ipv6 or the user gets a new one.
+func (t *Tun) ConfigUpdate(remoteCfg *uc.AuthResponse) {
+	if remoteCfg.INet6 == "" || remoteCfg.INet6 == t.inet6 {
+		return
+	}
+	if err := setAddr6(t.tun.Name, remoteCfg); err != nil {
+		logex.Error("set ipv6 address failed:", err)
+		return
+	}
+	t.inet6 = remoteCfg.INet6
 }
 
 func (t *Tun) Close() {
@@ -5,11 +5,19 @@ import (
 
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
+	"github.com/chzyer/next/dchan"
 	"github.com/chzyer/next/packet"
 )
 
 type CliDelegate interface {
-	OnNewDC(port []int)
+	// the Type of endpoints is empty if the server is too old to tell
+	OnNewDC(eps []dchan.Endpoint)
+}
+
+// NewDCRequest is the payload of NEWDC, the endpoints of all channel types
+// are replied if Typed, otherwise the ports of login channel type.
+type NewDCRequest struct {
+	Typed bool `json:"typed"`
 }
 
 type Client struct {
@@ -52,7 +60,8 @@ loop:
 	for {
 		select {
 		case <-c.newDC:
-			c.Send(packet.New(nil, packet.NEWDC))
+			req, _ := json.Marshal(NewDCRequest{Typed: true})
+			c.Send(packet.New(req, packet.NEWDC))
 		case <-c.flow.IsClose():
 			break loop
 		}
@@ -68,10 +77,9 @@ func (c *Client) handlePacket(p *packet.Packet) bool {
 			return false
 		}
 	case packet.NEWDC_R:
-		var port []int
-		json.Unmarshal(p.Payload(), &port)
-		if len(port) > 0 {
-			c.delegate.OnNewDC(port)
+		eps := parseEndpoints(p.Payload())
+		if len(eps) > 0 {
+			c.delegate.OnNewDC(eps)
 		}
 	}
 	if p.Type.IsReq() {
@@ -98,3 +106,19 @@ loop:
 		}
 	}
 }
+
+// parseEndpoints parses the reply of NEWDC, the old servers reply the ports
+// only.
+func parseEndpoints(payload []byte) []dchan.Endpoint {
+	var eps []dchan.Endpoint
+	if err := json.Unmarshal(payload, &eps); err == nil {
+		return eps
+	}
+	var ports []int
+	json.Unmarshal(payload, &ports)
+	eps = nil
+	for _, port := range ports {
+		eps = append(eps, dchan.Endpoint{Port: port})
+	}
+	return eps
+}
@@ -241,6 +241,10 @@ loop:
 	}
 }
 
+func (c *Controller) StageLen() int {
+	return c.stage.Len()
+}
+
 func (c *Controller) ShowStage() []StageInfo {
 	return c.stage.ShowStage()
 }
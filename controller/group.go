@@ -5,12 +5,16 @@ import (
 
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
+	"github.com/chzyer/next/dchan"
 	"github.com/chzyer/next/packet"
 	"github.com/chzyer/next/uc"
 )
 
 type SvrDelegate interface {
-	GetAllDataChannel() []int
+	// GetChannelType returns the channel type of login
+	GetChannelType() string
+	GetAllDataChannel() []dchan.Endpoint
+	IsHub() bool
 }
 
 type Group struct {
@@ -38,14 +42,17 @@ loop:
 		select {
 		case ipPacket := <-fromTun:
 			d := packet.NewDataPacket(ipPacket)
-			u := c.users.FindByIP(d.DestIP())
+			u := c.findDest(d)
 			if u == nil {
-				logex.Errorf("user not found: %v", d.DestIP())
 				continue
 			}
 			c.mutex.RLock()
 			ctl := c.online[u.Id]
 			c.mutex.RUnlock()
+			if ctl == nil {
+				logex.Errorf("user is offline: %v", u.Name)
+				continue
+			}
 			logex.Debugf("send to %v: %v", u.Name, d.Packet.Type)
 			ctl.Send(d.Packet)
 		case <-c.flow.IsClose():
@@ -54,27 +61,95 @@ loop:
 	}
 }
 
-func (c *Group) OnDchanPortUpdate(port []int) {
+func (c *Group) findDest(d *packet.DataPacket) *uc.User {
+	var u *uc.User
+	switch d.IPVersion() {
+	case 4:
+		u = c.users.FindByIP(d.DestIP())
+		if u == nil {
+			logex.Errorf("user not found: %v", d.DestIP())
+		}
+	case 6:
+		u = c.users.FindByIP6(d.DestIP6())
+		if u == nil {
+			logex.Errorf("user not found: %v", d.DestIP6())
+		}
+	default:
+		logex.Error("invalid ip packet, size:", len(d.Payload()))
+	}
+	return u
+}
+
+// Allow checks the packet by the acl of user.
+func (c *Group) Allow(from *uc.User, d *packet.DataPacket) bool {
+	return c.users.Allow(from, d)
+}
+
+// Route forwards the packet to another user directly in hub mode, the users
+// must be in a same group. The packets to the others are sent to tun.
+func (c *Group) Route(from *uc.User, d *packet.DataPacket) bool {
+	if !c.delegate.IsHub() {
+		return false
+	}
+	var to *uc.User
+	switch d.IPVersion() {
+	case 4:
+		to = c.users.FindByIP(d.DestIP())
+	case 6:
+		to = c.users.FindByIP6(d.DestIP6())
+	}
+	if to == nil || to.Id == from.Id {
+		return false
+	}
+	if !from.ShareGroup(to.UserInfo) {
+		logex.Debugf("hub: %v -> %v is denied", from.Name, to.Name)
+		return true
+	}
+	c.mutex.RLock()
+	ctl := c.online[to.Id]
+	c.mutex.RUnlock()
+	if ctl == nil {
+		logex.Debugf("hub: %v is offline", to.Name)
+		return true
+	}
+	logex.Debugf("hub: %v -> %v", from.Name, to.Name)
+	ctl.Send(d.Packet)
+	return true
+}
+
+func (c *Group) OnDchanPortUpdate(eps []dchan.Endpoint) {
+	chanType := c.delegate.GetChannelType()
 	c.mutex.RLock()
 	for _, ctl := range c.online {
-		ctl.NotifyDataChannel(port)
+		ctl.NotifyDataChannel(chanType, eps)
 	}
 	c.mutex.RUnlock()
 }
 
+func (c *Group) UserLogout(userId uint16) bool {
+	c.mutex.Lock()
+	controller, ok := c.online[userId]
+	delete(c.online, userId)
+	c.mutex.Unlock()
+	if ok {
+		controller.Close()
+	}
+	return ok
+}
+
 func (c *Group) UserLogin(u *uc.User) *Server {
 	logex.Debug("controller.onUserLogin")
 	c.mutex.Lock()
 	controller, ok := c.online[u.Id]
 	if !ok {
-		controller = NewServer(c.flow, u, c.toTun)
+		controller = NewServer(c.flow, u, c.toTun, c)
 		c.online[u.Id] = controller
 	} else {
 		controller.UserRelogin(u)
 	}
 	c.mutex.Unlock()
 	logex.Debug("controller.onUserLogin.notify")
-	controller.NotifyDataChannel(c.delegate.GetAllDataChannel())
+	controller.NotifyDataChannel(c.delegate.GetChannelType(), c.delegate.GetAllDataChannel())
 	logex.Debug("controller.onUserLogin.done")
 	return controller
 }
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/dchan"
+	"github.com/chzyer/next/ip"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/next/uc"
+	"github.com/chzyer/test"
+)
+
+type hubDelegate struct {
+	hub bool
+}
+
+func (d *hubDelegate) GetChannelType() string              { return "tcp" }
+func (d *hubDelegate) GetAllDataChannel() []dchan.Endpoint { return nil }
+func (d *hubDelegate) IsHub() bool                         { return d.hub }
+
+func newIPv4Packet(dest string) *packet.DataPacket {
+	payload := make([]byte, 20)
+	payload[0] = 0x45
+	addr := ip.ParseIP(dest)
+	copy(payload[16:20], addr[:])
+	return packet.NewDataPacket(payload)
+}
+
+func TestGroupRoute(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+
+	users := uc.NewUsers()
+	alice, err := users.Register("alice", "bye")
+	test.Nil(err)
+	bob, err := users.Register("bob", "bye")
+	test.Nil(err)
+	carol, err := users.Register("carol", "bye")
+	test.Nil(err)
+	for idx, addr := range []string{"10.8.0.2", "10.8.0.3", "10.8.0.4"} {
+		netIP := ip.ParseIP(addr)
+		test.Nil(users.SetNet(idx, &netIP))
+		test.Nil(users.SetGroups(users.FindId(idx).Name, []string{"dev"}))
+	}
+	test.Nil(users.SetGroups("carol", []string{"ops"}))
+
+	delegate := &hubDelegate{}
+	g := NewGroup(f, delegate, users, nil)
+	g.UserLogin(bob)
+
+	// disabled
+	test.False(g.Route(alice, newIPv4Packet("10.8.0.3")))
+	delegate.hub = true
+
+	// not an user
+	test.False(g.Route(alice, newIPv4Packet("10.8.0.100")))
+	// in different groups
+	test.True(g.Route(carol, newIPv4Packet("10.8.0.3")))
+	// offline
+	test.True(g.Route(bob, newIPv4Packet("10.8.0.2")))
+
+	test.True(g.Route(alice, newIPv4Packet("10.8.0.3")))
+	fromUser, _ := bob.GetFromDataChannel()
+	select {
+	case ps := <-fromUser:
+		test.Equal(len(ps), 1)
+		test.Equal(ps[0].Type, packet.DATA)
+		test.Equal(packet.NewDataPacket(ps[0].Payload()).DestIP().String(), "10.8.0.3")
+	case <-time.After(time.Second):
+		t.Fatal("packet is not forwarded")
+	}
+}
@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"github.com/chzyer/next/metrics"
+)
+
+func (c *Group) WriteMetrics(w *metrics.Writer) {
+	c.mutex.RLock()
+	online := make([]*Server, 0, len(c.online))
+	for _, ctl := range c.online {
+		online = append(online, ctl)
+	}
+	c.mutex.RUnlock()
+
+	w.Gauge("next_online_users", "count of online users", float64(len(online)))
+	for _, ctl := range online {
+		w.Gauge("next_controller_stage", "requests waiting for reply",
+			float64(ctl.StageLen()), "user", ctl.user.Name)
+	}
+}
@@ -2,22 +2,37 @@ package controller
 
 import (
 	"encoding/json"
+	"sync"
 
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
+	"github.com/chzyer/next/dchan"
 	"github.com/chzyer/next/packet"
 	"github.com/chzyer/next/uc"
 )
 
+// Router decides where the packets from user go.
+type Router interface {
+	// Allow returns false if the packet should be dropped by acl.
+	Allow(from *uc.User, d *packet.DataPacket) bool
+	// Route forwards the packet between users without the tun, it returns
+	// false if the packet should be sent to tun.
+	Route(from *uc.User, d *packet.DataPacket) bool
+}
+
 type Server struct {
 	*Controller
-	flow  *flow.Flow
-	user  *uc.User
-	toTun chan<- []byte
-	ports []int
+	flow   *flow.Flow
+	user   *uc.User
+	toTun  chan<- []byte
+	router Router
+
+	mutex     sync.Mutex
+	chanType  string
+	endpoints []dchan.Endpoint
 }
 
-func NewServer(f *flow.Flow, u *uc.User, toTun chan<- []byte) *Server {
+func NewServer(f *flow.Flow, u *uc.User, toTun chan<- []byte, router Router) *Server {
 	fromDC, toDC := u.GetFromController()
 	ctl := NewController(f, toDC, fromDC)
 	s := &Server{
@@ -25,23 +40,51 @@ func NewServer(f *flow.Flow, u *uc.User, toTun chan<- []byte) *Server {
 		Controller: ctl,
 		user:       u,
 		toTun:      toTun,
+		router:     router,
 	}
 	go s.recvLoop()
 	return s
 }
 
-func (s *Server) NotifyDataChannel(port []int) {
-	s.ports = port
-	return
+// NotifyDataChannel updates the endpoints replied in NEWDC_R, the old clients
+// only know the ones of login channel type.
+func (s *Server) NotifyDataChannel(chanType string, eps []dchan.Endpoint) {
+	s.mutex.Lock()
+	s.chanType = chanType
+	s.endpoints = eps
+	s.mutex.Unlock()
+}
+
+func (s *Server) newDCReply(payload []byte) []byte {
+	var req NewDCRequest
+	json.Unmarshal(payload, &req)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var ret []byte
+	if req.Typed {
+		ret, _ = json.Marshal(s.endpoints)
+	} else {
+		ret, _ = json.Marshal(dchan.EndpointPorts(s.endpoints, s.chanType))
+	}
+	return ret
 }
 
 func (s *Server) handlePacket(p *packet.Packet) bool {
 	switch p.Type {
 	case packet.NEWDC:
-		ret, _ := json.Marshal(s.ports)
-		s.Send(p.Reply(ret))
+		s.Send(p.Reply(s.newDCReply(p.Payload())))
 		return true
 	case packet.DATA:
+		if s.router != nil {
+			d := packet.NewDataPacket(p.Payload())
+			if !s.router.Allow(s.user, d) {
+				logex.Debugf("acl: drop packet from %v to %v", s.user.Name, d.DestNetIP())
+				break
+			}
+			if s.router.Route(s.user, d) {
+				break
+			}
+		}
 		select {
 		case s.toTun <- p.Payload():
 		case <-s.flow.IsClose():
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/chzyer/next/dchan"
+	"github.com/chzyer/test"
+)
+
+func TestNewDCReply(t *testing.T) {
+	defer test.New(t)
+
+	s := &Server{}
+	eps := []dchan.Endpoint{
+		{Type: "tcp", Port: 1},
+		{Type: "udp", Port: 2},
+		{Type: "tcp", Port: 3},
+	}
+	s.NotifyDataChannel("tcp", eps)
+
+	// old clients
+	test.Equal(string(s.newDCReply(nil)), "[1,3]")
+	test.Equal(parseEndpoints(s.newDCReply(nil)), []dchan.Endpoint{{Port: 1}, {Port: 3}})
+
+	req, _ := json.Marshal(NewDCRequest{Typed: true})
+	test.Equal(parseEndpoints(s.newDCReply(req)), eps)
+}
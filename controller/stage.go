@@ -76,6 +76,13 @@ type StageInfo struct {
 	DataType packet.Type
 }
 
+// Len returns how many requests are waiting for reply.
+func (s *Stage) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.staging)
+}
+
 func (s *Stage) ShowStage() []StageInfo {
 	s.m.Lock()
 	defer s.m.Unlock()
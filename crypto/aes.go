@@ -39,6 +39,19 @@ func DecodeAes(dst, src, key, iv []byte) {
 	cipher.NewCFBDecrypter(block, iv).XORKeyStream(dst, src)
 }
 
+// NewAesGcm returns an aes-256-gcm AEAD, the key must be 32 bytes.
+func NewAesGcm(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
 func EncodeMD5(data []byte) []byte {
 	sum := md5.Sum(data)
 	return sum[:]
@@ -21,3 +21,24 @@ func TestDeEnCode(t *testing.T) {
 	DecodeAes(dst, dst, key, iv)
 	test.Equal(src, dst)
 }
+
+func TestAesGcm(t *testing.T) {
+	defer test.New(t)
+
+	src := make([]byte, 24)
+	rand.Read(src)
+	key := make([]byte, 32)
+	rand.Read(key)
+	aead := NewAesGcm(key)
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+
+	sealed := aead.Seal(nil, nonce, src, []byte("ad"))
+	dst, err := aead.Open(nil, nonce, sealed, []byte("ad"))
+	test.Nil(err)
+	test.Equal(src, dst)
+
+	sealed[0] ^= 1
+	_, err = aead.Open(nil, nonce, sealed, []byte("ad"))
+	test.NotNil(err)
+}
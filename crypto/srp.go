@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// SRP-6a (RFC 5054) with the 2048-bit group and sha256, the server only
+// keeps the verifier, and the password never leaves the client.
+//
+//   x = H(salt + H(password)) or argon2id(password, salt)
+//   v = g^x
+//   A = g^a, B = k*v + g^b, u = H(A + B)
+//   S = (B - k*g^x)^(a+u*x) = (A*v^u)^b, K = H(S)
+//   M1 = H(A + B + K), M2 = H(A + M1 + K)
+var (
+	srpN, _ = new(big.Int).SetString(""+
+		"AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050"+
+		"A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50"+
+		"E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B8"+
+		"55F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773B"+
+		"CA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748"+
+		"544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6"+
+		"AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB6"+
+		"94B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73", 16)
+	srpG = big.NewInt(2)
+	srpK = new(big.Int).SetBytes(srpHash(srpPad(srpN), srpPad(srpG)))
+)
+
+var (
+	ErrSRPInvalidPublic = errors.New("srp: invalid public key")
+	ErrSRPInvalidProof  = errors.New("srp: invalid proof")
+)
+
+// SRPPrivate computes the x from the password, the username is not mixed
+// in, so renaming a user doesn't need to reset the password.
+func SRPPrivate(salt []byte, password string) []byte {
+	return srpHash(salt, srpHash([]byte(password)))
+}
+
+// argon2id parameters, see RFC 9106
+const (
+	Argon2Time    = 1
+	Argon2Memory  = 64 * 1024
+	Argon2Threads = 4
+)
+
+// SRPPrivateArgon2 is like SRPPrivate, but the password is hashed by
+// argon2id, which makes the offline guessing on a leaked verifier slow.
+func SRPPrivateArgon2(salt []byte, password string) []byte {
+	return argon2.IDKey([]byte(password), salt,
+		Argon2Time, Argon2Memory, Argon2Threads, 32)
+}
+
+// SRPVerifier computes the v which is stored in server instead of password.
+func SRPVerifier(x []byte) []byte {
+	return new(big.Int).Exp(srpG, new(big.Int).SetBytes(x), srpN).Bytes()
+}
+
+type SRPClient struct {
+	a  *big.Int
+	A  []byte
+	K  []byte
+	m1 []byte
+	m2 []byte
+}
+
+func NewSRPClient() *SRPClient {
+	a := srpRandom()
+	return &SRPClient{
+		a: a,
+		A: srpPad(new(big.Int).Exp(srpG, a, srpN)),
+	}
+}
+
+// Proof computes the M1 by the server's B, it should be sent to server
+// for authentication.
+func (c *SRPClient) Proof(x, B []byte) ([]byte, error) {
+	b := new(big.Int).SetBytes(B)
+	if !srpIsValid(b) {
+		return nil, ErrSRPInvalidPublic
+	}
+	u := new(big.Int).SetBytes(srpHash(c.A, srpPad(b)))
+	if u.Sign() == 0 {
+		return nil, ErrSRPInvalidPublic
+	}
+	xi := new(big.Int).SetBytes(x)
+
+	// (B - k*g^x) ^ (a + u*x)
+	base := new(big.Int).Exp(srpG, xi, srpN)
+	base.Mul(base, srpK)
+	base.Sub(b, base)
+	base.Mod(base, srpN)
+	exp := new(big.Int).Mul(u, xi)
+	exp.Add(exp, c.a)
+	S := new(big.Int).Exp(base, exp, srpN)
+
+	c.K = srpHash(srpPad(S))
+	c.m1 = srpHash(c.A, srpPad(b), c.K)
+	c.m2 = srpHash(c.A, c.m1, c.K)
+	return c.m1, nil
+}
+
+// Verify checks the M2 from server, it proves the server knows the verifier.
+func (c *SRPClient) Verify(m2 []byte) error {
+	if c.m2 == nil || subtle.ConstantTimeCompare(c.m2, m2) != 1 {
+		return ErrSRPInvalidProof
+	}
+	return nil
+}
+
+type SRPServer struct {
+	A  []byte
+	B  []byte
+	K  []byte
+	m1 []byte
+	m2 []byte
+}
+
+func NewSRPServer(verifier, A []byte) (*SRPServer, error) {
+	a := new(big.Int).SetBytes(A)
+	if !srpIsValid(a) {
+		return nil, ErrSRPInvalidPublic
+	}
+	v := new(big.Int).SetBytes(verifier)
+	b := srpRandom()
+
+	// k*v + g^b
+	B := new(big.Int).Exp(srpG, b, srpN)
+	B.Add(B, new(big.Int).Mul(srpK, v))
+	B.Mod(B, srpN)
+
+	s := &SRPServer{A: srpPad(a), B: srpPad(B)}
+	u := new(big.Int).SetBytes(srpHash(s.A, s.B))
+
+	// (A * v^u) ^ b
+	S := new(big.Int).Exp(v, u, srpN)
+	S.Mul(S, a)
+	S.Exp(S, b, srpN)
+
+	s.K = srpHash(srpPad(S))
+	s.m1 = srpHash(s.A, s.B, s.K)
+	s.m2 = srpHash(s.A, s.m1, s.K)
+	return s, nil
+}
+
+// Verify checks the M1 from client, and returns the M2 if it's matched.
+func (s *SRPServer) Verify(m1 []byte) ([]byte, error) {
+	if subtle.ConstantTimeCompare(s.m1, m1) != 1 {
+		return nil, ErrSRPInvalidProof
+	}
+	return s.m2, nil
+}
+
+func srpIsValid(n *big.Int) bool {
+	return new(big.Int).Mod(n, srpN).Sign() != 0
+}
+
+func srpRandom() *big.Int {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return new(big.Int).SetBytes(buf)
+}
+
+func srpPad(n *big.Int) []byte {
+	ret := make([]byte, (srpN.BitLen()+7)/8)
+	b := n.Bytes()
+	copy(ret[len(ret)-len(b):], b)
+	return ret
+}
+
+func srpHash(data ...[]byte) []byte {
+	h := sha256.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
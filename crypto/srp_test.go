@@ -0,0 +1,33 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/chzyer/test"
+)
+
+func TestSRP(t *testing.T) {
+	defer test.New(t)
+
+	salt := test.RandBytes(16)
+	verifier := SRPVerifier(SRPPrivate(salt, "bye"))
+
+	for _, pswd := range []string{"bye", "bye1"} {
+		cli := NewSRPClient()
+		svr, err := NewSRPServer(verifier, cli.A)
+		test.Nil(err)
+		m1, err := cli.Proof(SRPPrivate(salt, pswd), svr.B)
+		test.Nil(err)
+		m2, err := svr.Verify(m1)
+		if pswd != "bye" {
+			test.Equal(err, ErrSRPInvalidProof)
+			continue
+		}
+		test.Nil(err)
+		test.Nil(cli.Verify(m2))
+		test.Equal(cli.K, svr.K)
+	}
+
+	_, err := NewSRPServer(verifier, srpPad(srpN))
+	test.Equal(err, ErrSRPInvalidPublic)
+}
@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// NewX25519 generates an ephemeral key pair, the private key should be
+// dropped as soon as the shared secret is computed.
+func NewX25519() (private, public []byte) {
+	private = make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		panic(err)
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		panic(err)
+	}
+	return private, public
+}
+
+func X25519(private, peer []byte) ([]byte, error) {
+	return curve25519.X25519(private, peer)
+}
+
+// HKDF derives n bytes by hkdf-sha256.
+func HKDF(secret, salt, info []byte, n int) []byte {
+	ret := make([]byte, n)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), ret); err != nil {
+		panic(err)
+	}
+	return ret
+}
@@ -0,0 +1,24 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/chzyer/test"
+)
+
+func TestX25519(t *testing.T) {
+	defer test.New(t)
+
+	priv1, pub1 := NewX25519()
+	priv2, pub2 := NewX25519()
+	shared1, err := X25519(priv1, pub2)
+	test.Nil(err)
+	shared2, err := X25519(priv2, pub1)
+	test.Nil(err)
+	test.Equal(shared1, shared2)
+
+	key1 := HKDF(shared1, []byte("salt"), []byte("info"), 64)
+	key2 := HKDF(shared2, []byte("salt"), []byte("info"), 64)
+	test.Equal(key1, key2)
+	test.NotEqual(key1, HKDF(shared2, []byte("salt2"), []byte("info"), 64))
+}
@@ -14,7 +14,13 @@ type SvrDelegate interface {
 	SvrAuthDelegate
 	GetUserChannelFromDataChannel(id int) (
 		fromUser packet.RecvChan, toUser packet.SendChan, err error)
-	OnDChanUpdate([]int)
+	// GetUserUpload returns the chan which the channels of user send to
+	GetUserUpload(id int) (packet.SendChan, error)
+	GetUserLimiter(id int) *Limiter
+	// GetRekey returns the thresholds of rotating the keys of channels
+	GetRekey() packet.Rekey
+	// OnDChanUpdate is called if the listeners are changed
+	OnDChanUpdate()
 	OnNewChannel(Channel)
 }
 
@@ -24,7 +30,7 @@ type SvrInitDelegate interface {
 }
 
 type SvrAuthDelegate interface {
-	GetUserToken(id int) ([]byte, error)
+	GetUserToken(id int) ([]byte, packet.Version, error)
 }
 
 type ChannelFactory interface {
@@ -35,6 +41,17 @@ type ChannelFactory interface {
 	DialTimeout(host string, timeout time.Duration) (net.Conn, error)
 }
 
+// authListener is the factory which needs to find the keys of users in
+// listening.
+type authListener interface {
+	ListenAuth(*flow.Flow, packet.AuthDelegate) (net.Listener, error)
+}
+
+// sessionDialer is the factory which needs the session in dialing.
+type sessionDialer interface {
+	DialSession(host string, timeout time.Duration, s *packet.Session) (net.Conn, error)
+}
+
 type Channel interface {
 	Close()
 	Name() string
@@ -43,6 +60,7 @@ type Channel interface {
 	GetUserId() (int, error)
 	AddOnClose(func())
 	GetSpeed() *statistic.SpeedInfo
+	GetDrop() *statistic.DropInfo
 	ChanWrite() packet.SendChan
 	Run()
 
@@ -37,7 +37,7 @@ func BenchmarkAllGroup(b *testing.B) {
 	conn, err := cf.DialTimeout(getAddr(ln.Addr()), time.Second)
 	test.Nil(err)
 
-	session := packet.NewSessionCli(0, token)
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
 	ch := cf.NewClient(f, session, conn, fromDC.Send())
 	go ch.Run()
 	g.AddWithAutoRemove(ch)
@@ -96,7 +96,7 @@ func testFactory(f *flow.Flow, b *testing.B, cf ChannelFactory) {
 
 	conn, err := cf.DialTimeout(getAddr(ln.Addr()), time.Second)
 	test.Nil(err)
-	session := packet.NewSessionCli(0, token)
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
 	ch := packet.NewChan(0)
 	cli := cf.NewClient(f, session, conn, ch.Send())
 	go cli.Run()
@@ -109,7 +109,7 @@ func testFactoryListen(f *flow.Flow, b *testing.B, cf ChannelFactory, ln net.Lis
 	conn, err := ln.Accept()
 	test.Nil(err)
 
-	session := packet.NewSessionCli(0, token)
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
 	ch := packet.NewChan(2)
 	delegate := &dumpSvrInitDelegate{ch.Send()}
 	svr := cf.NewServer(f, session, conn, delegate)
@@ -2,6 +2,7 @@ package dchan
 
 import (
 	"fmt"
+	"net"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -11,7 +12,6 @@ import (
 	"github.com/chzyer/logex"
 	"github.com/chzyer/next/packet"
 	"github.com/chzyer/next/statistic"
-	"github.com/chzyer/next/util"
 )
 
 const (
@@ -19,12 +19,13 @@ const (
 )
 
 type Slot struct {
+	Type string
 	Host string
 	Port uint16
 }
 
 func (s Slot) String() string {
-	return fmt.Sprintf("%v:%v", s.Host, s.Port)
+	return fmt.Sprintf("%v://%v:%v", s.Type, s.Host, s.Port)
 }
 
 type ClientDelegate interface {
@@ -38,20 +39,23 @@ type Client struct {
 	session      *packet.Session
 	mutex        sync.Mutex
 	runningChans int32
-	chanFactory  ChannelFactory
+
+	// the channel type of login, the others are advertised in NEWDC_R
+	chanType  string
+	chanOpt   *ChannelOption
+	factories map[string]ChannelFactory
 
 	delegate ClientDelegate
 
-	ports       []int
-	fromDC      packet.SendChan
+	endpoints   []Endpoint
 	connectChan chan Slot
 }
 
 // out is which datachannel can write for
-// all of channel share on fromDC, and have their owned toDC
+// all of channel share on fromDC through the group, and have their owned toDC
 // client receive all packet from toDC and try to send them
 func NewClient(f *flow.Flow,
-	s *packet.Session, delegate ClientDelegate, chanTyp string,
+	s *packet.Session, delegate ClientDelegate, chanTyp string, opt *ChannelOption,
 	toDC packet.RecvChan, fromDC packet.SendChan) (*Client, error) {
 
 	if err := CheckType(chanTyp); err != nil {
@@ -61,8 +65,9 @@ func NewClient(f *flow.Flow,
 		delegate:    delegate,
 		connectChan: make(chan Slot, 1024),
 		session:     s,
-		fromDC:      fromDC,
-		chanFactory: GetChannelType(chanTyp),
+		chanType:    chanTyp,
+		chanOpt:     opt,
+		factories:   make(map[string]ChannelFactory),
 	}
 	f.ForkTo(&cli.flow, cli.Close)
 	cli.group = NewGroup(cli.flow, toDC, fromDC)
@@ -90,22 +95,42 @@ func (c *Client) Close() {
 	logex.Info("closed")
 }
 
-// AddHost will exclude endpoint which is already exists
+// AddHost adds the endpoint of login channel type.
 func (c *Client) AddHost(host string, port int) {
+	c.AddEndpoint(host, Endpoint{Port: port})
+}
+
+// AddEndpoint will exclude endpoint which is already exists, the unknown
+// channel types are ignored.
+func (c *Client) AddEndpoint(host string, ep Endpoint) {
+	if ep.Type == "" {
+		ep.Type = c.chanType
+	}
+	if err := CheckType(ep.Type); err != nil {
+		logex.Info("ignore endpoint:", err)
+		return
+	}
 	c.mutex.Lock()
-	added := util.InInts(int(port), c.ports)
+	added := false
+	for _, e := range c.endpoints {
+		if e == ep {
+			added = true
+			break
+		}
+	}
 	if !added {
-		c.ports = append(c.ports, int(port))
+		c.endpoints = append(c.endpoints, ep)
 	}
 	c.mutex.Unlock()
 	if added {
 		return
 	}
-	logex.Infof("add new endpoint: %v:%v", host, port)
+	logex.Infof("add new endpoint: %v://%v:%v", ep.Type, host, ep.Port)
 
 	slot := Slot{
+		Type: ep.Type,
 		Host: host,
-		Port: uint16(port),
+		Port: uint16(ep.Port),
 	}
 	for i := 0; i < ChanCount; i++ {
 		select {
@@ -116,8 +141,25 @@ func (c *Client) AddHost(host string, port int) {
 	}
 }
 
-func (c *Client) Ports() []int {
-	return c.ports
+func (c *Client) Endpoints() []Endpoint {
+	c.mutex.Lock()
+	ret := make([]Endpoint, len(c.endpoints))
+	copy(ret, c.endpoints)
+	c.mutex.Unlock()
+	return ret
+}
+
+// getFactory returns the factory of channel type, all of them share the
+// merged option pushed by server.
+func (c *Client) getFactory(typ string) ChannelFactory {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	factory, ok := c.factories[typ]
+	if !ok {
+		factory = NewChannelFactory(typ, c.chanOpt)
+		c.factories[typ] = factory
+	}
+	return factory
 }
 
 func (c *Client) GetRunningChans() int {
@@ -155,12 +197,19 @@ func (c *Client) onChanExit(slot Slot) {
 
 func (c *Client) MakeNewChannel(slot Slot) error {
 	host := fmt.Sprintf("%v:%v", slot.Host, slot.Port)
-	conn, err := c.chanFactory.DialTimeout(host, 2*time.Second)
+	session := c.session.Clone()
+	factory := c.getFactory(slot.Type)
+	var conn net.Conn
+	var err error
+	if sd, ok := factory.(sessionDialer); ok {
+		conn, err = sd.DialSession(host, 2*time.Second, session)
+	} else {
+		conn, err = factory.DialTimeout(host, 2*time.Second)
+	}
 	if err != nil {
 		return logex.Trace(err)
 	}
-	session := c.session.Clone()
-	ch := c.chanFactory.NewClient(c.flow, session, conn, c.fromDC)
+	ch := factory.NewClient(c.flow, session, conn, c.group.Upload())
 	ch.AddOnClose(func() {
 		c.onChanExit(slot)
 	})
@@ -169,10 +218,22 @@ func (c *Client) MakeNewChannel(slot Slot) error {
 	return nil
 }
 
+func (c *Client) SetScheduler(s Scheduler) {
+	c.group.SetScheduler(s)
+}
+
+func (c *Client) GetScheduler() Scheduler {
+	return c.group.GetScheduler()
+}
+
 func (c *Client) GetSpeedInfo() *statistic.SpeedInfo {
 	return c.group.GetSpeed()
 }
 
+func (c *Client) GetDropInfo() *statistic.DropInfo {
+	return c.group.GetDrop()
+}
+
 func (c *Client) connectLoop() {
 	c.flow.Add(1)
 	defer c.flow.DoneAndClose()
@@ -203,7 +264,7 @@ loop:
 				waitTime = time.Second
 			}
 
-			logex.Debugf("prepare to connect to %v:%v", slot.Host, slot.Port)
+			logex.Debugf("prepare to connect to %v", slot)
 			err := c.MakeNewChannel(slot)
 			if err != nil {
 				if strings.Contains(err.Error(), "connection refused") {
@@ -241,8 +302,12 @@ func (c *Client) GetStats() string {
 	return c.group.GetStatsInfo()
 }
 
-func (c *Client) UpdateRemoteAddrs(host string, ports []int) {
-	for _, p := range ports {
-		c.AddHost(host, p)
+func (c *Client) GetChannelStats() []*ChannelStat {
+	return c.group.GetChannelStats()
+}
+
+func (c *Client) UpdateRemoteAddrs(host string, eps []Endpoint) {
+	for _, ep := range eps {
+		c.AddEndpoint(host, ep)
 	}
 }
@@ -0,0 +1,158 @@
+package dchan
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/packet"
+)
+
+var ErrInvalidDatagram = logex.Define("invalid datagram")
+
+// DgramBatchSize is the max size of the packets which are batched in a
+// datagram, a larger packet is sent alone.
+var DgramBatchSize = 1200
+
+// DgramHandshakeResend is how often the HANDSHAKE is sent again until
+// the HANDSHAKE_R is received, either of them may be lost.
+var DgramHandshakeResend = 500 * time.Millisecond
+
+var _ Channel = new(DgramChan)
+
+// DgramChan sends one PacketL2 per datagram in the frame format of TcpChan.
+// A lost or reordered datagram only affects itself, the sequence number of
+// frame is checked by the replay window. The invalid datagrams are dropped
+// instead of closing the channel.
+type DgramChan struct {
+	*TcpChan
+	batchSize int
+}
+
+func NewDgramChanClient(f *flow.Flow, session *packet.Session, conn net.Conn, out packet.SendChan) Channel {
+	ch := NewTcpChanClient(f, session, conn, out).(*TcpChan)
+	ch.handshakeResend = DgramHandshakeResend
+	return &DgramChan{ch, DgramBatchSize}
+}
+
+func NewDgramChanServer(f *flow.Flow, session *packet.Session, conn net.Conn, delegate SvrInitDelegate) Channel {
+	return &DgramChan{NewTcpChanServer(f, session, conn, delegate).(*TcpChan), DgramBatchSize}
+}
+
+func (c *DgramChan) Run() {
+	go c.writeLoop(c.rawWrite)
+	go c.readLoop()
+}
+
+// rawWrite splits the packets into datagrams.
+func (c *DgramChan) rawWrite(ps []*packet.Packet) error {
+	for len(ps) > 0 {
+		n, size := 1, ps[0].TotalSize()
+		for n < len(ps) && size+ps[n].TotalSize() <= c.batchSize {
+			size += ps[n].TotalSize()
+			n++
+		}
+		if err := c.rawWriteL2(packet.WrapL2(c.session, ps[:n])); err != nil {
+			return err
+		}
+		ps = ps[n:]
+	}
+	return nil
+}
+
+// parseDatagram copies the frame out of b, the length in header must match
+// the datagram.
+func parseDatagram(b []byte) (*packet.PacketL2, error) {
+	if len(b) < packet.PacketL2HeaderSize {
+		return nil, ErrInvalidDatagram.Trace("short")
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	l2, length := decodeL2Header(data[:packet.PacketL2HeaderSize])
+	if length != len(data)-packet.PacketL2HeaderSize {
+		return nil, ErrInvalidDatagram.Trace("length not match")
+	}
+	l2.Payload = data[packet.PacketL2HeaderSize:]
+	return l2, nil
+}
+
+func (c *DgramChan) readLoop() {
+	c.flow.Add(1)
+	defer c.flow.DoneAndClose()
+
+	buf := make([]byte, 64<<10)
+loop:
+	for !c.flow.IsClosed() {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			if err, ok := err.(*net.OpError); ok {
+				if err.Temporary() || err.Timeout() {
+					continue
+				}
+			}
+			if !strings.Contains(err.Error(), "closed") {
+				c.exitError = logex.NewErrorf("read error: %v", err)
+			}
+			break
+		}
+
+		l2, err := parseDatagram(buf[:n])
+		if err != nil {
+			c.drop.Invalid()
+			continue
+		}
+		if err := l2.Verify(c.session); err != nil {
+			if logex.Equal(err, packet.ErrReplayed) {
+				c.drop.Replay()
+			} else {
+				c.drop.Invalid()
+			}
+			continue
+		}
+		ps, err := l2.Unmarshal()
+		if err != nil {
+			c.drop.Invalid()
+			continue
+		}
+
+		if c.IsSvrModeAndUninit() {
+			ps, err = serverHandshake(c.session, ps, c.rawWriteL2)
+			if err != nil {
+				c.exitError = logex.NewErrorf("handshake error: %v", err)
+				break
+			}
+			out, err := c.delegate.Init(int(l2.UserId))
+			if err != nil {
+				c.exitError = logex.NewErrorf("init error: %v", err)
+				break
+			}
+			c.markInit(out)
+			c.delegate.OnInited(c)
+		}
+
+		for _, p := range ps {
+			c.speed.Download(p.Size())
+			if p.Type == packet.HANDSHAKE {
+				c.onRepeatHandshake(p)
+				continue
+			}
+			if !c.onRecePacket(p) {
+				break loop
+			}
+		}
+	}
+}
+
+// the HANDSHAKE_R is lost and client sends the HANDSHAKE again, the
+// unexpected ones are dropped.
+func (c *DgramChan) onRepeatHandshake(p *packet.Packet) {
+	reply, err := c.session.RepeatHandshake(p)
+	if err != nil {
+		c.drop.Invalid()
+		return
+	}
+	c.rawWriteL2(reply)
+}
@@ -0,0 +1,35 @@
+package dchan
+
+import (
+	"net"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+)
+
+var _ ChannelFactory = DgramChanFactory{}
+
+// DgramChanFactory is the plain udp channel without kcp, the packets are
+// not retransmitted.
+type DgramChanFactory struct{}
+
+func (DgramChanFactory) Listen(f *flow.Flow) (net.Listener, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	return NewUDPListener(f, conn), nil
+}
+
+func (DgramChanFactory) DialTimeout(host string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("udp", host, timeout)
+}
+
+func (DgramChanFactory) NewClient(f *flow.Flow, s *packet.Session, c net.Conn, o packet.SendChan) Channel {
+	return NewDgramChanClient(f, s, c, o)
+}
+
+func (DgramChanFactory) NewServer(f *flow.Flow, s *packet.Session, c net.Conn, d SvrInitDelegate) Channel {
+	return NewDgramChanServer(f, s, c, d)
+}
@@ -0,0 +1,157 @@
+package dchan
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/test"
+)
+
+func TestParseDatagram(t *testing.T) {
+	defer test.New(t)
+
+	session := packet.NewSessionCli(1, token, packet.VersionAEAD)
+	l2 := packet.WrapL2(session, []*packet.Packet{dataPacket})
+	b := new(TcpChan).WriteL2(l2)
+
+	ret, err := parseDatagram(b)
+	test.Nil(err)
+	test.Equal(ret.Payload, l2.Payload)
+	test.Equal(ret.UserId, l2.UserId)
+
+	_, err = parseDatagram(b[:len(b)-1])
+	test.NotNil(err)
+	_, err = parseDatagram(b[:10])
+	test.NotNil(err)
+}
+
+func TestDgramChan(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+
+	cf := NewChannelFactory("dgram", nil)
+	ln, err := cf.Listen(f)
+	test.Nil(err)
+	defer ln.Close()
+
+	recv := packet.NewChan(1)
+	svrChan := make(chan Channel, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+		svr := cf.NewServer(f, session, conn, &dumpSvrInitDelegate{recv.Send()})
+		svrChan <- svr
+		go svr.Run()
+	}()
+
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+	conn, err := cf.DialTimeout(getAddr(ln.Addr()), time.Second)
+	test.Nil(err)
+	defer conn.Close()
+	cli := cf.NewClient(f, session, conn, packet.NewChan(1).Send())
+	go cli.Run()
+
+	ps := make([]*packet.Packet, 20)
+	for idx := range ps {
+		ps[idx] = dataPacket
+	}
+	test.True(cli.ChanWrite().SendSafe(f, ps))
+
+	got := 0
+	timeout := time.After(5 * time.Second)
+	for got < len(ps) {
+		select {
+		case ps := <-recv:
+			for _, p := range ps {
+				test.Equal(p.Payload(), dataPacket.Payload())
+				got++
+			}
+		case <-timeout:
+			t.Fatal("packet is not received")
+		}
+	}
+
+	// the garbage is dropped, the channel is still alive
+	svr := <-svrChan
+	_, err = conn.Write([]byte("garbage"))
+	test.Nil(err)
+	test.True(cli.ChanWrite().SendOneSafe(f, dataPacket))
+	select {
+	case <-recv:
+	case <-timeout:
+		t.Fatal("packet is not received")
+	}
+	test.Equal(svr.GetDrop().Invalid, int64(1))
+}
+
+// lossyConn drops the first n datagrams it writes.
+type lossyConn struct {
+	net.Conn
+	n int32
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	if atomic.AddInt32(&c.n, -1) >= 0 {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func TestDgramChanHandshakeLost(t *testing.T) {
+	defer test.New(t)
+
+	old := DgramHandshakeResend
+	DgramHandshakeResend = 50 * time.Millisecond
+	defer func() { DgramHandshakeResend = old }()
+
+	f := flow.New()
+	defer f.Close()
+
+	cf := NewChannelFactory("dgram", nil)
+	ln, err := cf.Listen(f)
+	test.Nil(err)
+	defer ln.Close()
+
+	recv := packet.NewChan(1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		// the HANDSHAKE_R is the first one written by server
+		session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+		svr := cf.NewServer(f, session, &lossyConn{Conn: conn, n: 1}, &dumpSvrInitDelegate{recv.Send()})
+		go svr.Run()
+	}()
+
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+	conn, err := cf.DialTimeout(getAddr(ln.Addr()), time.Second)
+	test.Nil(err)
+	defer conn.Close()
+	cli := cf.NewClient(f, session, conn, packet.NewChan(1).Send())
+	go cli.Run()
+
+	test.True(cli.ChanWrite().SendOneSafe(f, dataPacket))
+	timeout := time.After(HandshakeTimeout)
+	for {
+		select {
+		case ps := <-recv:
+			if len(ps) == 0 {
+				continue
+			}
+			test.Equal(ps[0].Payload(), dataPacket.Payload())
+			return
+		case <-timeout:
+			t.Fatal("packet is not received")
+		}
+	}
+}
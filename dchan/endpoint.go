@@ -0,0 +1,85 @@
+package dchan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Endpoint is a data channel listened by server, the Type is the login
+// channel type if it's empty.
+type Endpoint struct {
+	Type string `json:"type,omitempty"`
+	Port int    `json:"port"`
+}
+
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%v:%v", e.Type, e.Port)
+}
+
+// ParseTypes parses the channel types separated by comma, e.g. "tcp,udp",
+// the first one is used by the old clients.
+func ParseTypes(s string) ([]string, error) {
+	var ret []string
+	for _, typ := range strings.Split(s, ",") {
+		typ = strings.TrimSpace(typ)
+		if err := CheckType(typ); err != nil {
+			return nil, err
+		}
+		for _, t := range ret {
+			if t == typ {
+				return nil, fmt.Errorf("duplicated channel type: %v", typ)
+			}
+		}
+		ret = append(ret, typ)
+	}
+	return ret, nil
+}
+
+// EndpointPorts returns the ports of the channel type.
+func EndpointPorts(eps []Endpoint, typ string) []int {
+	ret := make([]int, 0, len(eps))
+	for _, ep := range eps {
+		if ep.Type == typ {
+			ret = append(ret, ep.Port)
+		}
+	}
+	return ret
+}
+
+// MergeOption merges the options of several channel types into one, the
+// fields of each type are not overlapped. It returns nil if all of them are
+// nil.
+func MergeOption(opts ...*ChannelOption) *ChannelOption {
+	var ret *ChannelOption
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if ret == nil {
+			ret = new(ChannelOption)
+		}
+		if opt.DataShards > 0 {
+			ret.DataShards, ret.ParityShards = opt.DataShards, opt.ParityShards
+		}
+		if opt.KCP != nil {
+			ret.KCP = opt.KCP
+		}
+		ret.Crypt = ret.Crypt || opt.Crypt
+		if opt.WSPath != "" {
+			ret.WSPath = opt.WSPath
+		}
+		if opt.Fingerprint != "" {
+			ret.Fingerprint = opt.Fingerprint
+		}
+		if opt.ListenAddr != "" {
+			ret.ListenAddr = opt.ListenAddr
+		}
+		if opt.URL != "" {
+			ret.URL = opt.URL
+		}
+		if opt.Cert != nil {
+			ret.Cert = opt.Cert
+		}
+	}
+	return ret
+}
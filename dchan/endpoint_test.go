@@ -0,0 +1,68 @@
+package dchan
+
+import (
+	"testing"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/test"
+)
+
+func TestParseTypes(t *testing.T) {
+	defer test.New(t)
+
+	types, err := ParseTypes("tcp")
+	test.Nil(err)
+	test.Equal(types, []string{"tcp"})
+
+	types, err = ParseTypes("tcp, udp,quic")
+	test.Nil(err)
+	test.Equal(types, []string{"tcp", "udp", "quic"})
+
+	for _, s := range []string{"", "tcp,", "tcp,foo", "tcp,udp,tcp"} {
+		_, err = ParseTypes(s)
+		test.NotNil(err)
+	}
+}
+
+func TestMergeOption(t *testing.T) {
+	defer test.New(t)
+
+	test.True(MergeOption(nil, nil) == nil)
+
+	opt := MergeOption(
+		&ChannelOption{DataShards: 10, ParityShards: 3, Crypt: true},
+		nil,
+		&ChannelOption{WSPath: "/ws"},
+		&ChannelOption{Fingerprint: "ab"},
+	)
+	test.Equal(*opt, ChannelOption{DataShards: 10, ParityShards: 3,
+		Crypt: true, WSPath: "/ws", Fingerprint: "ab"})
+}
+
+func TestClientEndpoints(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+	session := packet.NewSessionCli(1, test.RandBytes(32), packet.VersionAEAD)
+	cli, err := NewClient(f, session, nil, "tcp", &ChannelOption{Crypt: true}, nil, nil)
+	test.Nil(err)
+
+	cli.AddHost("localhost", 1)
+	cli.UpdateRemoteAddrs("localhost", []Endpoint{
+		{Port: 1},              // the login type
+		{Type: "tcp", Port: 1}, // duplicated
+		{Type: "udp", Port: 1},
+		{Type: "foo", Port: 2}, // unknown
+	})
+	test.Equal(cli.Endpoints(), []Endpoint{{Type: "tcp", Port: 1}, {Type: "udp", Port: 1}})
+
+	test.Equal(<-cli.connectChan, Slot{Type: "tcp", Host: "localhost", Port: 1})
+	test.Equal(<-cli.connectChan, Slot{Type: "udp", Host: "localhost", Port: 1})
+	test.Equal(len(cli.connectChan), 0)
+
+	_, ok := cli.getFactory("udp").(*UdpChanFactory)
+	test.True(ok)
+	test.True(cli.getFactory("udp") == cli.getFactory("udp"))
+}
@@ -10,13 +10,28 @@ func CheckType(name string) error {
 }
 
 func GetChannelType(name string) ChannelFactory {
+	return NewChannelFactory(name, nil)
+}
+
+// NewChannelFactory returns the factory of channel type, the option is
+// ignored by the types which don't support it.
+func NewChannelFactory(name string, opt *ChannelOption) ChannelFactory {
 	switch name {
 	case "http":
 		return HttpChanFactory{}
 	case "tcp":
 		return TcpChanFactory{}
 	case "udp":
-		return NewUdpChanFactory()
+		// kcp over udp, the name is kept for the old clients
+		return NewUdpChanFactory(opt)
+	case "dgram":
+		return DgramChanFactory{}
+	case "ws":
+		return NewWsChanFactory(opt)
+	case "tls":
+		return NewTlsChanFactory(opt)
+	case "quic":
+		return NewQuicChanFactory(opt)
 	default:
 		return nil
 	}
@@ -27,10 +27,15 @@ type Group struct {
 	flowIsCloseCase  reflect.SelectCase
 
 	usefulChans atomic.Value // []int
-	selectCase  []reflect.SelectCase
-
-	toDC   packet.RecvChan
-	fromDC packet.SendChan
+	chans       []Channel    // in the order of chanList
+	scheduler   atomic.Value // *Scheduler
+
+	toDC    packet.RecvChan
+	fromDC  packet.SendChan
+	upload  packet.Chan
+	dedup   dedupWindow  // owned by uploadLoop
+	reset   int32        // dedup is reset by uploadLoop if it's 1
+	limiter atomic.Value // *Limiter
 }
 
 func NewGroup(f *flow.Flow, toDC packet.RecvChan, fromDC packet.SendChan) *Group {
@@ -45,7 +50,9 @@ func NewGroup(f *flow.Flow, toDC packet.RecvChan, fromDC packet.SendChan) *Group
 
 		toDC:   toDC,
 		fromDC: fromDC,
+		upload: make(packet.Chan),
 	}
+	g.SetScheduler(newFlowScheduler())
 	f.ForkTo(&g.flow, g.Close)
 	g.flowIsCloseCase = reflect.SelectCase{
 		Dir:  reflect.SelectRecv,
@@ -65,10 +72,51 @@ loop:
 			break loop
 		}
 		logex.Debug(pkt)
+		if !s.shape(pkt, false) {
+			continue
+		}
 		s.Send(pkt)
 	}
 }
 
+// Upload returns the chan for the channels to send the packets they
+// receive, the duplicated ones are dropped and the others are shaped by the
+// limiter of group.
+func (g *Group) Upload() packet.SendChan {
+	return g.upload.Send()
+}
+
+// ResetDedup forgets the sequence numbers of the redundant copies, it's
+// called when the remote is restarted, its sequence starts again.
+func (g *Group) ResetDedup() {
+	atomic.StoreInt32(&g.reset, 1)
+}
+
+func (g *Group) uploadLoop() {
+	g.flow.Add(1)
+	defer g.flow.DoneAndClose()
+
+	upload := g.upload.Recv()
+	for !g.flow.IsClosed() {
+		pkt := upload.RecvAll(g.flow)
+		if pkt == nil {
+			break
+		}
+		if atomic.CompareAndSwapInt32(&g.reset, 1, 0) {
+			g.dedup = dedupWindow{}
+		}
+		if pkt = g.dedup.filter(pkt); len(pkt) == 0 {
+			continue
+		}
+		if !g.shape(pkt, true) {
+			continue
+		}
+		if !g.fromDC.SendSafe(g.flow, pkt) {
+			break
+		}
+	}
+}
+
 func (g *Group) findChannel(f func(Channel) bool) Channel {
 	var ret Channel
 	g.chanListGuard.RLock()
@@ -93,6 +141,19 @@ func (g *Group) CloseChannel(name string) error {
 	return nil
 }
 
+// CloseAllChannel closes all channels in group, they are removed by
+// themselves.
+func (g *Group) CloseAllChannel() {
+	var chans []Channel
+	g.findChannel(func(c Channel) bool {
+		chans = append(chans, c)
+		return false
+	})
+	for _, ch := range chans {
+		ch.Close()
+	}
+}
+
 func (g *Group) ChannelCount() int {
 	g.chanListGuard.RLock()
 	count := g.chanList.Len()
@@ -116,34 +177,87 @@ func (g *Group) GetUsefulChan() []Channel {
 	return ret
 }
 
-func (g *Group) GetStatsInfo() string {
-	buf := bytes.NewBuffer(nil)
-	useful := g.GetUseful()
-	isInUseful := func(idx int) bool {
-		for _, a := range useful {
-			if idx == a {
-				return true
-			}
-		}
-		return false
+// ChannelStat is the state of a channel in group.
+type ChannelStat struct {
+	Name string `json:"name"`
+	statistic.HeartBeatSummary
+	ReplayDrops  int64    `json:"replay_drops"`
+	InvalidDrops int64    `json:"invalid_drops"`
+	Flows        int      `json:"flows"` // pinned by the flow scheduler
+	FEC          *FECStat `json:"fec,omitempty"`
+	Useful       bool     `json:"useful"`
+}
+
+func (c *ChannelStat) String() string {
+	dropped := ""
+	if c.ReplayDrops > 0 {
+		dropped = fmt.Sprintf(", RD: %v", c.ReplayDrops)
+	}
+	if c.InvalidDrops > 0 {
+		dropped += fmt.Sprintf(", ID: %v", c.InvalidDrops)
+	}
+	if c.FEC != nil {
+		dropped += ", " + c.FEC.String()
 	}
+	if c.Flows > 0 {
+		dropped += fmt.Sprintf(", F: %v", c.Flows)
+	}
+	useful := ""
+	if c.Useful {
+		useful = " [*]"
+	}
+	return fmt.Sprintf("%v: %v%v%v", c.Name, c.HeartBeatSummary, dropped, useful)
+}
+
+func (g *Group) GetChannelStats() []*ChannelStat {
+	useful := g.GetUseful()
+	flows, _ := g.GetScheduler().(flowCounter)
+	var ret []*ChannelStat
 	idx := 0
 	g.findChannel(func(ch Channel) bool {
-		isuseful := ""
-		if isInUseful(idx) {
-			isuseful = " [*]"
+		drop := ch.GetDrop()
+		stat := &ChannelStat{
+			Name:             ch.Name(),
+			HeartBeatSummary: ch.GetStat().Summary(),
+			ReplayDrops:      drop.Replay,
+			InvalidDrops:     drop.Invalid,
+			Useful:           util.InInts(idx, useful),
+		}
+		if fec, ok := ch.(fecChannel); ok {
+			stat.FEC = fec.GetFEC()
 		}
-		buf.WriteString(fmt.Sprintf("%v: %v%v\n",
-			ch.Name(), ch.GetStat().String(), isuseful,
-		))
+		if flows != nil {
+			stat.Flows = flows.FlowCount(ch)
+		}
+		ret = append(ret, stat)
 		idx++
 		return false
 	})
+	return ret
+}
+
+func (g *Group) GetStatsInfo() string {
+	return FormatChannelStats(g.GetChannelStats())
+}
+
+// FormatChannelStats shows a stat in each line, the FEC counters are shown
+// once in the end if any of channels has FEC.
+func FormatChannelStats(stats []*ChannelStat) string {
+	buf := bytes.NewBuffer(nil)
+	hasFEC := false
+	for _, stat := range stats {
+		buf.WriteString(stat.String() + "\n")
+		hasFEC = hasFEC || stat.FEC != nil
+	}
+	if hasFEC {
+		buf.WriteString(GetFECCounters().String() + "\n")
+	}
 	return buf.String()
 }
 
 func (g *Group) Run() {
 	go g.sendLoop()
+	go g.uploadLoop()
 	go g.loop()
 }
 
@@ -180,6 +294,7 @@ func (g *Group) findUsefulLocked() []int {
 		ch := elem.Value.(Channel)
 		latency, lastCommit := ch.Latency()
 		if lastCommit >= 2*time.Second {
+			idx++
 			continue
 		}
 		infos = append(infos, &latencies{
@@ -217,13 +332,29 @@ func (g *Group) updateUsefulLocked() {
 	g.usefulChans.Store(useful)
 	// notify
 	if !util.EqualInts(useful, old) {
-		select {
-		case g.onNewUsefulChan <- struct{}{}:
-		default:
-		}
+		g.notifyUseful()
 	}
 }
 
+// notifyUseful lets Send schedule the packets again.
+func (g *Group) notifyUseful() {
+	select {
+	case g.onNewUsefulChan <- struct{}{}:
+	default:
+	}
+}
+
+// SetScheduler replaces the scheduler of Send.
+func (g *Group) SetScheduler(s Scheduler) {
+	// the concrete types are different
+	g.scheduler.Store(&s)
+	g.notifyUseful()
+}
+
+func (g *Group) GetScheduler() Scheduler {
+	return *g.scheduler.Load().(*Scheduler)
+}
+
 func (g *Group) GetUseful() []int {
 	useful := g.usefulChans.Load()
 	if useful == nil {
@@ -233,33 +364,86 @@ func (g *Group) GetUseful() []int {
 }
 
 func (g *Group) Send(p []*packet.Packet) {
-	pv := reflect.ValueOf(p)
 resend:
 	g.chanListGuard.RLock()
 	usefulChans := g.GetUseful()
-	selectCase := make([]reflect.SelectCase, len(usefulChans)+2)
-	for caseIdx, chanIdx := range usefulChans {
-		selectCase[caseIdx] = g.selectCase[chanIdx]
-		selectCase[caseIdx].Send = pv
+	chans := make([]Channel, len(usefulChans))
+	for idx, chanIdx := range usefulChans {
+		chans[idx] = g.chans[chanIdx]
 	}
 	g.chanListGuard.RUnlock()
 
+	var batches [][]*packet.Packet
+	if len(chans) > 0 {
+		batches = g.GetScheduler().Schedule(chans, p)
+	}
+	if batches == nil {
+		if g.sendReady(chans, p) {
+			goto resend
+		}
+		return
+	}
+
+	for idx, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		select {
+		case chans[idx].ChanWrite() <- batch:
+		case <-g.flow.IsClose():
+			return
+		case <-g.onNewUsefulChan:
+			p = unsentPackets(p, batches[:idx])
+			goto resend
+		}
+	}
+}
+
+// unsentPackets returns the packets which are not in sent, in the order
+// of p.
+func unsentPackets(p []*packet.Packet, sent [][]*packet.Packet) []*packet.Packet {
+	done := make(map[*packet.Packet]bool)
+	for _, batch := range sent {
+		for _, pkt := range batch {
+			done[pkt] = true
+		}
+	}
+	ret := make([]*packet.Packet, 0, len(p))
+	for _, pkt := range p {
+		if !done[pkt] {
+			ret = append(ret, pkt)
+		}
+	}
+	return ret
+}
+
+// sendReady sends the packets to whichever of chans is ready first, it
+// returns true if the useful channels are changed before that.
+func (g *Group) sendReady(chans []Channel, p []*packet.Packet) bool {
+	pv := reflect.ValueOf(p)
+	selectCase := make([]reflect.SelectCase, len(chans)+2)
+	for idx, ch := range chans {
+		selectCase[idx] = reflect.SelectCase{
+			Dir:  reflect.SelectSend,
+			Chan: reflect.ValueOf(ch.ChanWrite()),
+			Send: pv,
+		}
+	}
+
 	// case <-g.flow.IsClosed()
 	selectCase[len(selectCase)-2] = g.flowIsCloseCase
 	// case <-g.onNewUsefulChan:  notify if we got a new chose
 	selectCase[len(selectCase)-1] = g.onNewUsefullCase
 	// TODO: how about all of this is fail?
 	chosen, _, _ := reflect.Select(selectCase)
-	if chosen == len(selectCase)-1 {
-		goto resend
-	}
+	return chosen == len(selectCase)-1
 }
 
 func (g *Group) AddWithAutoRemove(c Channel) {
 	logex.Info("new channel:", c.Name())
 	g.chanListGuard.Lock()
 	elem := g.chanList.PushFront(c)
-	g.makeSelectCaseLocked()
+	g.makeChansLocked()
 	g.updateUsefulLocked()
 	g.chanListGuard.Unlock()
 
@@ -267,9 +451,11 @@ func (g *Group) AddWithAutoRemove(c Channel) {
 		logex.Info("remove channel:", c.Name())
 		g.chanListGuard.Lock()
 		g.chanList.Remove(elem)
-		g.makeSelectCaseLocked()
+		g.makeChansLocked()
 		g.updateUsefulLocked()
 		g.chanListGuard.Unlock()
+		// the packets may wait for it in Send
+		g.notifyUseful()
 	})
 
 }
@@ -283,15 +469,19 @@ func (g *Group) GetSpeed() *statistic.SpeedInfo {
 	return &s
 }
 
-func (g *Group) makeSelectCaseLocked() {
-	g.selectCase = make([]reflect.SelectCase, g.chanList.Len())
-	idx := 0
+func (g *Group) GetDrop() *statistic.DropInfo {
+	var d statistic.DropInfo
+	g.findChannel(func(ch Channel) bool {
+		d.Merge(ch.GetDrop())
+		return false
+	})
+	return &d
+}
+
+func (g *Group) makeChansLocked() {
+	g.chans = make([]Channel, 0, g.chanList.Len())
 	for elem := g.chanList.Front(); elem != nil; elem = elem.Next() {
-		g.selectCase[idx] = reflect.SelectCase{
-			Dir:  reflect.SelectSend,
-			Chan: reflect.ValueOf(elem.Value.(Channel).ChanWrite()),
-		}
-		idx++
+		g.chans = append(g.chans, elem.Value.(Channel))
 	}
 }
 
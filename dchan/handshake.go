@@ -0,0 +1,62 @@
+package dchan
+
+import (
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+)
+
+var HandshakeTimeout = 5 * time.Second
+
+// sending the HANDSHAKE which is prepared by client, and waiting for the
+// readLoop receives the reply, nothing else can be sent before that.
+// It's sent again in every resend if it's positive.
+func clientHandshake(f *flow.Flow, p *packet.Packet,
+	write func([]*packet.Packet) error, done <-chan struct{},
+	resend time.Duration) error {
+
+	if p == nil {
+		return nil
+	}
+	timeout := time.After(HandshakeTimeout)
+	for {
+		if err := write([]*packet.Packet{p}); err != nil {
+			return err
+		}
+		var retry <-chan time.Time
+		if resend > 0 {
+			retry = time.After(resend)
+		}
+		select {
+		case <-done:
+			return nil
+		case <-retry:
+		case <-timeout:
+			return packet.ErrHandshake.Format("timeout")
+		case <-f.IsClose():
+			return nil
+		}
+	}
+}
+
+// the first frame of a data channel in server side must be the HANDSHAKE,
+// the reply is written before the traffic keys are switched.
+func serverHandshake(s *packet.Session, ps []*packet.Packet,
+	write func(*packet.PacketL2) error) ([]*packet.Packet, error) {
+
+	if !s.NeedHandshake() {
+		return ps, nil
+	}
+	if len(ps) == 0 {
+		return nil, packet.ErrHandshake.Format("empty frame")
+	}
+	reply, err := s.AcceptHandshake(ps[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := write(reply); err != nil {
+		return nil, err
+	}
+	return ps[1:], nil
+}
@@ -27,8 +27,12 @@ type HttpChan struct {
 	delegate     SvrInitDelegate
 	waitInitChan chan struct{}
 
+	handshake     *packet.Packet // client only
+	handshakeDone chan struct{}
+
 	heartBeat *statistic.HeartBeatStage
 	speed     *statistic.Speed
+	drop      *statistic.Drop
 
 	exitError error
 
@@ -38,6 +42,9 @@ type HttpChan struct {
 
 func NewHttpChanClient(f *flow.Flow, session *packet.Session, conn net.Conn, out packet.SendChan) *HttpChan {
 	hc := NewHttpChanServer(f, session, conn, nil)
+	if session.NeedHandshake() {
+		hc.handshake = session.StartHandshake()
+	}
 	hc.markInit(out)
 	return hc
 }
@@ -49,7 +56,10 @@ func NewHttpChanServer(f *flow.Flow, s *packet.Session, conn net.Conn, delegate
 		session:      s,
 		waitInitChan: make(chan struct{}, 1),
 
+		handshakeDone: make(chan struct{}),
+
 		speed: statistic.NewSpeed(),
+		drop:  statistic.NewDrop(),
 		in:    packet.NewChan(4),
 	}
 	if tcpConn, ok := conn.(*net.TCPConn); ok {
@@ -69,6 +79,10 @@ func (h *HttpChan) IsSvrModeAndUninit() bool {
 	return h.out == nil
 }
 
+func (h *HttpChan) GetDrop() *statistic.DropInfo {
+	return h.drop.GetDrop()
+}
+
 func (h *HttpChan) GetSpeed() *statistic.SpeedInfo {
 	return h.speed.GetSpeed()
 }
@@ -84,7 +98,10 @@ func (h *HttpChan) Run() {
 }
 
 func (h *HttpChan) rawWrite(p []*packet.Packet) error {
-	l2 := packet.WrapL2(h.session, p)
+	return h.rawWriteL2(packet.WrapL2(h.session, p))
+}
+
+func (h *HttpChan) rawWriteL2(l2 *packet.PacketL2) error {
 	n, err := h.conn.Write(h.WriteL2(l2))
 	h.speed.Upload(n)
 	return err
@@ -98,6 +115,11 @@ func (h *HttpChan) writeLoop() {
 		return
 	}
 
+	if err := clientHandshake(h.flow, h.handshake, h.rawWrite, h.handshakeDone, 0); err != nil {
+		h.exitError = logex.NewErrorf("handshake error: %v", err)
+		return
+	}
+
 	heartBeatTicker := time.NewTicker(1 * time.Second)
 	defer heartBeatTicker.Stop()
 
@@ -145,11 +167,26 @@ loop:
 		}
 
 		if err := l2.Verify(h.session); err != nil {
+			if logex.Equal(err, packet.ErrReplayed) {
+				h.drop.Replay()
+				continue
+			}
 			h.exitError = logex.NewErrorf("verify error: %v", err)
 			break
 		}
 
+		ps, err := l2.Unmarshal()
+		if err != nil {
+			h.exitError = logex.NewErrorf("client error: %v", err)
+			break
+		}
+
 		if h.IsSvrModeAndUninit() {
+			ps, err = serverHandshake(h.session, ps, h.rawWriteL2)
+			if err != nil {
+				h.exitError = logex.NewErrorf("handshake error: %v", err)
+				break
+			}
 			out, err := h.delegate.Init(int(l2.UserId))
 			if err != nil {
 				h.exitError = logex.NewErrorf("init error: %v", err)
@@ -158,12 +195,6 @@ loop:
 			h.markInit(out)
 			h.delegate.OnInited(h)
 		}
-
-		ps, err := l2.Unmarshal()
-		if err != nil {
-			h.exitError = logex.NewErrorf("client error: %v", err)
-			break
-		}
 		if !h.onRecePacket(ps) {
 			break loop
 		}
@@ -181,6 +212,15 @@ func (h *HttpChan) onRecePacket(ps []*packet.Packet) bool {
 			}
 		case packet.HEARTBEAT_R:
 			h.heartBeat.Receive(p)
+		case packet.HANDSHAKE_R:
+			if err := h.session.FinishHandshake(p); err != nil {
+				h.exitError = logex.NewErrorf("handshake error: %v", err)
+				return false
+			}
+			close(h.handshakeDone)
+		case packet.HANDSHAKE:
+			h.exitError = logex.NewErrorf("unexpected handshake")
+			return false
 		default:
 			buffer = append(buffer, p)
 		}
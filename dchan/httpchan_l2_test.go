@@ -14,7 +14,7 @@ import (
 func TestHttpChanL2(t *testing.T) {
 	defer test.New(t)
 	token := util.RandStr(32)
-	session := packet.NewSessionCli(0, []byte(token))
+	session := packet.NewSessionCli(0, []byte(token), packet.VersionAEAD)
 	p := packet.New([]byte(util.RandStr(24)), packet.DATA)
 	l2 := packet.WrapL2(session, []*packet.Packet{p})
 
@@ -34,7 +34,7 @@ func TestHttpChanL2(t *testing.T) {
 func BenchmarkHttpChanL2(b *testing.B) {
 	defer test.New(b)
 	token := util.RandStr(32)
-	session := packet.NewSessionCli(0, []byte(token))
+	session := packet.NewSessionCli(0, []byte(token), packet.VersionAEAD)
 	p := packet.New([]byte(util.RandStr(24)), packet.DATA)
 	packets := make([]*packet.Packet, 20)
 	for idx := range packets {
@@ -55,21 +55,19 @@ func BenchmarkHttpChanL2(b *testing.B) {
 func BenchmarkHttpChanReadL2(b *testing.B) {
 	defer test.New(b)
 	token := util.RandStr(32)
-	session := packet.NewSessionCli(0, []byte(token))
+	session := packet.NewSessionCli(0, []byte(token), packet.VersionAEAD)
 	p := packet.New([]byte(util.RandStr(24)), packet.DATA)
 	packets := make([]*packet.Packet, 20)
 	for idx := range packets {
 		packets[idx] = p
 	}
 
-	l2 := packet.WrapL2(session, packets)
-
 	hc := new(HttpChan)
 	buf := bytes.NewBuffer(nil)
 
 	r := bufio.NewReader(buf)
 	for i := 0; i < b.N; i++ {
-		buf.Write(hc.WriteL2(l2))
+		buf.Write(hc.WriteL2(packet.WrapL2(session, packets)))
 	}
 
 	b.ResetTimer()
@@ -90,20 +88,18 @@ func BenchmarkHttpChanReadL2(b *testing.B) {
 func BenchmarkTcpChanReadL2(b *testing.B) {
 	defer test.New(b)
 	token := util.RandStr(32)
-	session := packet.NewSessionCli(0, []byte(token))
+	session := packet.NewSessionCli(0, []byte(token), packet.VersionAEAD)
 	p := packet.New([]byte(util.RandStr(24)), packet.DATA)
 	packets := make([]*packet.Packet, 20)
 	for idx := range packets {
 		packets[idx] = p
 	}
-	l2 := packet.WrapL2(session, packets)
-
 	hc := new(TcpChan)
 	buf := bytes.NewBuffer(nil)
 
 	r := bufio.NewReader(buf)
 	for i := 0; i < b.N; i++ {
-		buf.Write(hc.WriteL2(l2))
+		buf.Write(hc.WriteL2(packet.WrapL2(session, packets)))
 	}
 
 	b.ResetTimer()
@@ -0,0 +1,79 @@
+package dchan
+
+import (
+	"time"
+
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/next/util"
+)
+
+// Quota accounts the traffic of user.
+type Quota interface {
+	// Consume returns false if the quota runs out, the packets are dropped.
+	Consume(n int) bool
+}
+
+// Meter counts the traffic of user.
+type Meter interface {
+	Count(upload bool, bytes, packets int)
+}
+
+// Limiter shapes and counts the traffic of user in Group, the nil fields
+// mean unlimited.
+type Limiter struct {
+	Upload   *util.TokenBucket
+	Download *util.TokenBucket
+	Quota    Quota
+	Meter    Meter
+}
+
+func packetsSize(ps []*packet.Packet) int {
+	n := 0
+	for _, p := range ps {
+		n += len(p.Payload())
+	}
+	return n
+}
+
+// SetLimiter replaces the limiter, nil for unlimited.
+func (g *Group) SetLimiter(l *Limiter) {
+	g.limiter.Store(l)
+}
+
+func (g *Group) getLimiter() *Limiter {
+	l, _ := g.limiter.Load().(*Limiter)
+	return l
+}
+
+// shape waits for the tokens of bucket and consumes the quota, returns false
+// if the packets should be dropped.
+func (g *Group) shape(ps []*packet.Packet, upload bool) bool {
+	l := g.getLimiter()
+	if l == nil {
+		return true
+	}
+	n := packetsSize(ps)
+	if l.Quota != nil && !l.Quota.Consume(n) {
+		return false
+	}
+	if l.Meter != nil {
+		l.Meter.Count(upload, n, len(ps))
+	}
+	bucket := l.Download
+	if upload {
+		bucket = l.Upload
+	}
+	if bucket == nil {
+		return true
+	}
+	if wait := bucket.Take(n); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-g.flow.IsClose():
+			return false
+		}
+	}
+	return true
+}
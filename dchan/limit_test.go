@@ -0,0 +1,60 @@
+package dchan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/next/util"
+	"github.com/chzyer/test"
+)
+
+type testQuota struct {
+	left int
+}
+
+func (q *testQuota) Consume(n int) bool {
+	if q.left <= 0 {
+		return false
+	}
+	q.left -= n
+	return true
+}
+
+func TestGroupLimiter(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+	toDC := packet.NewChan(0)
+	fromDC := packet.NewChan(0)
+	g := NewGroup(f, toDC.Recv(), fromDC.Send())
+	g.Run()
+
+	// unlimited
+	p := []*packet.Packet{packet.New(make([]byte, 100), packet.DATA)}
+	test.True(g.Upload().SendSafe(f, p))
+	test.Equal(len(fromDC.Recv().RecvAll(f)), 1)
+
+	g.SetLimiter(&Limiter{
+		Upload: util.NewTokenBucket(1000),
+		Quota:  &testQuota{left: 1200},
+	})
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		p := []*packet.Packet{packet.New(make([]byte, 600), packet.DATA)}
+		test.True(g.Upload().SendSafe(f, p))
+		test.Equal(len(fromDC.Recv().RecvAll(f)), 1)
+	}
+	test.True(time.Since(start) >= 150*time.Millisecond)
+
+	// the quota runs out, the packets are dropped
+	test.True(g.Upload().SendSafe(f, p))
+	test.True(g.Upload().SendSafe(f, p))
+	select {
+	case <-fromDC:
+		t.Fatal("packet is not dropped")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -21,7 +21,13 @@ type Listener struct {
 }
 
 func NewListener(f *flow.Flow, d SvrDelegate, chanFactory ChannelFactory, c func()) (*Listener, error) {
-	ln, err := chanFactory.Listen(f)
+	var ln net.Listener
+	var err error
+	if al, ok := chanFactory.(authListener); ok {
+		ln, err = al.ListenAuth(f, d)
+	} else {
+		ln, err = chanFactory.Listen(f)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -53,7 +59,7 @@ type listenerDelegate struct {
 }
 
 func (d *listenerDelegate) Init(userId int) (toUser packet.SendChan, err error) {
-	_, toUser, err = d.delegate.GetUserChannelFromDataChannel(userId)
+	toUser, err = d.delegate.GetUserUpload(userId)
 	if err != nil {
 		return toUser, err
 	}
@@ -71,6 +77,7 @@ func (d *Listener) Accept() (Channel, error) {
 	}
 
 	session := packet.NewSessionSvr(d.delegate)
+	session.SetRekey(d.delegate.GetRekey())
 	delegate := &listenerDelegate{d.delegate}
 	ch := d.chanFactory.NewServer(d.flow, session, conn, delegate)
 	return ch, nil
@@ -22,13 +22,13 @@ type ListenerGroup struct {
 }
 
 // server communicate with channel
-func NewListenerGroup(f *flow.Flow, chanType string, delegate SvrDelegate) *ListenerGroup {
+func NewListenerGroup(f *flow.Flow, chanType string, opt *ChannelOption, delegate SvrDelegate) *ListenerGroup {
 	s := &ListenerGroup{
 		delegate:       delegate,
 		listeners:      list.New(),
 		onListenerExit: make(chan struct{}, 1),
 		chanType:       chanType,
-		chanFactory:    GetChannelType(chanType),
+		chanFactory:    NewChannelFactory(chanType, opt),
 	}
 	f.ForkTo(&s.flow, s.Close)
 	return s
@@ -50,7 +50,7 @@ loop:
 		if s.running.Val() < s.listenerCnt.Val() {
 			s.running.Add(1)
 			s.addNewListener()
-			s.delegate.OnDChanUpdate(s.GetAllDataChannel())
+			s.delegate.OnDChanUpdate()
 		} else {
 			select {
 			case <-s.onListenerExit:
@@ -131,3 +131,13 @@ func (s *ListenerGroup) GetAllDataChannel() []int {
 	s.mutex.RUnlock()
 	return ret
 }
+
+// GetEndpoints returns the listening ports with the channel type.
+func (s *ListenerGroup) GetEndpoints() []Endpoint {
+	ports := s.GetAllDataChannel()
+	ret := make([]Endpoint, len(ports))
+	for idx, port := range ports {
+		ret[idx] = Endpoint{Type: s.chanType, Port: port}
+	}
+	return ret
+}
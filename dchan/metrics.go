@@ -0,0 +1,50 @@
+package dchan
+
+import (
+	"github.com/chzyer/next/metrics"
+)
+
+// WriteMetrics writes the metrics of channels in group, the labels are added
+// to each sample.
+func (g *Group) WriteMetrics(w *metrics.Writer, labels ...string) {
+	w.Gauge("next_dchan_useful", "count of channels which are chosen to send",
+		float64(len(g.GetUseful())), labels...)
+	w.Gauge("next_dchan_channels", "count of channels",
+		float64(g.ChannelCount()), labels...)
+
+	g.findChannel(func(ch Channel) bool {
+		chLabels := metrics.With(labels, "channel", ch.Name())
+		latency, _ := ch.Latency()
+		drop := ch.GetDrop()
+		w.Gauge("next_dchan_rtt_seconds", "heartbeat rtt of channel",
+			latency.Seconds(), chLabels...)
+		w.Counter("next_dchan_drops_total", "frames dropped by channel",
+			float64(drop.Replay), metrics.With(chLabels, "reason", "replay")...)
+		w.Counter("next_dchan_drops_total", "frames dropped by channel",
+			float64(drop.Invalid), metrics.With(chLabels, "reason", "invalid")...)
+		speed := ch.GetSpeed()
+		w.Counter("next_dchan_bytes_total", "bytes transferred by channel",
+			float64(speed.TotalUpload), metrics.With(chLabels, "direction", "up")...)
+		w.Counter("next_dchan_bytes_total", "bytes transferred by channel",
+			float64(speed.TotalDownload), metrics.With(chLabels, "direction", "down")...)
+		return false
+	})
+}
+
+// WriteMetrics writes the metrics of all groups, labeled by userId.
+func (s *Server) WriteMetrics(w *metrics.Writer, userName func(userId int) string) {
+	s.m.RLock()
+	groups := make(map[int]*Group, len(s.group))
+	for userId, group := range s.group {
+		groups[userId] = group
+	}
+	s.m.RUnlock()
+
+	for userId, group := range groups {
+		group.WriteMetrics(w, "user", userName(userId))
+	}
+}
+
+func (c *Client) WriteMetrics(w *metrics.Writer) {
+	c.group.WriteMetrics(w)
+}
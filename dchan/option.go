@@ -0,0 +1,140 @@
+package dchan
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChannelOption tunes the channel factory, it's chosen by server and pushed
+// to the clients in login, so both sides are matched.
+type ChannelOption struct {
+	// forward error correction of udp channel, disabled if DataShards is 0
+	DataShards   int `json:"datashards,omitempty"`
+	ParityShards int `json:"parityshards,omitempty"`
+
+	// the library defaults are used if it's nil
+	KCP *KCPOption `json:"kcp,omitempty"`
+
+	// encrypt the kcp session by the session token, see udpchan_crypt.go
+	Crypt bool `json:"crypt,omitempty"`
+
+	// the http path of ws channel, "/" if it's empty
+	WSPath string `json:"wspath,omitempty"`
+
+	// the sha256 of server cert which the tls channel is pinned to
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// not pushed to the clients
+	ListenAddr string           `json:"-"` // listen a fixed address in server
+	URL        string           `json:"-"` // dial the url in client instead of the host
+	Cert       *tls.Certificate `json:"-"` // cert of tls and quic channel in server
+}
+
+// KCPOption is the tuning of kcp session in udp channel, the zero fields
+// keep the library defaults.
+type KCPOption struct {
+	NoDelay      int `json:"nodelay"`
+	Interval     int `json:"interval"` // ms
+	Resend       int `json:"resend"`
+	NoCongestion int `json:"nc"`
+	SndWnd       int `json:"sndwnd,omitempty"`
+	RcvWnd       int `json:"rcvwnd,omitempty"`
+	MTU          int `json:"mtu,omitempty"`
+}
+
+var KCPProfiles = map[string]KCPOption{
+	"normal": {NoDelay: 0, Interval: 40, Resend: 2, NoCongestion: 1,
+		SndWnd: 128, RcvWnd: 512, MTU: 1350},
+	// less latency, more retransmission
+	"fast": {NoDelay: 1, Interval: 20, Resend: 2, NoCongestion: 1,
+		SndWnd: 1024, RcvWnd: 1024, MTU: 1350},
+}
+
+// ParseKCP returns the kcp tuning by a profile in KCPProfiles, or a custom
+// one like "nodelay,interval,resend,nc", the window ("snd,rcv") and the mtu
+// override the ones of profile. It returns nil if all of them are empty.
+func ParseKCP(profile, wnd string, mtu int) (*KCPOption, error) {
+	if profile == "" && wnd == "" && mtu == 0 {
+		return nil, nil
+	}
+	var opt KCPOption
+	if profile != "" {
+		if p, ok := KCPProfiles[profile]; ok {
+			opt = p
+		} else {
+			vals, err := parseInts(profile, 4)
+			if err == nil && vals[1] == 0 {
+				err = fmt.Errorf("interval is 0")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid kcp profile: %v, expect normal, fast or nodelay,interval,resend,nc", profile)
+			}
+			opt.NoDelay, opt.Interval, opt.Resend, opt.NoCongestion = vals[0], vals[1], vals[2], vals[3]
+		}
+	}
+	if wnd != "" {
+		vals, err := parseInts(wnd, 2)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kcp window: %v, expect snd,rcv", wnd)
+		}
+		opt.SndWnd, opt.RcvWnd = vals[0], vals[1]
+	}
+	if mtu != 0 {
+		if mtu < 50 || mtu > 1500 {
+			return nil, fmt.Errorf("invalid kcp mtu: %v", mtu)
+		}
+		opt.MTU = mtu
+	}
+	return &opt, nil
+}
+
+// parseInts parses n non-negative integers separated by comma.
+func parseInts(s string, n int) ([]int, error) {
+	sp := strings.Split(s, ",")
+	if len(sp) != n {
+		return nil, fmt.Errorf("expect %v numbers", n)
+	}
+	ret := make([]int, n)
+	for idx, v := range sp {
+		val, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		if val < 0 {
+			return nil, fmt.Errorf("negative number: %v", val)
+		}
+		ret[idx] = val
+	}
+	return ret, nil
+}
+
+// ParseFEC parses the shards like "10,3", which means 3 parity shards for
+// every 10 data shards, empty means disabled.
+func ParseFEC(s string) (data, parity int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	vals, err := parseInts(s, 2)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid fec: %v, expect data,parity", s)
+	}
+	data, parity = vals[0], vals[1]
+	if data <= 0 || parity <= 0 || data+parity > 255 {
+		return 0, 0, fmt.Errorf("invalid fec: %v", s)
+	}
+	return data, parity, nil
+}
+
+func (o *ChannelOption) HasFEC() bool {
+	return o != nil && o.DataShards > 0 && o.ParityShards > 0
+}
+
+// FECOverhead is the ratio of parity shards to the data shards.
+func (o *ChannelOption) FECOverhead() float64 {
+	if !o.HasFEC() {
+		return 0
+	}
+	return float64(o.ParityShards) / float64(o.DataShards)
+}
@@ -0,0 +1,128 @@
+package dchan
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/test"
+)
+
+func TestParseFEC(t *testing.T) {
+	defer test.New(t)
+
+	data, parity, err := ParseFEC("")
+	test.Nil(err)
+	test.Equal(data, 0)
+	test.Equal(parity, 0)
+
+	data, parity, err = ParseFEC("10, 3")
+	test.Nil(err)
+	test.Equal(data, 10)
+	test.Equal(parity, 3)
+
+	for _, s := range []string{"10", "a,3", "0,3", "10,0", "200,100"} {
+		_, _, err = ParseFEC(s)
+		test.NotNil(err)
+	}
+}
+
+func TestParseKCP(t *testing.T) {
+	defer test.New(t)
+
+	opt, err := ParseKCP("", "", 0)
+	test.Nil(err)
+	test.True(opt == nil)
+
+	opt, err = ParseKCP("fast", "", 0)
+	test.Nil(err)
+	test.Equal(*opt, KCPProfiles["fast"])
+
+	opt, err = ParseKCP("normal", "256, 2048", 1200)
+	test.Nil(err)
+	test.Equal(opt.Interval, 40)
+	test.Equal(opt.SndWnd, 256)
+	test.Equal(opt.RcvWnd, 2048)
+	test.Equal(opt.MTU, 1200)
+
+	opt, err = ParseKCP("1,10,2,1", "", 0)
+	test.Nil(err)
+	test.Equal(*opt, KCPOption{NoDelay: 1, Interval: 10, Resend: 2, NoCongestion: 1})
+
+	// only the window is tuned
+	opt, err = ParseKCP("", "512,512", 0)
+	test.Nil(err)
+	test.Equal(*opt, KCPOption{SndWnd: 512, RcvWnd: 512})
+
+	for _, c := range [][2]string{{"slow", ""}, {"1,10,2", ""}, {"1,0,2,1", ""}, {"", "512"}, {"fast", "a,1"}} {
+		_, err = ParseKCP(c[0], c[1], 0)
+		test.NotNil(err)
+	}
+	_, err = ParseKCP("fast", "", 10)
+	test.NotNil(err)
+}
+
+func TestUdpChanFEC(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+
+	kcp := KCPProfiles["fast"]
+	opt := &ChannelOption{DataShards: 10, ParityShards: 3, KCP: &kcp}
+	cf := NewChannelFactory("udp", opt)
+	ln, err := cf.Listen(f)
+	test.Nil(err)
+	defer ln.Close()
+
+	recv := packet.NewChan(1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+		svr := cf.NewServer(f, session, conn, &dumpSvrInitDelegate{recv.Send()})
+		go svr.Run()
+	}()
+
+	conn, err := cf.DialTimeout(getAddr(ln.Addr()), time.Second)
+	test.Nil(err)
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+	cli := cf.NewClient(f, session, conn, packet.NewChan(1).Send())
+	go cli.Run()
+
+	test.True(cli.ChanWrite().SendOneSafe(f, dataPacket))
+	timeout := time.After(5 * time.Second)
+wait:
+	for {
+		select {
+		case ps := <-recv:
+			// the batch of heartbeat is empty after filtered
+			if len(ps) > 0 {
+				test.Equal(ps[0].Payload(), dataPacket.Payload())
+				break wait
+			}
+		case <-timeout:
+			t.Fatal("packet is not received")
+		}
+	}
+
+	fec := cli.(fecChannel).GetFEC()
+	test.Equal(fec.DataShards, 10)
+	test.Equal(fec.ParityShards, 3)
+	test.Equal(fec.Overhead, 0.3)
+
+	g := NewGroup(f, packet.NewChan(0).Recv(), packet.NewChan(0).Send())
+	g.AddWithAutoRemove(cli)
+	stats := g.GetChannelStats()
+	test.Equal(len(stats), 1)
+	test.NotNil(stats[0].FEC)
+	test.True(strings.Contains(FormatChannelStats(stats), "FEC of all channels"))
+
+	// fec is not enabled by default
+	_, ok := GetChannelType("udp").NewClient(f, session, conn, nil).(fecChannel)
+	test.False(ok)
+}
@@ -0,0 +1,342 @@
+package dchan
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/packet"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+const quicALPN = "next-dchan"
+
+var _ ChannelFactory = new(QuicChanFactory)
+
+// QuicChanFactory carries the frames of DgramChan in the datagrams of quic,
+// one quic connection for each channel, so a lost frame doesn't block the
+// others like a stream does. The frames which are too large for a datagram
+// are sent in their own unidirectional streams. The rtt of connection is
+// submitted to the heartbeat instead of the measured one.
+//
+// The cert is pinned by the fingerprint pushed by server, the frames are
+// sealed by the session anyway.
+type QuicChanFactory struct {
+	opt ChannelOption
+}
+
+func NewQuicChanFactory(opt *ChannelOption) *QuicChanFactory {
+	q := &QuicChanFactory{}
+	if opt != nil {
+		q.opt = *opt
+	}
+	return q
+}
+
+// QuicBatchSize is the batch size of DgramChan in quic, so the frames fit
+// in the datagrams before the path mtu is discovered.
+var QuicBatchSize = 1000
+
+type quicRTTKey struct{}
+
+// quicRTT is filled by the tracer of connection.
+type quicRTT struct {
+	smoothed int64
+}
+
+func (r *quicRTT) Get() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.smoothed))
+}
+
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		HandshakeIdleTimeout: HandshakeTimeout,
+		MaxIdleTimeout:       30 * time.Second,
+		KeepAlivePeriod:      5 * time.Second,
+		EnableDatagrams:      true,
+		// the large frames, each of them is in a stream
+		MaxIncomingUniStreams: 1000,
+		Tracer: func(ctx context.Context, _ logging.Perspective, _ quic.ConnectionID) *logging.ConnectionTracer {
+			rtt, ok := ctx.Value(quicRTTKey{}).(*quicRTT)
+			if !ok {
+				return nil
+			}
+			return &logging.ConnectionTracer{
+				UpdatedMetrics: func(stats *logging.RTTStats, _, _ logging.ByteCount, _ int) {
+					atomic.StoreInt64(&rtt.smoothed, int64(stats.SmoothedRTT()))
+				},
+			}
+		},
+	}
+}
+
+func (q *QuicChanFactory) Listen(f *flow.Flow) (net.Listener, error) {
+	if q.opt.Cert == nil {
+		return nil, ErrNoCert.Trace()
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	tr := &quic.Transport{
+		Conn: conn,
+		ConnContext: func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, quicRTTKey{}, new(quicRTT))
+		},
+	}
+	ln, err := tr.Listen(&tls.Config{
+		Certificates: []tls.Certificate{*q.opt.Cert},
+		NextProtos:   []string{quicALPN},
+	}, quicConfig())
+	if err != nil {
+		tr.Close()
+		conn.Close()
+		return nil, logex.Trace(err)
+	}
+	ql := &quicListener{
+		ln:     ln,
+		tr:     tr,
+		conn:   conn,
+		accept: make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	go ql.loop()
+	return ql, nil
+}
+
+func (q *QuicChanFactory) DialTimeout(host string, timeout time.Duration) (net.Conn, error) {
+	cfg, err := clientTLSConfig(host, q.opt.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	cfg.NextProtos = []string{quicALPN}
+
+	rtt := new(quicRTT)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := quic.DialAddr(context.WithValue(ctx, quicRTTKey{}, rtt), host, cfg, quicConfig())
+	if err != nil {
+		return nil, logex.Trace(err, host)
+	}
+	if !conn.ConnectionState().SupportsDatagrams {
+		conn.CloseWithError(0, "")
+		return nil, logex.NewErrorf("datagram is not supported by %v", host)
+	}
+	return newQuicConn(conn, rtt), nil
+}
+
+func (q *QuicChanFactory) NewClient(f *flow.Flow, s *packet.Session, c net.Conn, o packet.SendChan) Channel {
+	return useQuicConn(NewDgramChanClient(f, s, c, o), c)
+}
+
+func (q *QuicChanFactory) NewServer(f *flow.Flow, s *packet.Session, c net.Conn, d SvrInitDelegate) Channel {
+	return useQuicConn(NewDgramChanServer(f, s, c, d), c)
+}
+
+func useQuicConn(ch Channel, c net.Conn) Channel {
+	dc := ch.(*DgramChan)
+	dc.batchSize = QuicBatchSize
+	if qc, ok := c.(*quicConn); ok && qc.rtt != nil {
+		dc.heartBeat.UseRTT(qc.rtt.Get)
+	}
+	return ch
+}
+
+type quicListener struct {
+	ln        *quic.Listener
+	tr        *quic.Transport
+	conn      *net.UDPConn
+	accept    chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *quicListener) loop() {
+	for {
+		conn, err := l.ln.Accept(context.Background())
+		if err != nil {
+			l.Close()
+			return
+		}
+		if !conn.ConnectionState().SupportsDatagrams {
+			conn.CloseWithError(0, "datagram is required")
+			continue
+		}
+		rtt, _ := conn.Context().Value(quicRTTKey{}).(*quicRTT)
+		select {
+		case l.accept <- newQuicConn(conn, rtt):
+		case <-l.closed:
+			conn.CloseWithError(0, "")
+			return
+		}
+	}
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, logex.Trace(net.ErrClosed, "listener closed")
+	}
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *quicListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.ln.Close()
+		l.tr.Close()
+		l.conn.Close()
+	})
+	return nil
+}
+
+// quicConn reads and writes the frames of the quic connection, each Read
+// returns a whole frame like the udp conn.
+type quicConn struct {
+	conn     quic.Connection
+	rtt      *quicRTT
+	frames   chan []byte
+	deadline int64 // of read, in unix nano
+}
+
+func newQuicConn(conn quic.Connection, rtt *quicRTT) *quicConn {
+	c := &quicConn{
+		conn:   conn,
+		rtt:    rtt,
+		frames: make(chan []byte, 64),
+	}
+	go c.recvDatagrams()
+	go c.acceptStreams()
+	return c
+}
+
+func (c *quicConn) push(frame []byte) bool {
+	select {
+	case c.frames <- frame:
+		return true
+	case <-c.conn.Context().Done():
+		return false
+	}
+}
+
+func (c *quicConn) recvDatagrams() {
+	ctx := c.conn.Context()
+	for {
+		frame, err := c.conn.ReceiveDatagram(ctx)
+		if err != nil || !c.push(frame) {
+			return
+		}
+	}
+}
+
+func (c *quicConn) acceptStreams() {
+	ctx := c.conn.Context()
+	for {
+		stream, err := c.conn.AcceptUniStream(ctx)
+		if err != nil {
+			return
+		}
+		go func() {
+			stream.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+			frame, err := io.ReadAll(io.LimitReader(stream, 64<<10))
+			if err != nil {
+				stream.CancelRead(0)
+				return
+			}
+			c.push(frame)
+		}()
+	}
+}
+
+func (c *quicConn) Read(b []byte) (int, error) {
+	var timeout <-chan time.Time
+	if deadline := atomic.LoadInt64(&c.deadline); deadline > 0 {
+		timer := time.NewTimer(time.Until(time.Unix(0, deadline)))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case frame := <-c.frames:
+		return copy(b, frame), nil
+	case <-timeout:
+		// DgramChan only retries the timeout of *net.OpError
+		return 0, &net.OpError{Op: "read", Net: "quic",
+			Addr: c.RemoteAddr(), Err: errQuicTimeout{}}
+	case <-c.conn.Context().Done():
+		return 0, logex.Trace(net.ErrClosed, "quic connection closed")
+	}
+}
+
+// Write sends b in a datagram, or a stream if it's too large.
+func (c *quicConn) Write(b []byte) (int, error) {
+	err := c.conn.SendDatagram(b)
+	var tooLarge *quic.DatagramTooLargeError
+	if !errors.As(err, &tooLarge) {
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	ctx, cancel := context.WithTimeout(c.conn.Context(), HandshakeTimeout)
+	defer cancel()
+	stream, err := c.conn.OpenUniStreamSync(ctx)
+	if err != nil {
+		return 0, err
+	}
+	stream.SetWriteDeadline(time.Now().Add(HandshakeTimeout))
+	if _, err := stream.Write(b); err != nil {
+		stream.CancelWrite(0)
+		return 0, err
+	}
+	return len(b), stream.Close()
+}
+
+func (c *quicConn) Close() error {
+	return c.conn.CloseWithError(0, "")
+}
+
+func (c *quicConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *quicConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *quicConn) SetReadDeadline(t time.Time) error {
+	var deadline int64
+	if !t.IsZero() {
+		deadline = t.UnixNano()
+	}
+	atomic.StoreInt64(&c.deadline, deadline)
+	return nil
+}
+
+// the datagrams are not blocked, the streams have their own deadline.
+func (c *quicConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type errQuicTimeout struct{}
+
+func (errQuicTimeout) Error() string   { return "i/o timeout" }
+func (errQuicTimeout) Timeout() bool   { return true }
+func (errQuicTimeout) Temporary() bool { return true }
@@ -0,0 +1,79 @@
+package dchan
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/test"
+)
+
+func TestQuicChan(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+
+	cert, err := NewSelfSignedCert()
+	test.Nil(err)
+	pin := CertFingerprint(cert.Certificate[0])
+
+	cf := NewChannelFactory("quic", &ChannelOption{Cert: cert})
+	ln, err := cf.Listen(f)
+	test.Nil(err)
+	defer ln.Close()
+	addr := getAddr(ln.Addr())
+
+	recv := packet.NewChan(1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+			svr := cf.NewServer(f, session, conn, &dumpSvrInitDelegate{recv.Send()})
+			go svr.Run()
+		}
+	}()
+
+	wrong := NewChannelFactory("quic", &ChannelOption{Fingerprint: strings.Repeat("00", 32)})
+	_, err = wrong.DialTimeout(addr, time.Second)
+	test.NotNil(err)
+
+	cliFactory := NewChannelFactory("quic", &ChannelOption{Fingerprint: pin})
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+	conn, err := cliFactory.DialTimeout(addr, time.Second)
+	test.Nil(err)
+	defer conn.Close()
+	cli := cliFactory.NewClient(f, session, conn, packet.NewChan(1).Send())
+	go cli.Run()
+
+	expect := func(p *packet.Packet) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ps := <-recv:
+				if len(ps) == 0 {
+					continue
+				}
+				test.Equal(ps[0].Payload(), p.Payload())
+				return
+			case <-timeout:
+				t.Fatal("packet is not received")
+			}
+		}
+	}
+
+	test.True(cli.ChanWrite().SendOneSafe(f, dataPacket))
+	expect(dataPacket)
+	// the rtt is measured by quic
+	test.True(conn.(*quicConn).rtt.Get() > 0)
+
+	// too large for a datagram, it's sent in a stream
+	large := packet.New(test.RandBytes(4000), packet.DATA)
+	test.True(cli.ChanWrite().SendOneSafe(f, large))
+	expect(large)
+}
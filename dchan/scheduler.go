@@ -0,0 +1,398 @@
+package dchan
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/packet"
+)
+
+var ErrUnknownScheduler = logex.Define("unknown scheduler: %v, expect %v")
+
+// Scheduler decides which of the useful channels in Group the packets go
+// through.
+type Scheduler interface {
+	Name() string
+	// Schedule returns the packets for each of chans, the empty ones are
+	// skipped. It returns nil if the packets can go through whichever of
+	// chans is ready first. chans is not empty.
+	Schedule(chans []Channel, p []*packet.Packet) [][]*packet.Packet
+}
+
+// SchedulerNames are the names of schedulers, the first one is the default.
+var SchedulerNames = []string{"flow", "ready", "rtt", "wrr", "redundant"}
+
+// flowCounter is the scheduler which pins the flows to the channels.
+type flowCounter interface {
+	FlowCount(Channel) int
+}
+
+func CheckScheduler(name string) error {
+	_, err := NewScheduler(name)
+	return err
+}
+
+// NewScheduler returns the scheduler by name, "flow" is used if it's empty:
+//
+//	ready:     whichever channel is ready first
+//	rtt:       the channel with the lowest rtt
+//	wrr:       weighted round-robin by the measured bandwidth
+//	redundant: duplicate the small or expedited data packets onto the best 2 channels by rtt
+//	flow:      pin the flows by the 5-tuple of data packets, keeps the order of tcp
+func NewScheduler(name string) (Scheduler, error) {
+	switch name {
+	case "ready":
+		return readyScheduler{}, nil
+	case "rtt":
+		return rttScheduler{}, nil
+	case "wrr":
+		return newWrrScheduler(), nil
+	case "redundant":
+		return newRedundantScheduler(2), nil
+	case "", "flow":
+		return newFlowScheduler(), nil
+	default:
+		return nil, ErrUnknownScheduler.Format(name, strings.Join(SchedulerNames, ", "))
+	}
+}
+
+// byLatency returns the indexes of chans sorted by rtt, the ones without
+// heartbeat yet are the last.
+func byLatency(chans []Channel) []int {
+	latency := make([]time.Duration, len(chans))
+	idx := make([]int, len(chans))
+	for i, ch := range chans {
+		latency[i], _ = ch.Latency()
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		li, lj := latency[idx[i]], latency[idx[j]]
+		if li == 0 || lj == 0 {
+			return lj == 0 && li != 0
+		}
+		return li < lj
+	})
+	return idx
+}
+
+type readyScheduler struct{}
+
+func (readyScheduler) Name() string { return "ready" }
+
+func (readyScheduler) Schedule([]Channel, []*packet.Packet) [][]*packet.Packet {
+	return nil
+}
+
+type rttScheduler struct{}
+
+func (rttScheduler) Name() string { return "rtt" }
+
+func (rttScheduler) Schedule(chans []Channel, p []*packet.Packet) [][]*packet.Packet {
+	ret := make([][]*packet.Packet, len(chans))
+	ret[byLatency(chans)[0]] = p
+	return ret
+}
+
+// the channels without traffic still get a few packets to be measured
+const wrrMinWeight = 16 << 10
+
+// wrrScheduler is the smooth weighted round-robin of nginx, the weight is
+// the bytes per second the channel sent or received in the last second.
+type wrrScheduler struct {
+	mutex   sync.Mutex
+	current map[Channel]int64
+}
+
+func newWrrScheduler() *wrrScheduler {
+	return &wrrScheduler{current: make(map[Channel]int64)}
+}
+
+func (*wrrScheduler) Name() string { return "wrr" }
+
+func (w *wrrScheduler) Schedule(chans []Channel, p []*packet.Packet) [][]*packet.Packet {
+	weights := make([]int64, len(chans))
+	var total int64
+	for i, ch := range chans {
+		speed := ch.GetSpeed()
+		weights[i] = int64(speed.Upload)
+		if int64(speed.Download) > weights[i] {
+			weights[i] = int64(speed.Download)
+		}
+		if weights[i] < wrrMinWeight {
+			weights[i] = wrrMinWeight
+		}
+		total += weights[i]
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	current := make(map[Channel]int64, len(chans))
+	for _, ch := range chans {
+		current[ch] = w.current[ch]
+	}
+	w.current = current
+
+	ret := make([][]*packet.Packet, len(chans))
+	for _, pkt := range p {
+		best := 0
+		for i, ch := range chans {
+			current[ch] += weights[i]
+			if current[ch] > current[chans[best]] {
+				best = i
+			}
+		}
+		current[chans[best]] -= total
+		ret[best] = append(ret[best], pkt)
+	}
+	return ret
+}
+
+// RedundantMaxSize is the largest data packet duplicated by the redundant
+// scheduler, like the acks, dns and voice. The larger ones are duplicated
+// only if they are marked as expedited forwarding.
+var RedundantMaxSize = 256
+
+const dscpEF = 46
+
+// redundantScheduler sends the small or expedited data packets to the best
+// n channels, they are wrapped in DATA_DUP with their own sequence number so
+// the receiving Group drops the duplicated ones before shaping. The other
+// packets go through the best one.
+type redundantScheduler struct {
+	n     int
+	mutex sync.Mutex
+	seq   uint32
+	// the copies of the last Schedule, the unsent packets are rescheduled
+	// by Group if the useful channels are changed, they keep the sequence.
+	last map[*packet.Packet]*packet.Packet
+}
+
+func newRedundantScheduler(n int) *redundantScheduler {
+	return &redundantScheduler{n: n}
+}
+
+func (*redundantScheduler) Name() string { return "redundant" }
+
+func (r *redundantScheduler) isRedundant(pkt *packet.Packet) bool {
+	if pkt.Type != packet.DATA {
+		return false
+	}
+	if pkt.Size() <= RedundantMaxSize {
+		return true
+	}
+	return (&packet.DataPacket{Packet: pkt}).DSCP() == dscpEF
+}
+
+func (r *redundantScheduler) Schedule(chans []Channel, p []*packet.Packet) [][]*packet.Packet {
+	ret := make([][]*packet.Packet, len(chans))
+	best := byLatency(chans)
+	if len(best) > r.n {
+		best = best[:r.n]
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	copies := make(map[*packet.Packet]*packet.Packet)
+	for _, pkt := range p {
+		if len(best) == 1 || !r.isRedundant(pkt) {
+			ret[best[0]] = append(ret[best[0]], pkt)
+			continue
+		}
+		dup := r.last[pkt]
+		if dup == nil {
+			dup = packet.NewDupPacket(pkt, r.nextSeqLocked())
+		}
+		copies[pkt] = dup
+		for _, idx := range best {
+			ret[idx] = append(ret[idx], dup)
+		}
+	}
+	r.last = copies
+	return ret
+}
+
+func (r *redundantScheduler) nextSeqLocked() uint32 {
+	r.seq++
+	if r.seq == 0 {
+		r.seq++
+	}
+	return r.seq
+}
+
+// dedupWindow drops the duplicated DATA_DUP of redundantScheduler, it's
+// not thread-safe, the receiving loop of Group owns it.
+type dedupWindow struct {
+	last   int64
+	window packet.ReplayWindow
+}
+
+// accept reports whether seq is seen in the first time. seq is extended to
+// 64 bits around the last one, the one behind the window is accepted, the
+// duplicated packet which is late so much is harmless.
+func (d *dedupWindow) accept(seq uint32) bool {
+	if d.last == 0 {
+		// keeps the extended ones positive
+		d.last = 1<<32 + int64(seq)
+	}
+	ext := d.last + int64(int32(seq-uint32(d.last)))
+	if d.last-ext >= packet.ReplayWindowSize {
+		return true
+	}
+	if ext > d.last {
+		d.last = ext
+	}
+	return d.window.Accept(uint64(ext))
+}
+
+// filter unwraps the DATA_DUP which are seen in the first time and drops
+// the others, in place.
+func (d *dedupWindow) filter(p []*packet.Packet) []*packet.Packet {
+	ret := p[:0]
+	for _, pkt := range p {
+		if pkt.Type == packet.DATA_DUP {
+			seq, data, err := packet.ParseDupPacket(pkt)
+			if err != nil || !d.accept(seq) {
+				continue
+			}
+			pkt = data
+		}
+		ret = append(ret, pkt)
+	}
+	return ret
+}
+
+// FlowIdleTimeout is how long a flow keeps pinned to its channel without
+// any packet.
+var FlowIdleTimeout = time.Minute
+
+// MaxFlows is the size of flow table of each group, the least recently
+// active flow is dropped if it's full.
+var MaxFlows = 1 << 16
+
+type flowEntry struct {
+	hash   uint32
+	ch     Channel
+	active time.Time
+	elem   *list.Element // in lru
+}
+
+// flowScheduler pins the flows by the 5-tuple of data packets to the
+// channels, so the packets of a tcp connection are not reordered by the
+// different rtt. A flow is moved only if its channel is dead or not useful,
+// the new flows go to the channel with the fewest flows, the one with lower
+// rtt wins the tie. The packets which are not data go through the first
+// channel.
+type flowScheduler struct {
+	mutex  sync.Mutex
+	flows  map[uint32]*flowEntry
+	lru    *list.List // the most recently active is in the front
+	counts map[Channel]int
+}
+
+func newFlowScheduler() *flowScheduler {
+	return &flowScheduler{
+		flows:  make(map[uint32]*flowEntry),
+		lru:    list.New(),
+		counts: make(map[Channel]int),
+	}
+}
+
+func (*flowScheduler) Name() string { return "flow" }
+
+func (f *flowScheduler) Schedule(chans []Channel, p []*packet.Packet) [][]*packet.Packet {
+	now := time.Now()
+	idx := make(map[Channel]int, len(chans))
+	for i, ch := range chans {
+		idx[ch] = i
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sweepLocked(now)
+	var order []int // lazy, only for the new flows
+
+	ret := make([][]*packet.Packet, len(chans))
+	for _, pkt := range p {
+		if pkt.Type != packet.DATA {
+			ret[0] = append(ret[0], pkt)
+			continue
+		}
+		hash := (&packet.DataPacket{Packet: pkt}).FlowHash()
+		entry := f.flows[hash]
+		if entry == nil {
+			if f.lru.Len() >= MaxFlows {
+				f.removeLocked(f.lru.Back().Value.(*flowEntry))
+			}
+			entry = &flowEntry{hash: hash}
+			entry.elem = f.lru.PushFront(entry)
+			f.flows[hash] = entry
+		} else {
+			f.lru.MoveToFront(entry.elem)
+		}
+		i, ok := idx[entry.ch]
+		if !ok {
+			if order == nil {
+				order = byLatency(chans)
+			}
+			i = order[0]
+			for _, j := range order[1:] {
+				if f.counts[chans[j]] < f.counts[chans[i]] {
+					i = j
+				}
+			}
+			if entry.ch != nil {
+				logex.Debugf("flow %x: %v -> %v", hash, entry.ch.Name(), chans[i].Name())
+			}
+			f.pinLocked(entry, chans[i])
+		}
+		entry.active = now
+		ret[i] = append(ret[i], pkt)
+	}
+	return ret
+}
+
+// pinLocked moves the flow to ch and updates the counts of channels.
+func (f *flowScheduler) pinLocked(entry *flowEntry, ch Channel) {
+	f.unpinLocked(entry)
+	entry.ch = ch
+	f.counts[ch]++
+}
+
+func (f *flowScheduler) unpinLocked(entry *flowEntry) {
+	if entry.ch == nil {
+		return
+	}
+	if f.counts[entry.ch]--; f.counts[entry.ch] <= 0 {
+		// the channel may be gone
+		delete(f.counts, entry.ch)
+	}
+	entry.ch = nil
+}
+
+func (f *flowScheduler) removeLocked(entry *flowEntry) {
+	f.unpinLocked(entry)
+	f.lru.Remove(entry.elem)
+	delete(f.flows, entry.hash)
+}
+
+// sweepLocked removes the idle flows from the back of lru.
+func (f *flowScheduler) sweepLocked(now time.Time) {
+	for elem := f.lru.Back(); elem != nil; elem = f.lru.Back() {
+		entry := elem.Value.(*flowEntry)
+		if now.Sub(entry.active) < FlowIdleTimeout {
+			break
+		}
+		f.removeLocked(entry)
+	}
+}
+
+// FlowCount returns the count of flows pinned to the channel.
+func (f *flowScheduler) FlowCount(ch Channel) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.counts[ch]
+}
@@ -0,0 +1,326 @@
+package dchan
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/next/statistic"
+	"github.com/chzyer/next/util"
+	"github.com/chzyer/test"
+)
+
+type testChan struct {
+	Channel // not implemented
+	name    string
+	latency time.Duration
+	speed   util.Unit
+	out     chan []*packet.Packet
+	onClose []func()
+}
+
+func newTestChan(name string, latency time.Duration) *testChan {
+	return &testChan{
+		name:    name,
+		latency: latency,
+		out:     make(chan []*packet.Packet),
+	}
+}
+
+func (c *testChan) Name() string { return c.name }
+
+func (c *testChan) Latency() (time.Duration, time.Duration) { return c.latency, 0 }
+
+func (c *testChan) GetSpeed() *statistic.SpeedInfo {
+	return &statistic.SpeedInfo{Upload: c.speed}
+}
+
+func (c *testChan) ChanWrite() packet.SendChan { return c.out }
+
+func (c *testChan) AddOnClose(f func()) { c.onClose = append(c.onClose, f) }
+
+func (c *testChan) Close() {
+	for _, f := range c.onClose {
+		f()
+	}
+}
+
+func newTestPackets(n int, t packet.Type) []*packet.Packet {
+	ret := make([]*packet.Packet, n)
+	for i := range ret {
+		payload := make([]byte, 24)
+		payload[0] = 0x45
+		payload[9] = packet.ProtoTCP
+		payload[20] = byte(i)
+		ret[i] = packet.New(payload, t)
+	}
+	return ret
+}
+
+func TestSchedulers(t *testing.T) {
+	defer test.New(t)
+
+	_, err := NewScheduler("foo")
+	test.NotNil(err)
+
+	fast := newTestChan("fast", 10*time.Millisecond)
+	slow := newTestChan("slow", 50*time.Millisecond)
+	idle := newTestChan("idle", 0) // no heartbeat yet
+	chans := []Channel{idle, slow, fast}
+	p := newTestPackets(400, packet.DATA)
+
+	sched, _ := NewScheduler("")
+	test.Equal(sched.Name(), "flow")
+	sched, _ = NewScheduler("ready")
+	test.True(sched.Schedule(chans, p) == nil)
+
+	sched, _ = NewScheduler("rtt")
+	test.Equal(len(sched.Schedule(chans, p)[2]), 400)
+
+	fast.speed = 3 * wrrMinWeight
+	sched, _ = NewScheduler("wrr")
+	ret := sched.Schedule(chans, p)
+	test.Equal(len(ret[0]), 80)
+	test.Equal(len(ret[1]), 80)
+	test.Equal(len(ret[2]), 240)
+
+	sched, _ = NewScheduler("redundant")
+	ctl := packet.New(nil, packet.NEWDC)
+	big := packet.New(make([]byte, RedundantMaxSize+1), packet.DATA)
+	ef := make([]byte, RedundantMaxSize+1)
+	ef[0], ef[1] = 0x45, dscpEF<<2
+	expedited := packet.New(ef, packet.DATA)
+	ret = sched.Schedule(chans, append([]*packet.Packet{ctl, big, expedited}, p...))
+	test.Equal(len(ret[0]), 0)
+	test.Equal(len(ret[1]), 401)
+	test.Equal(len(ret[2]), 403)
+	test.True(ret[2][1] == big)
+	// the copies are shared by the channels
+	test.True(ret[1][0] == ret[2][2])
+	seq, data, err := packet.ParseDupPacket(ret[1][0])
+	test.Nil(err)
+	test.Equal(seq, uint32(1))
+	test.Equal(data.Payload(), expedited.Payload())
+	seq, _, err = packet.ParseDupPacket(ret[1][400])
+	test.Nil(err)
+	test.Equal(seq, uint32(401))
+	// the rescheduled ones keep the sequence
+	ret2 := sched.Schedule(chans, []*packet.Packet{expedited})
+	test.True(ret2[1][0] == ret[1][0])
+
+	sched, _ = NewScheduler("flow")
+	twice := make([]*packet.Packet, 0, 2*len(p))
+	for _, pkt := range p {
+		twice = append(twice, pkt, pkt)
+	}
+	ret = sched.Schedule(chans, twice)
+	total := 0
+	for _, batch := range ret {
+		// all packets of a flow are in the same channel
+		for i := 0; i < len(batch); i += 2 {
+			test.True(batch[i] == batch[i+1])
+		}
+		total += len(batch)
+	}
+	test.Equal(total, 800)
+}
+
+func TestGroupScheduler(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+	g := NewGroup(f, nil, nil)
+	fast := newTestChan("fast", 10*time.Millisecond)
+	slow := newTestChan("slow", 15*time.Millisecond)
+	g.AddWithAutoRemove(slow)
+	g.AddWithAutoRemove(fast)
+	sched, _ := NewScheduler("rtt")
+	g.SetScheduler(sched)
+	test.Equal(g.GetScheduler().Name(), "rtt")
+
+	// the fast one is blocked until it's closed
+	p := newTestPackets(1, packet.DATA)
+	go g.Send(p)
+	time.Sleep(20 * time.Millisecond)
+	fast.Close()
+	select {
+	case got := <-slow.out:
+		test.Equal(got, p)
+	case <-time.After(time.Second):
+		t.Fatal("packets are not rescheduled")
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	defer test.New(t)
+
+	var d dedupWindow
+	test.True(d.accept(1))
+	test.False(d.accept(1))
+	test.True(d.accept(3))
+	test.True(d.accept(2))
+	test.False(d.accept(3))
+
+	// wrapped around
+	d = dedupWindow{}
+	test.True(d.accept(math.MaxUint32))
+	test.True(d.accept(1))
+	test.False(d.accept(math.MaxUint32))
+	test.False(d.accept(1))
+
+	// far behind the window, it's accepted and the window is kept
+	test.True(d.accept(1 << 20))
+	test.True(d.accept(1))
+	test.True(d.accept(1))
+	test.False(d.accept(1 << 20))
+}
+
+type testMeter struct {
+	bytes, packets int
+}
+
+func (m *testMeter) Count(upload bool, bytes, packets int) {
+	m.bytes += bytes
+	m.packets += packets
+}
+
+func TestGroupDedup(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+	toDC := packet.NewChan(0)
+	fromDC := packet.NewChan(0)
+	g := NewGroup(f, toDC.Recv(), fromDC.Send())
+	meter := &testMeter{}
+	g.SetLimiter(&Limiter{Meter: meter})
+	g.Run()
+
+	a := newTestChan("a", 10*time.Millisecond)
+	b := newTestChan("b", 20*time.Millisecond)
+	p := newTestPackets(2, packet.DATA)
+	ret := newRedundantScheduler(2).Schedule([]Channel{a, b}, p)
+	test.True(g.Upload().SendSafe(f, ret[0]))
+	test.Equal(fromDC.Recv().RecvAll(f), p)
+	test.True(g.Upload().SendSafe(f, ret[1]))
+	test.True(g.Upload().SendSafe(f, newTestPackets(1, packet.DATA)))
+	test.Equal(len(fromDC.Recv().RecvAll(f)), 1)
+	// the duplicated ones are counted once
+	test.Equal(meter.packets, 3)
+	test.Equal(meter.bytes, 3*24)
+}
+
+type testSvrDelegate struct {
+	SvrDelegate // not implemented
+	fromUser    packet.Chan
+	toUser      packet.Chan
+}
+
+func (d *testSvrDelegate) GetUserChannelFromDataChannel(int) (packet.RecvChan, packet.SendChan, error) {
+	return d.fromUser.Recv(), d.toUser.Send(), nil
+}
+
+func (d *testSvrDelegate) GetUserLimiter(int) *Limiter { return nil }
+
+func TestServerRelogin(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+	d := &testSvrDelegate{fromUser: packet.NewChan(0), toUser: packet.NewChan(0)}
+	s := NewServer(f, d)
+	defer s.Close()
+	g, err := s.Group(1)
+	test.Nil(err)
+
+	a := newTestChan("a", 10*time.Millisecond)
+	b := newTestChan("b", 20*time.Millisecond)
+	p := newTestPackets(2, packet.DATA)
+	ret := newRedundantScheduler(2).Schedule([]Channel{a, b}, p)
+	test.True(g.Upload().SendSafe(f, ret[0]))
+	test.Equal(d.toUser.Recv().RecvAll(f), p)
+
+	// the group is kept after the client logins again, the sequence of the
+	// new session starts from 1
+	s.ResetDedup(1)
+	ret = newRedundantScheduler(2).Schedule([]Channel{a, b}, p)
+	test.True(g.Upload().SendSafe(f, ret[1]))
+	test.Equal(d.toUser.Recv().RecvAll(f), p)
+}
+
+func TestFlowScheduler(t *testing.T) {
+	defer test.New(t)
+
+	a := newTestChan("a", 10*time.Millisecond)
+	b := newTestChan("b", 10*time.Millisecond)
+	c := newTestChan("c", 10*time.Millisecond)
+	sched := newFlowScheduler()
+	p := newTestPackets(10, packet.DATA)
+
+	ret := sched.Schedule([]Channel{a, b}, p)
+	test.Equal(len(ret[0]), 5)
+	test.Equal(len(ret[1]), 5)
+	test.Equal(sched.FlowCount(a), 5)
+
+	// the flows are kept in the new useful channels
+	ret2 := sched.Schedule([]Channel{c, b, a}, p)
+	test.Equal(len(ret2[0]), 0)
+	test.Equal(ret2[1], ret[1])
+	test.Equal(ret2[2], ret[0])
+
+	// the new flows go to the channel with the fewest flows
+	ret2 = sched.Schedule([]Channel{c, b, a}, newTestPackets(12, packet.DATA)[10:])
+	test.Equal(len(ret2[0]), 2)
+
+	// a is dead or not useful
+	ret2 = sched.Schedule([]Channel{c, b}, p)
+	inB := make(map[*packet.Packet]bool)
+	for _, pkt := range ret2[1] {
+		inB[pkt] = true
+	}
+	for _, pkt := range ret[1] {
+		test.True(inB[pkt])
+	}
+	test.Equal(sched.FlowCount(a), 0)
+	test.Equal(sched.FlowCount(b), 6)
+	test.Equal(sched.FlowCount(c), 6)
+
+	// idle flows are released
+	sched.mutex.Lock()
+	for _, entry := range sched.flows {
+		entry.active = entry.active.Add(-FlowIdleTimeout)
+	}
+	sched.mutex.Unlock()
+	sched.Schedule([]Channel{c, b}, nil)
+	test.Equal(sched.FlowCount(b)+sched.FlowCount(c), 0)
+	test.Equal(len(sched.flows), 0)
+	test.Equal(len(sched.counts), 0)
+}
+
+func TestFlowSchedulerLimit(t *testing.T) {
+	defer test.New(t)
+
+	old := MaxFlows
+	MaxFlows = 4
+	defer func() { MaxFlows = old }()
+
+	fast := newTestChan("fast", 10*time.Millisecond)
+	slow := newTestChan("slow", 20*time.Millisecond)
+	sched := newFlowScheduler()
+	p := newTestPackets(6, packet.DATA)
+
+	// the faster one wins the tie
+	ret := sched.Schedule([]Channel{slow, fast}, p[:1])
+	test.Equal(len(ret[1]), 1)
+
+	// the least recently active ones are dropped
+	sched.Schedule([]Channel{slow, fast}, p[1:])
+	test.Equal(len(sched.flows), 4)
+	test.Equal(sched.lru.Len(), 4)
+	test.Equal(sched.FlowCount(slow)+sched.FlowCount(fast), 4)
+	test.True(sched.lru.Back().Value.(*flowEntry).hash ==
+		(&packet.DataPacket{Packet: p[2]}).FlowHash())
+}
@@ -12,6 +12,7 @@ type Server struct {
 	flow     *flow.Flow
 	group    map[int]*Group // map[userId]Group
 	delegate SvrDelegate
+	sched    string // name of the scheduler for the new groups
 	m        sync.RWMutex
 }
 
@@ -38,6 +39,9 @@ func (s *Server) Group(userId int) (*Group, error) {
 		group = s.group[userId]
 		if group == nil {
 			group = NewGroup(s.flow, fromUser, toUser)
+			group.SetLimiter(s.delegate.GetUserLimiter(userId))
+			sched, _ := NewScheduler(s.sched)
+			group.SetScheduler(sched)
 			group.Run()
 			s.group[userId] = group
 		}
@@ -46,6 +50,74 @@ func (s *Server) Group(userId int) (*Group, error) {
 	return group, nil
 }
 
+// SetLimiter updates the limiter of user if it's online.
+func (s *Server) SetLimiter(userId int, l *Limiter) {
+	s.m.RLock()
+	group := s.group[userId]
+	s.m.RUnlock()
+	if group != nil {
+		group.SetLimiter(l)
+	}
+}
+
+// SetScheduler replaces the scheduler of all groups, each of them has its
+// own one.
+func (s *Server) SetScheduler(name string) error {
+	if err := CheckScheduler(name); err != nil {
+		return err
+	}
+	s.m.Lock()
+	s.sched = name
+	for _, group := range s.group {
+		sched, _ := NewScheduler(name)
+		group.SetScheduler(sched)
+	}
+	s.m.Unlock()
+	return nil
+}
+
+func (s *Server) GetScheduler() string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if s.sched == "" {
+		return SchedulerNames[0]
+	}
+	return s.sched
+}
+
+// ResetDedup resets the dedup of redundant copies of user if it's online,
+// the client logins again and its sequence starts from 1.
+func (s *Server) ResetDedup(userId int) {
+	s.m.RLock()
+	group := s.group[userId]
+	s.m.RUnlock()
+	if group != nil {
+		group.ResetDedup()
+	}
+}
+
+// IsOnline reports whether the user has any data channel.
+func (s *Server) IsOnline(userId int) bool {
+	s.m.RLock()
+	group := s.group[userId]
+	s.m.RUnlock()
+	return group != nil && group.ChannelCount() > 0
+}
+
+// CloseGroup closes the group of user and all of its channels.
+func (s *Server) CloseGroup(userId int) bool {
+	s.m.Lock()
+	group := s.group[userId]
+	delete(s.group, userId)
+	s.m.Unlock()
+	if group == nil {
+		return false
+	}
+	group.CloseAllChannel()
+	group.Close()
+	return true
+}
+
 func (s *Server) AddChannel(ch Channel) error {
 	userId, err := ch.GetUserId()
 	if err != nil {
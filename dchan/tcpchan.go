@@ -26,9 +26,14 @@ type TcpChan struct {
 	delegate     SvrInitDelegate
 	waitInitChan chan struct{}
 
+	handshake       *packet.Packet // client only
+	handshakeDone   chan struct{}
+	handshakeResend time.Duration
+
 	// private
 	heartBeat *statistic.HeartBeatStage
 	speed     *statistic.Speed
+	drop      *statistic.Drop
 
 	// runtime
 	exitError error
@@ -39,6 +44,9 @@ type TcpChan struct {
 
 func NewTcpChanClient(f *flow.Flow, session *packet.Session, conn net.Conn, out packet.SendChan) Channel {
 	ch := NewTcpChanServer(f, session, conn, nil).(*TcpChan)
+	if session.NeedHandshake() {
+		ch.handshake = session.StartHandshake()
+	}
 	ch.markInit(out)
 	return ch
 }
@@ -50,7 +58,10 @@ func NewTcpChanServer(f *flow.Flow, session *packet.Session, conn net.Conn, dele
 		session:      session,
 		waitInitChan: make(chan struct{}, 1),
 
+		handshakeDone: make(chan struct{}),
+
 		speed: statistic.NewSpeed(),
+		drop:  statistic.NewDrop(),
 		in:    packet.NewChan(0),
 	}
 	if tcpConn, ok := conn.(*net.TCPConn); ok {
@@ -71,6 +82,10 @@ func (c *TcpChan) IsSvrModeAndUninit() bool {
 	return c.out == nil
 }
 
+func (c *TcpChan) GetDrop() *statistic.DropInfo {
+	return c.drop.GetDrop()
+}
+
 func (c *TcpChan) GetSpeed() *statistic.SpeedInfo {
 	return c.speed.GetSpeed()
 }
@@ -81,19 +96,23 @@ func (c *TcpChan) HeartBeatClean(err error) {
 }
 
 func (c *TcpChan) Run() {
-	go c.writeLoop()
+	go c.writeLoop(c.rawWrite)
 	go c.readLoop()
 }
 
 func (c *TcpChan) rawWrite(p []*packet.Packet) error {
-	l2 := packet.WrapL2(c.session, p)
+	return c.rawWriteL2(packet.WrapL2(c.session, p))
+}
+
+func (c *TcpChan) rawWriteL2(l2 *packet.PacketL2) error {
 	data := c.WriteL2(l2)
 	n, err := c.conn.Write(data)
 	c.speed.Upload(n)
 	return err
 }
 
-func (c *TcpChan) writeLoop() {
+// the packets of handshake, heartbeat and c.in are written by write.
+func (c *TcpChan) writeLoop(write func([]*packet.Packet) error) {
 	c.flow.Add(1)
 	defer c.flow.DoneAndClose()
 
@@ -101,6 +120,11 @@ func (c *TcpChan) writeLoop() {
 		return
 	}
 
+	if err := clientHandshake(c.flow, c.handshake, write, c.handshakeDone, c.handshakeResend); err != nil {
+		c.exitError = logex.NewErrorf("handshake error: %v", err)
+		return
+	}
+
 	heartBeatTicker := time.NewTicker(1 * time.Second)
 	defer heartBeatTicker.Stop()
 
@@ -112,10 +136,10 @@ loop:
 			break loop
 		case <-heartBeatTicker.C:
 			p := c.heartBeat.New()
-			err = c.rawWrite([]*packet.Packet{p})
+			err = write([]*packet.Packet{p})
 			c.heartBeat.Add(p)
 		case p := <-c.in:
-			err = c.rawWrite(p)
+			err = write(p)
 		}
 		if err != nil {
 			if !strings.Contains(err.Error(), "closed") {
@@ -148,11 +172,26 @@ loop:
 		}
 
 		if err := l2.Verify(c.session); err != nil {
+			if logex.Equal(err, packet.ErrReplayed) {
+				c.drop.Replay()
+				continue
+			}
 			c.exitError = logex.NewErrorf("verify error: %v", err)
 			break
 		}
 
+		ps, err := l2.Unmarshal()
+		if err != nil {
+			c.exitError = logex.NewErrorf("packet error: %v", err)
+			break
+		}
+
 		if c.IsSvrModeAndUninit() {
+			ps, err = serverHandshake(c.session, ps, c.rawWriteL2)
+			if err != nil {
+				c.exitError = logex.NewErrorf("handshake error: %v", err)
+				break
+			}
 			out, err := c.delegate.Init(int(l2.UserId))
 			if err != nil {
 				c.exitError = logex.NewErrorf("init error: %v", err)
@@ -162,12 +201,6 @@ loop:
 			c.delegate.OnInited(c)
 		}
 
-		ps, err := l2.Unmarshal()
-		if err != nil {
-			c.exitError = logex.NewErrorf("packet error: %v", err)
-			break
-		}
-
 		for _, p := range ps {
 			c.speed.Download(p.Size())
 			if !c.onRecePacket(p) {
@@ -185,6 +218,15 @@ func (h *TcpChan) onRecePacket(p *packet.Packet) bool {
 		}
 	case packet.HEARTBEAT_R:
 		h.heartBeat.Receive(p)
+	case packet.HANDSHAKE_R:
+		if err := h.session.FinishHandshake(p); err != nil {
+			h.exitError = logex.NewErrorf("handshake error: %v", err)
+			return false
+		}
+		close(h.handshakeDone)
+	case packet.HANDSHAKE:
+		h.exitError = logex.NewErrorf("unexpected handshake")
+		return false
 	default:
 		if !h.out.SendOneSafe(h.flow, p) {
 			return false
@@ -9,30 +9,43 @@ import (
 	"github.com/chzyer/next/util"
 )
 
+// offsets in the header of PacketL2
+const (
+	l2UserId   = 16
+	l2Checksum = 18
+	l2Length   = 22
+)
+
+// decodeL2Header returns the frame without payload and the length of its
+// payload, header must be PacketL2HeaderSize bytes.
+func decodeL2Header(header []byte) (*packet.PacketL2, int) {
+	iv := header[:l2UserId]
+	userId := binary.BigEndian.Uint16(header[l2UserId:l2Checksum])
+	checksum := binary.BigEndian.Uint32(header[l2Checksum:l2Length])
+	length := binary.BigEndian.Uint16(header[l2Length:packet.PacketL2HeaderSize])
+	return packet.NewPacketL2(iv, userId, nil, checksum), int(length)
+}
+
 func (c *TcpChan) ReadL2(r *bufio.Reader) (*packet.PacketL2, error) {
-	header, err := util.ReadFull(r, 24)
+	header, err := util.ReadFull(r, packet.PacketL2HeaderSize)
 	if err != nil {
 		return nil, logex.Trace(err, "read l2 header")
 	}
 
-	iv := header[:16]
-	userId := binary.BigEndian.Uint16(header[16:18])
-	checksum := binary.BigEndian.Uint32(header[18:22])
-	length := binary.BigEndian.Uint16(header[22:24])
-	payload, err := util.ReadFull(r, int(length))
+	l2, length := decodeL2Header(header)
+	l2.Payload, err = util.ReadFull(r, length)
 	if err != nil {
 		return nil, logex.Trace(err, "read l2 payload")
 	}
-
-	return packet.NewPacketL2(iv, userId, payload, checksum), nil
+	return l2, nil
 }
 
 func (c *TcpChan) WriteL2(p *packet.PacketL2) []byte {
-	ret := make([]byte, 24+len(p.Payload))
-	copy(ret[:16], p.IV)
-	binary.BigEndian.PutUint16(ret[16:18], p.UserId)
-	binary.BigEndian.PutUint32(ret[18:22], p.Checksum)
-	binary.BigEndian.PutUint16(ret[22:24], uint16(len(p.Payload)))
-	copy(ret[24:], p.Payload)
+	ret := make([]byte, packet.PacketL2HeaderSize+len(p.Payload))
+	copy(ret[:l2UserId], p.IV)
+	binary.BigEndian.PutUint16(ret[l2UserId:l2Checksum], p.UserId)
+	binary.BigEndian.PutUint32(ret[l2Checksum:l2Length], p.Checksum)
+	binary.BigEndian.PutUint16(ret[l2Length:packet.PacketL2HeaderSize], uint16(len(p.Payload)))
+	copy(ret[packet.PacketL2HeaderSize:], p.Payload)
 	return ret
 }
@@ -0,0 +1,154 @@
+package dchan
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/packet"
+)
+
+var (
+	ErrCertMismatch = logex.Define("certificate fingerprint mismatch")
+	ErrNoCert       = logex.Define("certificate is not provided")
+)
+
+var _ ChannelFactory = new(TlsChanFactory)
+
+// TlsChanFactory wraps the tcp channel in tls by the cert of http api, the
+// client pins the fingerprint of server cert if it's known, otherwise the
+// cert is verified by the system roots.
+type TlsChanFactory struct {
+	opt ChannelOption
+}
+
+func NewTlsChanFactory(opt *ChannelOption) *TlsChanFactory {
+	t := &TlsChanFactory{}
+	if opt != nil {
+		t.opt = *opt
+	}
+	return t
+}
+
+func (t *TlsChanFactory) Listen(*flow.Flow) (net.Listener, error) {
+	if t.opt.Cert == nil {
+		return nil, ErrNoCert.Trace()
+	}
+	return tls.Listen("tcp", ":0", &tls.Config{
+		Certificates: []tls.Certificate{*t.opt.Cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+}
+
+func (t *TlsChanFactory) DialTimeout(host string, timeout time.Duration) (net.Conn, error) {
+	cfg, err := clientTLSConfig(host, t.opt.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, cfg)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	return conn, nil
+}
+
+func (t *TlsChanFactory) NewClient(f *flow.Flow, s *packet.Session, c net.Conn, o packet.SendChan) Channel {
+	return NewHttpChanClient(f, s, c, o)
+}
+
+func (t *TlsChanFactory) NewServer(f *flow.Flow, s *packet.Session, c net.Conn, d SvrInitDelegate) Channel {
+	return NewHttpChanServer(f, s, c, d)
+}
+
+// clientTLSConfig pins the cert by the fingerprint, or verifies it by the
+// system roots if the fingerprint is empty.
+func clientTLSConfig(host, fingerprint string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if fingerprint == "" {
+		h, _, err := net.SplitHostPort(host)
+		if err != nil {
+			return nil, logex.Trace(err)
+		}
+		cfg.ServerName = h
+		return cfg, nil
+	}
+
+	// the cert is usually self-signed
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return ErrCertMismatch.Trace()
+		}
+		fp := CertFingerprint(cs.PeerCertificates[0].Raw)
+		if fp != fingerprint {
+			return ErrCertMismatch.Trace(fp)
+		}
+		return nil
+	}
+	return cfg, nil
+}
+
+// CertFingerprint is the hex sha256 of the der encoded cert.
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+func LoadCert(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	return &cert, nil
+}
+
+// NewSelfSignedCert generates a cert for the channels which must run on tls
+// but the cert is not provided, the clients pin it by the fingerprint.
+func NewSelfSignedCert() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "next"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// ParseFingerprint accepts the hex sha256 with or without colons, like the
+// output of `openssl x509 -fingerprint -sha256`.
+func ParseFingerprint(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	fp := strings.ToLower(strings.Replace(s, ":", "", -1))
+	if b, err := hex.DecodeString(fp); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid fingerprint: %v, expect the hex of sha256", s)
+	}
+	return fp, nil
+}
@@ -0,0 +1,128 @@
+package dchan
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/test"
+)
+
+// writeTestCert writes a self-signed cert and returns the file paths.
+func writeTestCert(dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.Nil(err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "next"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	test.Nil(err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	test.Nil(err)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	test.Nil(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	test.Nil(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return
+}
+
+func TestParseFingerprint(t *testing.T) {
+	defer test.New(t)
+
+	fp := strings.Repeat("ab", 32)
+	ret, err := ParseFingerprint(strings.ToUpper(fp))
+	test.Nil(err)
+	test.Equal(ret, fp)
+
+	colons := strings.TrimSuffix(strings.Repeat("AB:", 32), ":")
+	ret, err = ParseFingerprint(colons)
+	test.Nil(err)
+	test.Equal(ret, fp)
+
+	ret, err = ParseFingerprint("")
+	test.Nil(err)
+	test.Equal(ret, "")
+
+	_, err = ParseFingerprint("abcd")
+	test.NotNil(err)
+}
+
+func TestTlsChan(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+	// the flow is stopped if all the channels are gone, the rejected ones
+	// are closed quickly
+	f.Add(1)
+	defer f.Done()
+
+	certFile, keyFile := writeTestCert(t.TempDir())
+	cert, err := LoadCert(certFile, keyFile)
+	test.Nil(err)
+	pin := CertFingerprint(cert.Certificate[0])
+
+	cf := NewChannelFactory("tls", &ChannelOption{Cert: cert})
+	ln, err := cf.Listen(f)
+	test.Nil(err)
+	defer ln.Close()
+	addr := getAddr(ln.Addr())
+
+	recv := packet.NewChan(1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+			svr := cf.NewServer(f, session, conn, &dumpSvrInitDelegate{recv.Send()})
+			go svr.Run()
+		}
+	}()
+
+	// the self-signed cert can't be verified without pin
+	_, err = NewChannelFactory("tls", nil).DialTimeout(addr, time.Second)
+	test.NotNil(err)
+
+	wrong := NewChannelFactory("tls", &ChannelOption{Fingerprint: strings.Repeat("00", 32)})
+	_, err = wrong.DialTimeout(addr, time.Second)
+	test.NotNil(err)
+
+	cliFactory := NewChannelFactory("tls", &ChannelOption{Fingerprint: pin})
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+	conn, err := cliFactory.DialTimeout(addr, time.Second)
+	test.Nil(err)
+	defer conn.Close()
+	cli := cliFactory.NewClient(f, session, conn, packet.NewChan(1).Send())
+	go cli.Run()
+
+	test.True(cli.ChanWrite().SendOneSafe(f, dataPacket))
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ps := <-recv:
+			if len(ps) > 0 {
+				test.Equal(ps[0].Payload(), dataPacket.Payload())
+				return
+			}
+		case <-timeout:
+			t.Fatal("packet is not received")
+		}
+	}
+}
@@ -3,21 +3,28 @@ package dchan
 import (
 	"fmt"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chzyer/flow"
 )
 
+// UDPIdleTimeout is how long a client entry of UDPListener lives without
+// receiving any datagram.
+var UDPIdleTimeout = 30 * time.Second
+
 var _ net.Listener = new(UDPListener)
 
+// UDPListener demultiplexes the datagrams of a udp socket into UDPConn by
+// the remote address.
 type UDPListener struct {
 	flow *flow.Flow
 	conn *net.UDPConn
 
 	acceptChan chan *UDPConn
-	closeChan  chan string
-	conns      map[string]chan []byte
+	conns      map[string]*UDPConn
 
 	writeGuard sync.Mutex
 	connGuard  sync.Mutex
@@ -27,8 +34,7 @@ func NewUDPListener(f *flow.Flow, conn *net.UDPConn) *UDPListener {
 	ln := &UDPListener{
 		conn:       conn,
 		acceptChan: make(chan *UDPConn),
-		closeChan:  make(chan string),
-		conns:      make(map[string]chan []byte),
+		conns:      make(map[string]*UDPConn),
 	}
 	f.ForkTo(&ln.flow, func() {
 		ln.Close()
@@ -44,25 +50,23 @@ func (u *UDPListener) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
 	return n, err
 }
 
-func (u *UDPListener) newConn(addr *net.UDPAddr) *UDPConn {
-	conn := NewUDPConn(u.flow, u.conn.LocalAddr().(*net.UDPAddr), addr, u)
-	u.acceptChan <- conn
-	return conn
-}
-
 func (u *UDPListener) write(addr *net.UDPAddr, b []byte) {
 	u.connGuard.Lock()
-	outGoing, ok := u.conns[addr.String()]
+	conn, ok := u.conns[addr.String()]
 	if !ok {
-		conn := u.newConn(addr)
-		outGoing = conn.WriteChan()
-		u.conns[addr.String()] = outGoing
+		conn = NewUDPConn(u.flow, u.conn.LocalAddr().(*net.UDPAddr), addr, u)
+		u.conns[addr.String()] = conn
 	}
 	u.connGuard.Unlock()
-	select {
-	case outGoing <- b:
-	case <-u.flow.IsClose():
+
+	if !ok {
+		select {
+		case u.acceptChan <- conn:
+		case <-u.flow.IsClose():
+			return
+		}
 	}
+	conn.input(b)
 }
 
 func (u *UDPListener) loop() {
@@ -70,13 +74,39 @@ func (u *UDPListener) loop() {
 	defer u.flow.DoneAndClose()
 
 	buf := make([]byte, 64<<10)
+	lastCheck := time.Now()
 loop:
 	for !u.flow.IsClosed() {
+		if time.Since(lastCheck) >= UDPIdleTimeout/4 {
+			u.closeIdle()
+			lastCheck = time.Now()
+		}
+		u.conn.SetReadDeadline(time.Now().Add(UDPIdleTimeout / 4))
 		n, addr, err := u.conn.ReadFromUDP(buf)
 		if err != nil {
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				continue
+			}
 			break loop
 		}
-		u.write(addr, buf[:n])
+		b := make([]byte, n)
+		copy(b, buf[:n])
+		u.write(addr, b)
+	}
+}
+
+// closeIdle closes the entries which are not active in UDPIdleTimeout.
+func (u *UDPListener) closeIdle() {
+	var idle []*UDPConn
+	u.connGuard.Lock()
+	for _, conn := range u.conns {
+		if conn.idle() >= UDPIdleTimeout {
+			idle = append(idle, conn)
+		}
+	}
+	u.connGuard.Unlock()
+	for _, conn := range idle {
+		conn.Close()
 	}
 }
 
@@ -93,10 +123,18 @@ func (u *UDPListener) Addr() net.Addr {
 	return u.conn.LocalAddr()
 }
 
+// ConnCount returns the count of client entries.
+func (u *UDPListener) ConnCount() int {
+	u.connGuard.Lock()
+	n := len(u.conns)
+	u.connGuard.Unlock()
+	return n
+}
+
 func (u *UDPListener) ClientClose(addr *net.UDPAddr) {
 	u.connGuard.Lock()
 	delete(u.conns, addr.String())
-	u.connGuard.Lock()
+	u.connGuard.Unlock()
 }
 
 func (u *UDPListener) Close() error {
@@ -115,28 +153,29 @@ type writeDelegate interface {
 	ClientClose(*net.UDPAddr)
 }
 
+// UDPConn is a client entry of UDPListener, each Read returns a datagram,
+// the datagrams are dropped if the reader can't catch up.
 type UDPConn struct {
 	flow       *flow.Flow
 	in         chan []byte
 	localAddr  *net.UDPAddr
 	remoteAddr *net.UDPAddr
 	delegate   writeDelegate
+	active     int64 // unix nano of the last datagram
 
-	rdead *time.Timer
-	wdead *time.Timer
+	mutex     sync.Mutex
+	rdeadline time.Time
+	wdeadline time.Time
 }
 
 func NewUDPConn(f *flow.Flow, local, remote *net.UDPAddr, delegate writeDelegate) *UDPConn {
 	conn := &UDPConn{
-		in:         make(chan []byte, 1),
+		in:         make(chan []byte, 64),
 		localAddr:  local,
 		remoteAddr: remote,
 		delegate:   delegate,
-
-		rdead: time.NewTimer(0),
-		wdead: time.NewTimer(0),
+		active:     time.Now().UnixNano(),
 	}
-	conn.SetDeadline(time.Unix(0, 0))
 
 	f.ForkTo(&conn.flow, func() {
 		conn.Close()
@@ -144,6 +183,18 @@ func NewUDPConn(f *flow.Flow, local, remote *net.UDPAddr, delegate writeDelegate
 	return conn
 }
 
+func (u *UDPConn) input(b []byte) {
+	atomic.StoreInt64(&u.active, time.Now().UnixNano())
+	select {
+	case u.in <- b:
+	default:
+	}
+}
+
+func (u *UDPConn) idle() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&u.active))
+}
+
 func (u *UDPConn) LocalAddr() net.Addr {
 	return u.localAddr
 }
@@ -155,20 +206,22 @@ func (u *UDPConn) SetDeadline(d time.Time) error {
 }
 
 func (u *UDPConn) SetReadDeadline(d time.Time) error {
-	return u.setDeadline(u.rdead, d)
+	u.mutex.Lock()
+	u.rdeadline = d
+	u.mutex.Unlock()
+	return nil
 }
 
 func (u *UDPConn) SetWriteDeadline(d time.Time) error {
-	return u.setDeadline(u.wdead, d)
+	u.mutex.Lock()
+	u.wdeadline = d
+	u.mutex.Unlock()
+	return nil
 }
 
-func (u *UDPConn) setDeadline(t *time.Timer, d time.Time) error {
-	if d.IsZero() {
-		t.Stop()
-		return nil
-	}
-	t.Reset(d.Sub(time.Now()))
-	return nil
+func (u *UDPConn) timeoutError(op string) error {
+	return &net.OpError{Op: op, Net: "udp", Source: u.localAddr,
+		Addr: u.remoteAddr, Err: os.ErrDeadlineExceeded}
 }
 
 func (u *UDPConn) RemoteAddr() net.Addr {
@@ -176,28 +229,41 @@ func (u *UDPConn) RemoteAddr() net.Addr {
 }
 
 func (u *UDPConn) Read(b []byte) (int, error) {
+	u.mutex.Lock()
+	deadline := u.rdeadline
+	u.mutex.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
 	select {
 	case buf := <-u.in:
 		n := copy(b, buf)
 		return n, nil
+	case <-timeout:
+		return 0, u.timeoutError("read")
 	case <-u.flow.IsClose():
 		return 0, fmt.Errorf("conn is closed")
 	}
 }
 
 func (u *UDPConn) Write(b []byte) (int, error) {
+	u.mutex.Lock()
+	deadline := u.wdeadline
+	u.mutex.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, u.timeoutError("write")
+	}
 	return u.delegate.WriteToUDP(b, u.remoteAddr)
 }
 
-func (u *UDPConn) WriteChan() chan []byte {
-	return u.in
-}
-
 func (u *UDPConn) Close() error {
 	if !u.flow.MarkExit() {
 		return nil
 	}
-	close(u.in)
 	u.flow.Close()
 	u.delegate.ClientClose(u.remoteAddr)
 	return nil
@@ -0,0 +1,265 @@
+package dchan
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chzyer/next/packet"
+	kcp "github.com/xtaci/kcp-go"
+)
+
+// The kcp packets of udp channel are encrypted by the key derived from the
+// session token of user. The listener is shared by all users, so the user id
+// is sent in plain to find the key:
+//
+//	userId(2) | nonce(16) | crc32(4) | kcp packet
+//
+// everything after the user id is encrypted by the aes kcp.BlockCrypt, the
+// packets which can't be decrypted are dropped.
+const (
+	kcpNonceSize   = 16
+	kcpCryptHeader = 2 + kcpNonceSize + 4
+)
+
+func newKCPBlockCrypt(token []byte) (kcp.BlockCrypt, error) {
+	key := sha256.Sum256(append([]byte("next kcp "), token...))
+	return kcp.NewAESBlockCrypt(key[:])
+}
+
+// the peers are expired if nothing is received in KCPPeerIdle, they are
+// removed once the kcp session is closed too.
+var KCPPeerIdle = 2 * time.Minute
+
+// the addrs whose packets can't be decrypted are ignored in KCPMissTTL, and
+// at most KCPBlockRate blocks are derived per second, so the packets from
+// unknown peers can't keep the server busy.
+var (
+	KCPMissTTL   = 5 * time.Second
+	KCPBlockRate = 100
+)
+
+var kcpBufPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 64<<10)
+	},
+}
+
+type kcpCryptConn struct {
+	net.PacketConn
+
+	// client
+	userId int
+	block  kcp.BlockCrypt
+
+	// server
+	delegate    packet.AuthDelegate
+	mutex       sync.Mutex
+	peers       map[string]*kcpPeer  // addr -> peer
+	misses      map[string]time.Time // addr -> expired
+	lastSweep   time.Time
+	blockSecond time.Time
+	blockCount  int
+}
+
+// kcpPeer caches the block of user, so the token is only looked up in the
+// first packet of a peer.
+type kcpPeer struct {
+	userId int
+	block  kcp.BlockCrypt
+	seen   time.Time
+}
+
+func newKCPCryptCli(conn net.PacketConn, s *packet.Session) (*kcpCryptConn, error) {
+	block, err := newKCPBlockCrypt(s.Token())
+	if err != nil {
+		return nil, err
+	}
+	return &kcpCryptConn{
+		PacketConn: conn,
+		userId:     s.UserId(),
+		block:      block,
+	}, nil
+}
+
+func newKCPCryptSvr(conn net.PacketConn, d packet.AuthDelegate) *kcpCryptConn {
+	return &kcpCryptConn{
+		PacketConn: conn,
+		userId:     -1,
+		delegate:   d,
+		peers:      make(map[string]*kcpPeer),
+		misses:     make(map[string]time.Time),
+		lastSweep:  time.Now(),
+	}
+}
+
+// getBlock returns the block of user from addr, the one of peer is used if
+// it's the same user.
+func (c *kcpCryptConn) getBlock(userId int, addr net.Addr) kcp.BlockCrypt {
+	if c.delegate == nil {
+		if userId != c.userId {
+			return nil
+		}
+		return c.block
+	}
+	block, ok := c.peerBlock(userId, addr)
+	if block != nil || !ok {
+		return block
+	}
+
+	token, _, err := c.delegate.GetUserToken(userId)
+	if err != nil {
+		c.onMiss(addr)
+		return nil
+	}
+	block, err = newKCPBlockCrypt(token)
+	if err != nil {
+		return nil
+	}
+	return block
+}
+
+// peerBlock returns the block of peer if it's the same user, otherwise it
+// reports whether a new block can be derived for addr.
+func (c *kcpCryptConn) peerBlock(userId int, addr net.Addr) (kcp.BlockCrypt, bool) {
+	now := time.Now()
+	key := addr.String()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if peer := c.peers[key]; peer != nil && peer.userId == userId {
+		return peer.block, true
+	}
+	if expired, ok := c.misses[key]; ok && now.Before(expired) {
+		return nil, false
+	}
+	if now.Sub(c.blockSecond) >= time.Second {
+		c.blockSecond, c.blockCount = now, 0
+	}
+	if c.blockCount >= KCPBlockRate {
+		return nil, false
+	}
+	c.blockCount++
+	return nil, true
+}
+
+// onMiss ignores addr for a while if it's not a peer.
+func (c *kcpCryptConn) onMiss(addr net.Addr) {
+	now := time.Now()
+	key := addr.String()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.peers[key] == nil {
+		c.misses[key] = now.Add(KCPMissTTL)
+	}
+	c.sweepLocked(now)
+}
+
+// onRecv remembers the peer after its packet is decrypted.
+func (c *kcpCryptConn) onRecv(addr net.Addr, userId int, block kcp.BlockCrypt) {
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := addr.String()
+	if peer := c.peers[key]; peer != nil && peer.userId == userId {
+		peer.seen = now
+	} else {
+		c.peers[key] = &kcpPeer{userId: userId, block: block, seen: now}
+		delete(c.misses, key)
+	}
+	c.sweepLocked(now)
+}
+
+func (c *kcpCryptConn) sweepLocked(now time.Time) {
+	if now.Sub(c.lastSweep) < KCPPeerIdle/2 {
+		return
+	}
+	for key, peer := range c.peers {
+		if now.Sub(peer.seen) > KCPPeerIdle {
+			delete(c.peers, key)
+		}
+	}
+	for key, expired := range c.misses {
+		if !now.Before(expired) {
+			delete(c.misses, key)
+		}
+	}
+	c.lastSweep = now
+}
+
+func (c *kcpCryptConn) removePeer(addr net.Addr) {
+	c.mutex.Lock()
+	delete(c.peers, addr.String())
+	c.mutex.Unlock()
+}
+
+func (c *kcpCryptConn) getPeer(addr net.Addr) *kcpPeer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.peers[addr.String()]
+}
+
+func (c *kcpCryptConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := kcpBufPool.Get().([]byte)
+	defer kcpBufPool.Put(buf)
+
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, addr, err
+		}
+		if n <= kcpCryptHeader {
+			continue
+		}
+		userId := int(binary.BigEndian.Uint16(buf))
+		block := c.getBlock(userId, addr)
+		if block == nil {
+			continue
+		}
+		data := buf[2:n]
+		block.Decrypt(data, data)
+		checksum := binary.LittleEndian.Uint32(data[kcpNonceSize:])
+		data = data[kcpNonceSize+4:]
+		if crc32.ChecksumIEEE(data) != checksum {
+			if c.delegate != nil {
+				c.onMiss(addr)
+			}
+			continue
+		}
+		if c.delegate != nil {
+			c.onRecv(addr, userId, block)
+		}
+		return copy(b, data), addr, nil
+	}
+}
+
+func (c *kcpCryptConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	userId, block := c.userId, c.block
+	if c.delegate != nil {
+		peer := c.getPeer(addr)
+		if peer == nil {
+			// we never heard from it
+			return len(b), nil
+		}
+		userId, block = peer.userId, peer.block
+	}
+
+	buf := kcpBufPool.Get().([]byte)
+	defer kcpBufPool.Put(buf)
+	if len(b)+kcpCryptHeader > len(buf) {
+		return len(b), nil
+	}
+	buf = buf[:kcpCryptHeader+len(b)]
+	binary.BigEndian.PutUint16(buf, uint16(userId))
+	rand.Read(buf[2 : 2+kcpNonceSize])
+	binary.LittleEndian.PutUint32(buf[2+kcpNonceSize:], crc32.ChecksumIEEE(b))
+	copy(buf[kcpCryptHeader:], b)
+	block.Encrypt(buf[2:], buf[2:])
+	if _, err := c.PacketConn.WriteTo(buf, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,155 @@
+package dchan
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/test"
+)
+
+type testAuthDelegate map[int][]byte
+
+func (d testAuthDelegate) GetUserToken(userId int) ([]byte, packet.Version, error) {
+	token, ok := d[userId]
+	if !ok {
+		return nil, 0, packet.ErrInvalidToken
+	}
+	return token, packet.VersionAEAD, nil
+}
+
+func listenLocalUDP() *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	test.Nil(err)
+	return conn
+}
+
+func TestKCPCryptConn(t *testing.T) {
+	defer test.New(t)
+
+	svrConn := listenLocalUDP()
+	cliConn := listenLocalUDP()
+	defer svrConn.Close()
+	defer cliConn.Close()
+
+	svr := newKCPCryptSvr(svrConn, testAuthDelegate{3: token})
+	cli, err := newKCPCryptCli(cliConn, packet.NewSessionCli(3, token, packet.VersionAEAD))
+	test.Nil(err)
+
+	buf := make([]byte, 1500)
+	_, err = cli.WriteTo([]byte("hello"), svrConn.LocalAddr())
+	test.Nil(err)
+	n, addr, err := svr.ReadFrom(buf)
+	test.Nil(err)
+	test.Equal(string(buf[:n]), "hello")
+
+	_, err = svr.WriteTo([]byte("world"), addr)
+	test.Nil(err)
+	n, _, err = cli.ReadFrom(buf)
+	test.Nil(err)
+	test.Equal(string(buf[:n]), "world")
+
+	// the block of peer is cached, the token is not looked up again
+	svr.delegate = testAuthDelegate{}
+	_, err = cli.WriteTo([]byte("again"), svrConn.LocalAddr())
+	test.Nil(err)
+	n, _, err = svr.ReadFrom(buf)
+	test.Nil(err)
+	test.Equal(string(buf[:n]), "again")
+	svr.removePeer(addr)
+	test.Equal(len(svr.peers), 0)
+	svr.delegate = testAuthDelegate{3: token}
+
+	// the payload is not in plain
+	_, err = cli.WriteTo([]byte("hello"), cliConn.LocalAddr())
+	test.Nil(err)
+	n, _, err = cliConn.ReadFrom(buf)
+	test.Nil(err)
+	test.Equal(n, kcpCryptHeader+5)
+	test.False(bytes.Contains(buf[:n], []byte("hello")))
+
+	// wrong token is dropped
+	other := listenLocalUDP()
+	defer other.Close()
+	wrong, err := newKCPCryptCli(other, packet.NewSessionCli(3, test.RandBytes(32), packet.VersionAEAD))
+	test.Nil(err)
+	_, err = wrong.WriteTo([]byte("hello"), svrConn.LocalAddr())
+	test.Nil(err)
+	svrConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = svr.ReadFrom(buf)
+	test.NotNil(err)
+	test.Equal(len(svr.misses), 1)
+
+	// the addr is ignored for a while, even with the right token
+	right, err := newKCPCryptCli(other, packet.NewSessionCli(3, token, packet.VersionAEAD))
+	test.Nil(err)
+	_, err = right.WriteTo([]byte("hello"), svrConn.LocalAddr())
+	test.Nil(err)
+	svrConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = svr.ReadFrom(buf)
+	test.NotNil(err)
+
+	// the blocks are derived in a limited rate
+	svr.misses = make(map[string]time.Time)
+	svr.blockCount = KCPBlockRate
+	_, err = right.WriteTo([]byte("hello"), svrConn.LocalAddr())
+	test.Nil(err)
+	svrConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = svr.ReadFrom(buf)
+	test.NotNil(err)
+	svr.blockSecond = time.Now().Add(-time.Second)
+	_, err = right.WriteTo([]byte("hello"), svrConn.LocalAddr())
+	test.Nil(err)
+	svrConn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err = svr.ReadFrom(buf)
+	test.Nil(err)
+	test.Equal(string(buf[:n]), "hello")
+}
+
+func TestUdpChanCrypt(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+
+	cf := NewChannelFactory("udp", &ChannelOption{Crypt: true})
+	ln, err := cf.(authListener).ListenAuth(f, testAuthDelegate{0: token})
+	test.Nil(err)
+	defer ln.Close()
+
+	recv := packet.NewChan(1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+		svr := cf.NewServer(f, session, conn, &dumpSvrInitDelegate{recv.Send()})
+		go svr.Run()
+	}()
+
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+	conn, err := cf.(sessionDialer).DialSession(getAddr(ln.Addr()), time.Second, session)
+	test.Nil(err)
+	// the channel waits the read deadline if the conn is not closed
+	defer conn.Close()
+	cli := cf.NewClient(f, session, conn, packet.NewChan(1).Send())
+	go cli.Run()
+
+	test.True(cli.ChanWrite().SendOneSafe(f, dataPacket))
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ps := <-recv:
+			if len(ps) > 0 {
+				test.Equal(ps[0].Payload(), dataPacket.Payload())
+				return
+			}
+		case <-timeout:
+			t.Fatal("packet is not received")
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package dchan
 
 import (
+	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/chzyer/flow"
@@ -11,52 +13,201 @@ import (
 
 type UdpChanFactory struct {
 	port int32
+	opt  ChannelOption
 }
 
-func NewUdpChanFactory() *UdpChanFactory {
-	return &UdpChanFactory{
+func NewUdpChanFactory(opt *ChannelOption) *UdpChanFactory {
+	u := &UdpChanFactory{
 		port: 10000,
 	}
+	if opt != nil {
+		u.opt = *opt
+	}
+	return u
 }
 
 type wrapLn struct {
-	net.Listener
+	*kcp.Listener
+	opt      *KCPOption
+	overhead int
+	crypt    *kcpCryptConn // nil if the kcp session is not encrypted
 }
 
 func (w *wrapLn) Accept() (net.Conn, error) {
-	sess, err := w.Listener.Accept()
+	sess, err := w.Listener.AcceptKCP()
 	if err != nil {
 		return nil, err
 	}
-	// sess.SetMtu(1350)
-	// sess.SetNoDelay(1, 20, 2, 1)
-	// sess.SetWindowSize(2048, 2048)
+	tuneKCP(sess, w.opt, w.overhead)
+	if w.crypt != nil {
+		return &kcpCryptSess{sess, w.crypt}, nil
+	}
 	return sess, nil
 }
 
+// kcpCryptSess forgets the peer in kcpCryptConn after it's closed.
+type kcpCryptSess struct {
+	*kcp.UDPSession
+	crypt *kcpCryptConn
+}
+
+func (s *kcpCryptSess) Close() error {
+	s.crypt.removePeer(s.RemoteAddr())
+	return s.UDPSession.Close()
+}
+
+// the overhead is the bytes added by the conn under kcp
+func tuneKCP(sess *kcp.UDPSession, opt *KCPOption, overhead int) {
+	mtu := kcp.IKCP_MTU_DEF
+	if opt != nil && opt.MTU > 0 {
+		mtu = opt.MTU
+	}
+	if mtu != kcp.IKCP_MTU_DEF || overhead > 0 {
+		sess.SetMtu(mtu - overhead)
+	}
+	if opt == nil {
+		return
+	}
+	// the interval is 0 if only the window or mtu is tuned
+	if opt.Interval > 0 {
+		sess.SetNoDelay(opt.NoDelay, opt.Interval, opt.Resend, opt.NoCongestion)
+	}
+	if opt.SndWnd > 0 || opt.RcvWnd > 0 {
+		sess.SetWindowSize(opt.SndWnd, opt.RcvWnd)
+	}
+}
+
 func (u *UdpChanFactory) Listen(f *flow.Flow) (net.Listener, error) {
-	ln, err := kcp.Listen(":0")
+	ln, err := kcp.ListenWithOptions(":0", nil, u.opt.DataShards, u.opt.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &wrapLn{ln, u.opt.KCP, 0, nil}, nil
+}
+
+// ListenAuth listens the encrypted kcp session if it's enabled, the keys of
+// users are found by delegate.
+func (u *UdpChanFactory) ListenAuth(f *flow.Flow, d packet.AuthDelegate) (net.Listener, error) {
+	if !u.opt.Crypt {
+		return u.Listen(f)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	crypt := newKCPCryptSvr(conn, d)
+	ln, err := kcp.ServeConn(nil, u.opt.DataShards, u.opt.ParityShards, crypt)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wrapLn{ln, u.opt.KCP, kcpCryptHeader, crypt}, nil
+}
+
+// DialSession dials the encrypted kcp session if it's enabled.
+func (u *UdpChanFactory) DialSession(host string, timeout time.Duration, s *packet.Session) (net.Conn, error) {
+	if !u.opt.Crypt {
+		return u.DialTimeout(host, timeout)
+	}
+	addr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if addr.IP.To4() == nil {
+		network = "udp"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	cryptConn, err := newKCPCryptCli(conn, s)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	sess, err := kcp.NewConn2(addr, nil, u.opt.DataShards, u.opt.ParityShards, cryptConn)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	return &wrapLn{ln}, nil
+	tuneKCP(sess, u.opt.KCP, kcpCryptHeader)
+	return sess, nil
 }
 
-func (UdpChanFactory) DialTimeout(host string, timeout time.Duration) (net.Conn, error) {
-	sess, err := kcp.Dial(host)
+func (u *UdpChanFactory) DialTimeout(host string, timeout time.Duration) (net.Conn, error) {
+	sess, err := kcp.DialWithOptions(host, nil, u.opt.DataShards, u.opt.ParityShards)
 	if err != nil {
 		return nil, err
 	}
-	// sess.SetMtu(1350)
-	// sess.SetNoDelay(1, 20, 2, 1)
-	// sess.SetWindowSize(256, 2048)
+	tuneKCP(sess, u.opt.KCP, 0)
 	return sess, nil
 }
 
-func (UdpChanFactory) NewClient(f *flow.Flow, session *packet.Session, conn net.Conn, out packet.SendChan) Channel {
-	return NewHttpChanClient(f, session, conn, out)
+func (u *UdpChanFactory) NewClient(f *flow.Flow, session *packet.Session, conn net.Conn, out packet.SendChan) Channel {
+	return u.wrap(NewHttpChanClient(f, session, conn, out))
+}
+
+func (u *UdpChanFactory) NewServer(f *flow.Flow, session *packet.Session, conn net.Conn, delegate SvrInitDelegate) Channel {
+	return u.wrap(NewHttpChanServer(f, session, conn, delegate))
+}
+
+func (u *UdpChanFactory) wrap(ch *HttpChan) Channel {
+	if !u.opt.HasFEC() {
+		return ch
+	}
+	return &udpChan{ch, u.opt}
+}
+
+// udpChan is the kcp channel with fec
+type udpChan struct {
+	*HttpChan
+	opt ChannelOption
+}
+
+func (u *udpChan) GetFEC() *FECStat {
+	return &FECStat{
+		DataShards:   u.opt.DataShards,
+		ParityShards: u.opt.ParityShards,
+		Overhead:     u.opt.FECOverhead(),
+	}
+}
+
+// FECStat is the forward error correction of channel.
+type FECStat struct {
+	DataShards   int     `json:"data_shards"`
+	ParityShards int     `json:"parity_shards"`
+	Overhead     float64 `json:"overhead"`
+}
+
+func (s *FECStat) String() string {
+	return fmt.Sprintf("FEC: %v/%v (+%.0f%%)",
+		s.DataShards, s.ParityShards, s.Overhead*100)
+}
+
+// FECCounters are shared by all udp channels in the process, since kcp only
+// counts them globally.
+type FECCounters struct {
+	ParityReceived uint64 `json:"parity_received"`
+	Recovered      uint64 `json:"recovered"`
+	Errors         uint64 `json:"errors"`
+	Short          uint64 `json:"short"`
+}
+
+func GetFECCounters() *FECCounters {
+	return &FECCounters{
+		ParityReceived: atomic.LoadUint64(&kcp.DefaultSnmp.FECParityShards),
+		Recovered:      atomic.LoadUint64(&kcp.DefaultSnmp.FECRecovered),
+		Errors:         atomic.LoadUint64(&kcp.DefaultSnmp.FECErrs),
+		Short:          atomic.LoadUint64(&kcp.DefaultSnmp.FECShortShards),
+	}
+}
+
+func (c *FECCounters) String() string {
+	return fmt.Sprintf("FEC of all channels: parity: %v, recovered: %v, errors: %v, short: %v",
+		c.ParityReceived, c.Recovered, c.Errors, c.Short)
 }
 
-func (UdpChanFactory) NewServer(f *flow.Flow, session *packet.Session, conn net.Conn, delegate SvrInitDelegate) Channel {
-	return NewHttpChanServer(f, session, conn, delegate)
+type fecChannel interface {
+	GetFEC() *FECStat
 }
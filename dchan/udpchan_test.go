@@ -24,7 +24,7 @@ func handleListener(ln *UDPListener) {
 	}
 }
 
-func nTestUdpChan(t *testing.T) {
+func TestUDPListener(t *testing.T) {
 	defer test.New(t)
 
 	addr, err := net.ResolveUDPAddr("udp", ":0")
@@ -33,6 +33,7 @@ func nTestUdpChan(t *testing.T) {
 	test.Nil(err)
 
 	f := flow.New()
+	defer f.Close()
 
 	ln := NewUDPListener(f, conn)
 	defer ln.Close()
@@ -42,9 +43,49 @@ func nTestUdpChan(t *testing.T) {
 		addr := conn.LocalAddr().String()
 		conn, err := net.DialTimeout("udp", addr, time.Second)
 		test.Nil(err)
+		defer conn.Close()
 		test.WriteString(conn, "hello")
 		test.WriteString(conn, "aaa")
 		test.ReadString(conn, "hello!too!")
 		test.Read(conn, data)
 	}
 }
+
+func TestUDPListenerIdle(t *testing.T) {
+	defer test.New(t)
+
+	old := UDPIdleTimeout
+	UDPIdleTimeout = 100 * time.Millisecond
+	defer func() { UDPIdleTimeout = old }()
+
+	f := flow.New()
+	defer f.Close()
+	ln := NewUDPListener(f, listenLocalUDP())
+	defer ln.Close()
+
+	cli, err := net.Dial("udp", ln.Addr().String())
+	test.Nil(err)
+	defer cli.Close()
+	test.WriteString(cli, "hello")
+
+	conn, err := ln.Accept()
+	test.Nil(err)
+	test.ReadString(conn, "hello")
+	test.Equal(ln.ConnCount(), 1)
+
+	// the deadline is respected
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 10))
+	test.True(err.(net.Error).Timeout())
+	conn.SetReadDeadline(time.Time{})
+
+	_, err = conn.Read(make([]byte, 10))
+	test.NotNil(err)
+	test.Equal(ln.ConnCount(), 0)
+
+	// a new entry is created for the same address
+	test.WriteString(cli, "again")
+	conn, err = ln.Accept()
+	test.Nil(err)
+	test.ReadString(conn, "again")
+}
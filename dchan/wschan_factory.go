@@ -0,0 +1,250 @@
+package dchan
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/packet"
+	"github.com/gorilla/websocket"
+)
+
+var _ ChannelFactory = new(WsChanFactory)
+
+// WsChanFactory carries the frames of TcpChan in binary websocket messages,
+// it can be served behind a reverse proxy or cdn.
+type WsChanFactory struct {
+	opt ChannelOption
+}
+
+func NewWsChanFactory(opt *ChannelOption) *WsChanFactory {
+	w := &WsChanFactory{}
+	if opt != nil {
+		w.opt = *opt
+	}
+	return w
+}
+
+func (w *WsChanFactory) path() string {
+	if w.opt.WSPath == "" {
+		return "/"
+	}
+	return w.opt.WSPath
+}
+
+func (w *WsChanFactory) Listen(f *flow.Flow) (net.Listener, error) {
+	addr := w.opt.ListenAddr
+	if addr == "" {
+		addr = ":0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	wl := &wsListener{
+		Listener: ln,
+		accept:   make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(w.path(), wl.ServeHTTP)
+	wl.svr = &http.Server{Handler: mux}
+	go wl.svr.Serve(ln)
+	return wl, nil
+}
+
+// DialTimeout dials the url in option if it's set, it's used if the server
+// is behind a reverse proxy.
+func (w *WsChanFactory) DialTimeout(host string, timeout time.Duration) (net.Conn, error) {
+	url := w.opt.URL
+	if url == "" {
+		url = "ws://" + host + w.path()
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: timeout,
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, logex.Trace(err, url)
+	}
+	return newWsConn(conn), nil
+}
+
+func (w *WsChanFactory) NewClient(f *flow.Flow, session *packet.Session, conn net.Conn, out packet.SendChan) Channel {
+	return NewTcpChanClient(f, session, conn, out)
+}
+
+func (w *WsChanFactory) NewServer(f *flow.Flow, session *packet.Session, conn net.Conn, delegate SvrInitDelegate) Channel {
+	return NewTcpChanServer(f, session, conn, delegate)
+}
+
+var wsUpgrader = websocket.Upgrader{
+	// the clients are not browsers
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+type wsListener struct {
+	net.Listener
+	svr       *http.Server
+	accept    chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *wsListener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !websocket.IsWebSocketUpgrade(req) {
+		http.NotFound(w, req)
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	select {
+	case l.accept <- newWsConn(conn):
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, logex.Trace(io.ErrClosedPipe, "listener closed")
+	}
+}
+
+func (l *wsListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return l.svr.Close()
+}
+
+// wsConn reads the binary messages as a stream, and writes each buffer as a
+// message. The read deadline is handled here, the websocket conn is broken
+// after a read timeout.
+type wsConn struct {
+	conn   *websocket.Conn
+	msgs   chan []byte
+	buf    []byte
+	err    error // valid after msgs is closed
+	closed chan struct{}
+	once   sync.Once
+
+	mutex    sync.Mutex
+	deadline time.Time
+	wmutex   sync.Mutex
+}
+
+func newWsConn(conn *websocket.Conn) *wsConn {
+	c := &wsConn{
+		conn:   conn,
+		msgs:   make(chan []byte, 16),
+		closed: make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *wsConn) readLoop() {
+	defer close(c.msgs)
+	for {
+		typ, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.err = err
+			return
+		}
+		if typ != websocket.BinaryMessage {
+			continue
+		}
+		select {
+		case c.msgs <- data:
+		case <-c.closed:
+			c.err = io.ErrClosedPipe
+			return
+		}
+	}
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	if len(c.buf) == 0 {
+		c.mutex.Lock()
+		deadline := c.deadline
+		c.mutex.Unlock()
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case data, ok := <-c.msgs:
+			if !ok {
+				return 0, c.err
+			}
+			c.buf = data
+		case <-timeout:
+			return 0, &net.OpError{Op: "read", Net: "ws",
+				Addr: c.RemoteAddr(), Err: os.ErrDeadlineExceeded}
+		}
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return c.conn.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.deadline = t
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// IsWsURL reports whether the url is a websocket one.
+func IsWsURL(url string) bool {
+	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
+}
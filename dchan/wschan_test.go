@@ -0,0 +1,73 @@
+package dchan
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/test"
+)
+
+func TestWsChan(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	defer f.Close()
+
+	cf := NewChannelFactory("ws", &ChannelOption{WSPath: "/next"})
+	ln, err := cf.Listen(f)
+	test.Nil(err)
+	defer ln.Close()
+	addr := getAddr(ln.Addr())
+
+	// plain http request is not upgraded
+	resp, err := http.Get("http://" + addr + "/next")
+	test.Nil(err)
+	resp.Body.Close()
+	test.Equal(resp.StatusCode, http.StatusNotFound)
+
+	// dial through a reverse proxy
+	target, err := url.Parse("http://" + addr)
+	test.Nil(err)
+	proxy := httptest.NewServer(httputil.NewSingleHostReverseProxy(target))
+	defer proxy.Close()
+	proxyURL := "ws://" + proxy.Listener.Addr().String() + "/next"
+	cliFactory := NewChannelFactory("ws", &ChannelOption{URL: proxyURL})
+
+	recv := packet.NewChan(1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+		svr := cf.NewServer(f, session, conn, &dumpSvrInitDelegate{recv.Send()})
+		go svr.Run()
+	}()
+
+	session := packet.NewSessionCli(0, token, packet.VersionAEAD)
+	conn, err := cliFactory.DialTimeout("unused", time.Second)
+	test.Nil(err)
+	defer conn.Close()
+	cli := cliFactory.NewClient(f, session, conn, packet.NewChan(1).Send())
+	go cli.Run()
+
+	test.True(cli.ChanWrite().SendOneSafe(f, dataPacket))
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ps := <-recv:
+			if len(ps) > 0 {
+				test.Equal(ps[0].Payload(), dataPacket.Payload())
+				return
+			}
+		case <-timeout:
+			t.Fatal("packet is not received")
+		}
+	}
+}
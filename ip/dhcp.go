@@ -1,5 +1,10 @@
 package ip
 
+import (
+	"math/bits"
+	"sync"
+)
+
 // use for alloc ip address
 type DHCP struct {
 	IPNet     *IPNet
@@ -7,6 +12,7 @@ type DHCP struct {
 	Boardcast IP
 	IpSize    int
 	bitmap    []byte
+	m         sync.Mutex
 }
 
 func NewDHCP(ipnet *IPNet) *DHCP {
@@ -33,6 +39,9 @@ func NewDHCP(ipnet *IPNet) *DHCP {
 }
 
 func (d *DHCP) IsExistIP(ip IP) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+
 	ipInt := ip.Int()
 	gateway := d.Gateway.Int()
 	boardcast := d.Boardcast.Int()
@@ -48,6 +57,9 @@ func (d *DHCP) IsExistIP(ip IP) bool {
 }
 
 func (d *DHCP) Release(ip IP) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+
 	ipInt := ip.Int()
 	gateway := d.Gateway.Int()
 	boardcast := d.Boardcast.Int()
@@ -62,7 +74,31 @@ func (d *DHCP) Release(ip IP) bool {
 	}
 }
 
+// Reserve marks the specified ip as allocated, it returns false if the
+// ip is already allocated or out of range.
+func (d *DHCP) Reserve(ip IP) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	ipInt := ip.Int()
+	gateway := d.Gateway.Int()
+	boardcast := d.Boardcast.Int()
+	if ipInt <= gateway || ipInt >= boardcast {
+		return false
+	}
+	offset := ipInt - gateway - 1
+	idx := offset / 8
+	if d.bitmap[idx]&(1<<(offset&7)) > 0 {
+		return false
+	}
+	d.bitmap[idx] |= 1 << (offset & 7)
+	return true
+}
+
 func (d *DHCP) Alloc() *IP {
+	d.m.Lock()
+	defer d.m.Unlock()
+
 	gateway := d.Gateway.Int() + 1
 	boardcast := d.Boardcast.Int()
 	for i := 0; i < len(d.bitmap); i++ {
@@ -84,3 +120,18 @@ func (d *DHCP) Alloc() *IP {
 	}
 	return nil
 }
+
+// Used returns how many ips are allocated.
+func (d *DHCP) Used() int {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return countBits(d.bitmap)
+}
+
+func countBits(bitmap []byte) int {
+	n := 0
+	for _, b := range bitmap {
+		n += bits.OnesCount8(b)
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+package ip
+
+import "sync"
+
+// the hosts of a v6 prefix are too many to be kept in bitmap
+const DHCP6MaxHosts = 1 << 16
+
+// DHCP6 allocates the addresses after the gateway in a v6 prefix.
+type DHCP6 struct {
+	IPNet   *IPNet6
+	Gateway IP6
+	IpSize  int
+	bitmap  []byte
+	m       sync.Mutex
+}
+
+func NewDHCP6(ipnet *IPNet6) *DHCP6 {
+	ones, bits := ipnet.Mask.Size()
+	ipSize := DHCP6MaxHosts
+	if bits-ones < 17 {
+		ipSize = 1<<uint(bits-ones) - 1
+	}
+	// gateway offset
+	network := CopyIP6(ipnet.IP.IP().Mask(ipnet.Mask))
+	ipSize -= int(ipnet.IP.Sub(network))
+	if ipSize < 0 {
+		ipSize = 0
+	}
+
+	return &DHCP6{
+		IPNet:   ipnet,
+		Gateway: ipnet.IP,
+		IpSize:  ipSize,
+		bitmap:  make([]byte, (ipSize+7)/8),
+	}
+}
+
+// offset of ip in bitmap, -1 if ip is out of range
+func (d *DHCP6) offset(ip IP6) int {
+	if !d.IPNet.Contains(ip) {
+		return -1
+	}
+	diff := ip.Sub(d.Gateway)
+	if diff == 0 || diff > uint64(d.IpSize) {
+		return -1
+	}
+	return int(diff - 1)
+}
+
+func (d *DHCP6) IsExistIP(ip IP6) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if ip == d.Gateway {
+		return true
+	}
+	offset := d.offset(ip)
+	if offset < 0 {
+		return false
+	}
+	return d.bitmap[offset/8]&(1<<uint(offset&7)) > 0
+}
+
+func (d *DHCP6) Release(ip IP6) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	offset := d.offset(ip)
+	if offset < 0 {
+		return false
+	}
+	isExist := d.bitmap[offset/8]&(1<<uint(offset&7)) > 0
+	d.bitmap[offset/8] &= ^(1 << uint(offset&7))
+	return isExist
+}
+
+func (d *DHCP6) Reserve(ip IP6) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	offset := d.offset(ip)
+	if offset < 0 || d.bitmap[offset/8]&(1<<uint(offset&7)) > 0 {
+		return false
+	}
+	d.bitmap[offset/8] |= 1 << uint(offset&7)
+	return true
+}
+
+func (d *DHCP6) Alloc() *IP6 {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	for i := 0; i < len(d.bitmap); i++ {
+		if d.bitmap[i] == 255 {
+			continue
+		}
+		for j := 0; j < 8; j++ {
+			offset := i*8 + j
+			if offset >= d.IpSize {
+				break
+			}
+			if d.bitmap[i]&(1<<uint(j)) == 0 {
+				d.bitmap[i] |= 1 << uint(j)
+				ip := d.Gateway.Add(uint64(offset + 1))
+				return &ip
+			}
+		}
+	}
+	return nil
+}
+
+// Used returns how many ips are allocated.
+func (d *DHCP6) Used() int {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return countBits(d.bitmap)
+}
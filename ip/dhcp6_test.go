@@ -0,0 +1,57 @@
+package ip
+
+import (
+	"testing"
+
+	"github.com/chzyer/test"
+)
+
+func TestIP6(t *testing.T) {
+	defer test.New(t)
+
+	ip := ParseIP6("fd00::ff")
+	test.Equal(ip.Add(1), ParseIP6("fd00::100"))
+	test.Equal(ip.Add(0x101).Sub(ip), uint64(0x101))
+
+	_, err := ParseCIDR6("10.8.0.1/24")
+	test.NotNil(err)
+	ipnet, err := ParseCIDR6("fd00::1/64")
+	test.Nil(err)
+	test.Equal(ipnet.String(), "fd00::1/64")
+	test.True(ipnet.Contains(ParseIP6("fd00::ffff")))
+	test.False(ipnet.Contains(ParseIP6("fd01::1")))
+}
+
+func TestDHCP6(t *testing.T) {
+	defer test.New(t)
+	ipnet, err := ParseCIDR6("fd00::1/64")
+	test.Nil(err)
+	d := NewDHCP6(ipnet)
+	test.Equal(d.IpSize, DHCP6MaxHosts-1)
+
+	first := d.Alloc()
+	test.NotNil(first)
+	test.Equal(*first, ParseIP6("fd00::2"))
+	test.Should(d.IsExistIP(*first))
+	test.Should(d.IsExistIP(ParseIP6("fd00::1")))
+	test.Should(!d.IsExistIP(ParseIP6("fd00::3")))
+
+	test.Should(d.Reserve(ParseIP6("fd00::3")))
+	test.Should(!d.Reserve(ParseIP6("fd00::3")))
+	test.Should(!d.Reserve(ParseIP6("fd01::3")))
+	test.Equal(*d.Alloc(), ParseIP6("fd00::4"))
+
+	test.Should(d.Release(*first))
+	test.Should(!d.Release(ParseIP6("fd00::1")))
+	test.Equal(*d.Alloc(), *first)
+
+	// a small prefix can be full
+	ipnet, err = ParseCIDR6("fd00::1/125")
+	test.Nil(err)
+	d = NewDHCP6(ipnet)
+	test.Equal(d.IpSize, 6)
+	for i := 0; i < d.IpSize; i++ {
+		test.NotNil(d.Alloc())
+	}
+	test.Nil(d.Alloc())
+}
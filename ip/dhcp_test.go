@@ -20,8 +20,10 @@ func TestDHCP(t *testing.T) {
 	second := d.Alloc()
 	test.NotNil(second)
 	test.Equal(*second, ParseIP("10.6.0.3"))
+	test.Equal(d.Used(), 2)
 
 	test.Should(d.Release(*first))
+	test.Equal(d.Used(), 1)
 	test.Should(!d.Release(ParseIP("10.6.0.10")))
 	test.Should(!d.IsExistIP(*first))
 
@@ -37,3 +39,22 @@ func TestDHCP(t *testing.T) {
 	// must be full
 	test.Nil(d.Alloc())
 }
+
+func TestDHCPReserve(t *testing.T) {
+	defer test.New(t)
+	ipnet, err := ParseCIDR("10.6.0.1/24")
+	test.Nil(err)
+	d := NewDHCP(ipnet)
+
+	test.Should(d.Reserve(ParseIP("10.6.0.2")))
+	test.Should(!d.Reserve(ParseIP("10.6.0.2")))
+	test.Should(!d.Reserve(ParseIP("10.6.0.1")))
+	test.Should(!d.Reserve(ParseIP("10.6.0.255")))
+	test.Should(!d.Reserve(ParseIP("10.6.1.2")))
+	test.Should(d.IsExistIP(ParseIP("10.6.0.2")))
+
+	// the reserved one is skipped
+	test.Equal(*d.Alloc(), ParseIP("10.6.0.3"))
+	test.Should(d.Release(ParseIP("10.6.0.2")))
+	test.Equal(*d.Alloc(), ParseIP("10.6.0.2"))
+}
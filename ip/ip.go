@@ -16,6 +16,9 @@ func IsIP(s string) bool {
 	if idx := strings.Index(s, "/"); idx >= 0 {
 		s = s[:idx]
 	}
+	if strings.Contains(s, ":") {
+		return net.ParseIP(s) != nil
+	}
 	rs := []rune(s)
 	for _, r := range rs {
 		if (r >= '0' && r <= '9') || r == '.' {
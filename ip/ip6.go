@@ -0,0 +1,103 @@
+package ip
+
+import (
+	"net"
+	"reflect"
+
+	"github.com/chzyer/flagly"
+	"github.com/chzyer/logex"
+)
+
+var (
+	ErrNotIPv6 = logex.Define("'%v' is not an ipv6 address")
+)
+
+type IP6 [16]byte
+
+func NewIP6(b []byte) (ret IP6) {
+	copy(ret[:], b)
+	return
+}
+
+func ParseIP6(s string) IP6 {
+	return CopyIP6(net.ParseIP(s))
+}
+
+func CopyIP6(ip net.IP) IP6 {
+	return NewIP6(ip.To16())
+}
+
+func (ip IP6) Equal(ip2 IP6) bool {
+	return ip == ip2
+}
+
+func (ip IP6) IP() net.IP {
+	return net.IP(ip[:])
+}
+
+func (ip IP6) String() string {
+	return ip.IP().String()
+}
+
+// Add returns ip + n, the carry is applied on the low 8 bytes only.
+func (ip IP6) Add(n uint64) IP6 {
+	for i := 15; i >= 8 && n > 0; i-- {
+		sum := uint64(ip[i]) + n&0xff
+		ip[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	return ip
+}
+
+// Sub returns ip - ip2, only the low 8 bytes are counted.
+func (ip IP6) Sub(ip2 IP6) uint64 {
+	var a, b uint64
+	for i := 8; i < 16; i++ {
+		a = a<<8 | uint64(ip[i])
+		b = b<<8 | uint64(ip2[i])
+	}
+	return a - b
+}
+
+type IPNet6 struct {
+	IP   IP6        // the address of gateway
+	Mask net.IPMask // prefix
+}
+
+func ParseCIDR6(s string) (*IPNet6, error) {
+	addr, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	if addr.To4() != nil {
+		return nil, ErrNotIPv6.Format(s)
+	}
+	return &IPNet6{
+		IP:   CopyIP6(addr),
+		Mask: ipnet.Mask,
+	}, nil
+}
+
+func (in *IPNet6) ToNet() *net.IPNet {
+	return &net.IPNet{
+		IP:   in.IP.IP(),
+		Mask: in.Mask,
+	}
+}
+
+func (in *IPNet6) Contains(ip IP6) bool {
+	return in.ToNet().Contains(ip.IP())
+}
+
+func (in *IPNet6) String() string {
+	return in.ToNet().String()
+}
+
+func (IPNet6) ParseArgs(args []string) (reflect.Value, error) {
+	n, err := ParseCIDR6(args[0])
+	if err != nil {
+		return flagly.NilValue, err
+	}
+	return reflect.ValueOf(n), nil
+}
+func (IPNet6) Type() reflect.Type { return reflect.TypeOf(IPNet6{}) }
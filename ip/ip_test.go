@@ -30,3 +30,14 @@ func TestMatchIPNet(t *testing.T) {
 		test.Equal(MatchIPNet(child, parent), r.Match)
 	}
 }
+
+func TestIsIP(t *testing.T) {
+	defer test.New(t)
+
+	test.True(IsIP("10.8.0.1"))
+	test.True(IsIP("10.8.0.0/24"))
+	test.True(IsIP("fd00::1"))
+	test.True(IsIP("fd00::/64"))
+	test.False(IsIP("google.com"))
+	test.False(IsIP("localhost:80"))
+}
@@ -0,0 +1,147 @@
+// Package metrics exports the metrics in the prometheus text format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chzyer/flow"
+)
+
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples []sample
+}
+
+// Writer collects the samples and groups them by name, labels are pairs of
+// name and value.
+type Writer struct {
+	families []*family
+	index    map[string]*family
+}
+
+func NewWriter() *Writer {
+	return &Writer{index: make(map[string]*family)}
+}
+
+func (w *Writer) Gauge(name, help string, value float64, labels ...string) {
+	w.add(name, help, Gauge, value, labels)
+}
+
+func (w *Writer) Counter(name, help string, value float64, labels ...string) {
+	w.add(name, help, Counter, value, labels)
+}
+
+func (w *Writer) add(name, help, typ string, value float64, labels []string) {
+	f := w.index[name]
+	if f == nil {
+		f = &family{name: name, help: help, typ: typ}
+		w.index[name] = f
+		w.families = append(w.families, f)
+	}
+	f.samples = append(f.samples, sample{
+		labels: formatLabels(labels),
+		value:  value,
+	})
+}
+
+// With returns a copy of labels with the extra pairs.
+func With(labels []string, pairs ...string) []string {
+	ret := make([]string, 0, len(labels)+len(pairs))
+	return append(append(ret, labels...), pairs...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(labels[i])
+		buf.WriteString(`="`)
+		buf.WriteString(labelEscaper.Replace(labels[i+1]))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	buf := bytes.NewBuffer(nil)
+	families := append([]*family(nil), w.families...)
+	sort.SliceStable(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+	for _, f := range families {
+		fmt.Fprintf(buf, "# HELP %v %v\n", f.name, f.help)
+		fmt.Fprintf(buf, "# TYPE %v %v\n", f.name, f.typ)
+		for _, s := range f.samples {
+			fmt.Fprintf(buf, "%v%v %v\n", f.name, s.labels,
+				strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+	return buf.WriteTo(out)
+}
+
+// Collector writes the current metrics.
+type Collector interface {
+	CollectMetrics(w *Writer)
+}
+
+func Handler(c Collector) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		w := NewWriter()
+		c.CollectMetrics(w)
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteTo(rw)
+	})
+}
+
+// ListenAndServe serves the metrics in /metrics until f is closed.
+func ListenAndServe(f *flow.Flow, addr string, c Collector) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(c))
+	svr := &http.Server{Handler: mux}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-f.IsClose():
+			svr.Close()
+		case <-done:
+		}
+	}()
+	if err := svr.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func TestWriter(t *testing.T) {
+	defer test.New(t)
+
+	w := NewWriter()
+	w.Gauge("next_rtt_seconds", "rtt", 0.5, "channel", "a")
+	w.Counter("next_bytes_total", "bytes", 1024, "dir", "up", "user", `a"b`)
+	w.Gauge("next_rtt_seconds", "rtt", 1, "channel", "b")
+	w.Gauge("next_online", "online users", 3)
+
+	buf := bytes.NewBuffer(nil)
+	_, err := w.WriteTo(buf)
+	test.Nil(err)
+	test.Equal(buf.String(), `# HELP next_bytes_total bytes
+# TYPE next_bytes_total counter
+next_bytes_total{dir="up",user="a\"b"} 1024
+# HELP next_online online users
+# TYPE next_online gauge
+next_online 3
+# HELP next_rtt_seconds rtt
+# TYPE next_rtt_seconds gauge
+next_rtt_seconds{channel="a"} 0.5
+next_rtt_seconds{channel="b"} 1
+`)
+}
+
+type testCollector struct{}
+
+func (testCollector) CollectMetrics(w *Writer) {}
+
+func TestListenAndServe(t *testing.T) {
+	defer test.New(t)
+
+	f := flow.New()
+	ret := make(chan error, 1)
+	go func() {
+		ret <- ListenAndServe(f, "127.0.0.1:0", testCollector{})
+	}()
+	f.Close()
+	select {
+	case err := <-ret:
+		test.Nil(err)
+	case <-time.After(time.Second):
+		t.Fatal("listener is not closed with the flow")
+	}
+}
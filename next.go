@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
@@ -18,6 +19,7 @@ import (
 	"github.com/chzyer/next/server"
 	"github.com/chzyer/next/uc"
 	"github.com/chzyer/next/util"
+	"github.com/chzyer/next/util/shellout"
 	"github.com/chzyer/readline"
 )
 
@@ -67,6 +69,7 @@ func (NextGenKey) FlaglyDesc() string {
 
 type NextShell struct {
 	Sock string   `default:"/tmp/next.sock"`
+	JSON bool     `name:"json" desc:"print a json line for each command, {\"result\": ...} or {\"error\": \"...\"}"`
 	Args []string `type:"[]"`
 }
 
@@ -90,9 +93,36 @@ func (n *NextShell) FlaglyHandle(f *flow.Flow) error {
 	} else {
 		source = os.Stdin
 	}
+	if n.JSON {
+		cli.MarkIsTerminal(false)
+		source = &prefixReader{
+			r:      bufio.NewReader(source),
+			prefix: []byte(shellout.JSONFlag + " "),
+		}
+	}
 	return cli.ServeBy(source)
 }
 
+// prefixReader adds the prefix to each line of source
+type prefixReader struct {
+	r      *bufio.Reader
+	prefix []byte
+	buf    []byte
+}
+
+func (p *prefixReader) Read(b []byte) (int, error) {
+	if len(p.buf) == 0 {
+		line, err := p.r.ReadBytes('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+		p.buf = append(append(p.buf, p.prefix...), line...)
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
 func (NextShell) FlaglyDesc() string {
 	return "shell mode"
 }
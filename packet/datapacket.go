@@ -1,6 +1,11 @@
 package packet
 
-import "github.com/chzyer/next/ip"
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/chzyer/next/ip"
+)
 
 type DataPacket struct {
 	*Packet
@@ -10,6 +15,56 @@ func NewDataPacket(payload []byte) *DataPacket {
 	return &DataPacket{New(payload, DATA)}
 }
 
+// DupHeaderSize is the size of sequence number in DATA_DUP.
+const DupHeaderSize = 4
+
+// NewDupPacket wraps the DATA p as a DATA_DUP, the copies of a same packet
+// share the seq. The ReqId is kept, so the reply of it is still matched.
+func NewDupPacket(p *Packet, seq uint32) *Packet {
+	payload := make([]byte, DupHeaderSize+len(p.payload))
+	binary.BigEndian.PutUint32(payload, seq)
+	copy(payload[DupHeaderSize:], p.payload)
+	dup := New(payload, DATA_DUP)
+	dup.ReqId = p.ReqId
+	return dup
+}
+
+// ParseDupPacket returns the seq and the DATA wrapped in the DATA_DUP p.
+func ParseDupPacket(p *Packet) (uint32, *Packet, error) {
+	if p.Type != DATA_DUP {
+		return 0, nil, ErrInvalidType.Format(int(p.Type))
+	}
+	if len(p.payload) < DupHeaderSize {
+		return 0, nil, ErrPacketTooShort.Format(len(p.payload))
+	}
+	// the payload is not prefixed by loopback like New
+	data := &Packet{
+		ReqId:   p.ReqId,
+		Type:    DATA,
+		payload: p.payload[DupHeaderSize:],
+		size:    len(p.payload) - DupHeaderSize,
+	}
+	return binary.BigEndian.Uint32(p.payload), data, nil
+}
+
+// IPVersion returns 4 or 6, and 0 if the packet is too short to be parsed.
+func (d *DataPacket) IPVersion() int {
+	if len(d.payload) == 0 {
+		return 0
+	}
+	switch d.payload[0] >> 4 {
+	case 4:
+		if len(d.payload) >= 20 {
+			return 4
+		}
+	case 6:
+		if len(d.payload) >= 40 {
+			return 6
+		}
+	}
+	return 0
+}
+
 func (d *DataPacket) SrcIP() ip.IP {
 	return ip.NewIP(d.payload[12:16])
 }
@@ -17,3 +72,130 @@ func (d *DataPacket) SrcIP() ip.IP {
 func (d *DataPacket) DestIP() ip.IP {
 	return ip.NewIP(d.payload[16:20])
 }
+
+func (d *DataPacket) SrcIP6() ip.IP6 {
+	return ip.NewIP6(d.payload[8:24])
+}
+
+func (d *DataPacket) DestIP6() ip.IP6 {
+	return ip.NewIP6(d.payload[24:40])
+}
+
+// DSCP returns the differentiated services code point of ipv4 or ipv6,
+// 0 if it's not an ip packet.
+func (d *DataPacket) DSCP() uint8 {
+	switch d.IPVersion() {
+	case 4:
+		return d.payload[1] >> 2
+	case 6:
+		return (d.payload[0]&0x0f)<<2 | d.payload[1]>>6
+	}
+	return 0
+}
+
+const (
+	ProtoICMP   = 1
+	ProtoTCP    = 6
+	ProtoUDP    = 17
+	ProtoICMPv6 = 58
+)
+
+// Proto returns the protocol of ipv4 or the next header of ipv6, the
+// extension headers of ipv6 are not followed.
+func (d *DataPacket) Proto() uint8 {
+	switch d.IPVersion() {
+	case 4:
+		return d.payload[9]
+	case 6:
+		return d.payload[6]
+	}
+	return 0
+}
+
+// transport returns the offset of tcp/udp header, -1 if the packet is not a
+// tcp/udp packet or it's a fragment which is not the first one.
+func (d *DataPacket) transport() int {
+	off := -1
+	switch d.IPVersion() {
+	case 4:
+		if d.payload[6]&0x1f != 0 || d.payload[7] != 0 {
+			return -1
+		}
+		off = int(d.payload[0]&0x0f) * 4
+	case 6:
+		off = 40
+	}
+	if off < 0 {
+		return -1
+	}
+	switch d.Proto() {
+	case ProtoTCP, ProtoUDP:
+	default:
+		return -1
+	}
+	if len(d.payload) < off+4 {
+		return -1
+	}
+	return off
+}
+
+// SrcPort returns the source port of tcp/udp packet.
+func (d *DataPacket) SrcPort() (uint16, bool) {
+	off := d.transport()
+	if off < 0 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(d.payload[off:]), true
+}
+
+// DestPort returns the destination port of tcp/udp packet.
+func (d *DataPacket) DestPort() (uint16, bool) {
+	off := d.transport()
+	if off < 0 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(d.payload[off+2:]), true
+}
+
+// DestNetIP returns the destination of both ipv4 and ipv6, nil if it's not
+// an ip packet.
+func (d *DataPacket) DestNetIP() net.IP {
+	switch d.IPVersion() {
+	case 4:
+		return d.DestIP().IP()
+	case 6:
+		return d.DestIP6().IP()
+	}
+	return nil
+}
+
+// FlowHash hashes the 5-tuple of tcp/udp packet, the other ip packets are
+// hashed by the protocol and the addresses. It returns 0 if it's not an ip
+// packet.
+func (d *DataPacket) FlowHash() uint32 {
+	var addrs []byte
+	switch d.IPVersion() {
+	case 4:
+		addrs = d.payload[12:20]
+	case 6:
+		addrs = d.payload[8:40]
+	default:
+		return 0
+	}
+	var ports [4]byte
+	if off := d.transport(); off >= 0 {
+		copy(ports[:], d.payload[off:off+4])
+	}
+	// fnv-1a, it's called for every packet
+	hash := uint32(2166136261)
+	write := func(b []byte) {
+		for _, c := range b {
+			hash ^= uint32(c)
+			hash *= 16777619
+		}
+	}
+	write(addrs)
+	write([]byte{d.Proto()})
+	write(ports[:])
+	return hash
+}
@@ -0,0 +1,131 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/chzyer/next/ip"
+	"github.com/chzyer/test"
+)
+
+func TestDataPacket(t *testing.T) {
+	defer test.New(t)
+
+	v4 := make([]byte, 20)
+	v4[0] = 0x45
+	copy(v4[12:], []byte{10, 8, 0, 2})
+	copy(v4[16:], []byte{10, 8, 0, 3})
+	d := NewDataPacket(v4)
+	test.Equal(d.IPVersion(), 4)
+	test.Equal(d.SrcIP(), ip.ParseIP("10.8.0.2"))
+	test.Equal(d.DestIP(), ip.ParseIP("10.8.0.3"))
+
+	v6 := make([]byte, 40)
+	v6[0] = 0x60
+	src, dst := ip.ParseIP6("fd00::2"), ip.ParseIP6("fd00::3")
+	copy(v6[8:], src[:])
+	copy(v6[24:], dst[:])
+	d = NewDataPacket(v6)
+	test.Equal(d.IPVersion(), 6)
+	test.Equal(d.SrcIP6(), src)
+	test.Equal(d.DestIP6(), dst)
+
+	test.Equal(d.DestNetIP().String(), "fd00::3")
+	_, ok := d.DestPort()
+	test.False(ok)
+
+	// expedited forwarding
+	test.Equal(d.DSCP(), uint8(0))
+	v6[0], v6[1] = 0x6b, 0x80
+	test.Equal(d.DSCP(), uint8(46))
+	v4[1] = 46 << 2
+	test.Equal(NewDataPacket(v4).DSCP(), uint8(46))
+
+	test.Equal(NewDataPacket(v6[:20]).IPVersion(), 0)
+	test.Equal(NewDataPacket(nil).IPVersion(), 0)
+}
+
+func TestDataPacketPort(t *testing.T) {
+	defer test.New(t)
+
+	tcp := make([]byte, 24+4)
+	tcp[0] = 0x46 // with 4 bytes options
+	tcp[9] = ProtoTCP
+	copy(tcp[24:], []byte{0x30, 0x39, 0x00, 0x50})
+	d := NewDataPacket(tcp)
+	test.Equal(d.Proto(), uint8(ProtoTCP))
+	port, ok := d.SrcPort()
+	test.True(ok)
+	test.Equal(port, uint16(12345))
+	port, ok = d.DestPort()
+	test.True(ok)
+	test.Equal(port, uint16(80))
+
+	// fragment
+	tcp[7] = 1
+	_, ok = d.DestPort()
+	test.False(ok)
+	tcp[7] = 0
+
+	// too short
+	_, ok = NewDataPacket(tcp[:26]).DestPort()
+	test.False(ok)
+
+	udp6 := make([]byte, 40+8)
+	udp6[0] = 0x60
+	udp6[6] = ProtoUDP
+	copy(udp6[40:], []byte{0x00, 0x35, 0x00, 0x35})
+	port, ok = NewDataPacket(udp6).DestPort()
+	test.True(ok)
+	test.Equal(port, uint16(53))
+
+	icmp := make([]byte, 28)
+	icmp[0] = 0x45
+	icmp[9] = ProtoICMP
+	_, ok = NewDataPacket(icmp).DestPort()
+	test.False(ok)
+}
+
+func TestDataPacketFlowHash(t *testing.T) {
+	defer test.New(t)
+
+	newTCP := func(srcPort byte) *DataPacket {
+		tcp := make([]byte, 20+4)
+		tcp[0] = 0x45
+		tcp[9] = ProtoTCP
+		copy(tcp[12:20], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+		copy(tcp[20:], []byte{0x30, srcPort, 0x00, 0x50})
+		return NewDataPacket(tcp)
+	}
+	test.Equal(newTCP(1).FlowHash(), newTCP(1).FlowHash())
+	test.True(newTCP(1).FlowHash() != newTCP(2).FlowHash())
+	test.True(newTCP(1).FlowHash() != 0)
+
+	test.Equal(NewDataPacket(nil).FlowHash(), uint32(0))
+}
+
+func TestDupPacket(t *testing.T) {
+	defer test.New(t)
+
+	p := New(test.RandBytes(20), DATA)
+	p.ReqId = 7
+	dup := NewDupPacket(p, 3)
+	test.Equal(dup.Type, DATA_DUP)
+	test.Equal(dup.ReqId, uint32(7))
+	test.Equal(dup.Size(), DupHeaderSize+20)
+
+	buf := make([]byte, dup.TotalSize())
+	dup.Marshal(buf)
+	got, err := Unmarshal(buf)
+	test.Nil(err)
+	seq, data, err := ParseDupPacket(got)
+	test.Nil(err)
+	test.Equal(seq, uint32(3))
+	test.Equal(data.Type, DATA)
+	test.Equal(data.ReqId, uint32(7))
+	test.Equal(data.Payload(), p.Payload())
+
+	_, _, err = ParseDupPacket(p)
+	test.NotNil(err)
+	_, _, err = ParseDupPacket(New([]byte{1}, DATA_DUP))
+	test.NotNil(err)
+}
@@ -13,4 +13,36 @@
 //   type => int8
 //   payload => []byte
 //   token => auth request
+//
+// L2 Version 2 (aead)
+//
+//   --------------------------------------------------------------
+//   iv + userId + checksum(0) +
+//   length + aes_gcm(payload, key, nonce, iv+userId) + tag
+//   --------------------------------------------------------------
+//   iv => seq(uint64) + epoch(uint8) + rand(7)
+//   nonce => iv[4:]
+//   tag => 16byte, the frame is dropped if it's not matched
+//   seq => starts from 1 in each data channel, the receiver drops the
+//          duplicated or too old(ReplayWindowSize) frames
+//
+// Handshake
+//
+// Each data channel begins with a HANDSHAKE from the client which carries
+// an ephemeral x25519 public key, it and its HANDSHAKE_R are sealed by the
+// token. Afterwards both sides switch to the keys derived from the shared
+// secret:
+//
+//   c2s + s2c => hkdf-sha256(x25519, token, "next l2 handshake" + cpub + spub)
+//
+// The HANDSHAKE may be sent again if the channel loses datagrams, server
+// replies it by the same frame until the client uses the new keys.
+//
+// The sender rotates its key after Rekey.Bytes or Rekey.Interval by
+// hkdf(key, "next l2 rekey") and increases the epoch, the receiver follows
+// it when it sees the next epoch, and keeps the previous key for the
+// delayed frames.
+//
+// The header keeps the same size in both versions, which one is used is
+// decided by the auth request.
 package packet
@@ -1,14 +1,38 @@
 package packet
 
 import (
-	"crypto/rand"
+	"encoding/binary"
+	"fmt"
 
 	"github.com/chzyer/logex"
-	"github.com/chzyer/next/crypto"
 )
 
 const PacketL2HeaderSize = 24
 
+// Version of the L2 format, it's negotiated by the auth request and
+// shared by all data channels of a user.
+type Version uint8
+
+const (
+	// aes-256-cfb + crc32(payload), kept for the clients which are not
+	// upgraded yet, it's not able to detect the forged frames.
+	VersionCFB Version = 1
+	// aes-256-gcm, iv and userid are authenticated as additional data,
+	// the checksum is always zero.
+	VersionAEAD Version = 2
+)
+
+func (v Version) String() string {
+	switch v {
+	case VersionCFB:
+		return "aes-cfb"
+	case VersionAEAD:
+		return "aes-gcm"
+	default:
+		return fmt.Sprintf("<unknown version>:%v", int(v))
+	}
+}
+
 // to verify auth
 // iv + userid +          // header (18)
 // crc32(payload)         // checksum (4)
@@ -73,12 +97,16 @@ func WrapL2(s *Session, p []*Packet) *PacketL2 {
 		UserId:  uint16(s.UserId()),
 		Payload: buf,
 	}
-	rand.Read(l2.IV)
-	l2.Checksum = crypto.Crc32(l2.Payload)
-	s.Encode(l2.IV, l2.Payload, l2.Payload)
+	s.Seal(l2)
 	return l2
 }
 
+// Seq returns the sequence number of the frame, it's only meaningful
+// in VersionAEAD.
+func (p *PacketL2) Seq() uint64 {
+	return binary.BigEndian.Uint64(p.IV[:8])
+}
+
 func (p *PacketL2) Verify(s *Session) error {
 
 	if p.verifyd != nil {
@@ -86,7 +114,7 @@ func (p *PacketL2) Verify(s *Session) error {
 	}
 
 	// decode in here
-	err := s.Verify(int(p.UserId), p.Checksum, p.IV, p.Payload)
+	err := s.Verify(p)
 	p.verifyd = &err
 	return logex.Trace(err)
 }
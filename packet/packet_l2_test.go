@@ -0,0 +1,191 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/chzyer/logex"
+	"github.com/chzyer/test"
+)
+
+func TestPacketL2(t *testing.T) {
+	defer test.New(t)
+
+	token := test.RandBytes(32)
+	for _, version := range []Version{VersionCFB, VersionAEAD} {
+		s := NewSessionCli(1, token, version)
+		p := New(test.RandBytes(24), DATA)
+
+		l2 := WrapL2(s, []*Packet{p})
+		l2 = NewPacketL2(l2.IV, l2.UserId, l2.Payload, l2.Checksum)
+		test.Nil(l2.Verify(s))
+		ps, err := l2.Unmarshal()
+		test.Nil(err)
+		test.Equal(len(ps), 1)
+		test.Equal(ps[0].Payload(), p.Payload())
+	}
+}
+
+func TestPacketL2Forged(t *testing.T) {
+	defer test.New(t)
+
+	s := NewSessionCli(1, test.RandBytes(32), VersionAEAD)
+	p := New(test.RandBytes(24), DATA)
+
+	{ // flip bits in ciphertext
+		l2 := WrapL2(s, []*Packet{p})
+		l2.Payload[10] ^= 0xff
+		test.NotNil(l2.Verify(s))
+	}
+
+	{ // tamper with the header
+		l2 := WrapL2(s, []*Packet{p})
+		l2.IV[15] ^= 1
+		test.NotNil(l2.Verify(s))
+	}
+
+	{ // wrong key
+		l2 := WrapL2(s, []*Packet{p})
+		s2 := NewSessionCli(1, test.RandBytes(32), VersionAEAD)
+		test.NotNil(l2.Verify(s2))
+	}
+}
+
+func TestPacketL2Replay(t *testing.T) {
+	defer test.New(t)
+
+	token := test.RandBytes(32)
+	sender := NewSessionCli(1, token, VersionAEAD)
+	receiver := NewSessionCli(1, token, VersionAEAD)
+
+	l2 := WrapL2(sender, []*Packet{New(test.RandBytes(24), DATA)})
+	test.Equal(l2.Seq(), uint64(1))
+	copyL2 := func() *PacketL2 {
+		return NewPacketL2(
+			append([]byte(nil), l2.IV...), l2.UserId,
+			append([]byte(nil), l2.Payload...), l2.Checksum)
+	}
+
+	test.Nil(copyL2().Verify(receiver))
+	err := copyL2().Verify(receiver)
+	test.True(logex.Equal(err, ErrReplayed))
+
+	l2 = WrapL2(sender, []*Packet{New(test.RandBytes(24), DATA)})
+	test.Equal(l2.Seq(), uint64(2))
+	test.Nil(copyL2().Verify(receiver))
+}
+
+func handshake(t *testing.T, token []byte) (cli, svr *Session) {
+	cli = NewSessionCli(1, token, VersionAEAD)
+	svr = NewSessionCli(1, token, VersionAEAD)
+	test.True(cli.NeedHandshake())
+
+	l2 := WrapL2(cli, []*Packet{cli.StartHandshake()})
+	test.Nil(l2.Verify(svr))
+	ps, err := l2.Unmarshal()
+	test.Nil(err)
+	reply, err := svr.AcceptHandshake(ps[0])
+	test.Nil(err)
+
+	test.Nil(reply.Verify(cli))
+	ps, err = reply.Unmarshal()
+	test.Nil(err)
+	test.Nil(cli.FinishHandshake(ps[0]))
+	test.False(cli.NeedHandshake())
+	test.False(svr.NeedHandshake())
+	return cli, svr
+}
+
+func TestPacketL2Handshake(t *testing.T) {
+	defer test.New(t)
+
+	token := test.RandBytes(32)
+	cli, svr := handshake(t, token)
+
+	for _, pair := range [][2]*Session{{cli, svr}, {svr, cli}} {
+		p := New(test.RandBytes(24), DATA)
+		l2 := WrapL2(pair[0], []*Packet{p})
+		test.Nil(l2.Verify(pair[1]))
+		ps, err := l2.Unmarshal()
+		test.Nil(err)
+		test.Equal(ps[0].Payload(), p.Payload())
+	}
+
+	// the token only is not enough after handshake
+	l2 := WrapL2(cli, []*Packet{New(test.RandBytes(24), DATA)})
+	test.NotNil(l2.Verify(NewSessionCli(1, token, VersionAEAD)))
+
+	// a handshake without StartHandshake
+	test.NotNil(cli.FinishHandshake(New(test.RandBytes(32), HANDSHAKE_R)))
+}
+
+func TestPacketL2RepeatHandshake(t *testing.T) {
+	defer test.New(t)
+
+	token := test.RandBytes(32)
+	cli := NewSessionCli(1, token, VersionAEAD)
+	svr := NewSessionCli(1, token, VersionAEAD)
+	hs := cli.StartHandshake()
+	l2 := WrapL2(cli, []*Packet{hs})
+	test.Nil(l2.Verify(svr))
+	ps, err := l2.Unmarshal()
+	test.Nil(err)
+	reply, err := svr.AcceptHandshake(ps[0])
+	test.Nil(err)
+
+	// the reply is lost, the HANDSHAKE is sent again by the old key
+	l2 = WrapL2(cli, []*Packet{hs})
+	test.Nil(l2.Verify(svr))
+	ps, err = l2.Unmarshal()
+	test.Nil(err)
+	again, err := svr.RepeatHandshake(ps[0])
+	test.Nil(err)
+	test.Equal(again, reply)
+	_, err = svr.RepeatHandshake(New(test.RandBytes(32), HANDSHAKE))
+	test.NotNil(err)
+
+	test.Nil(again.Verify(cli))
+	ps, err = again.Unmarshal()
+	test.Nil(err)
+	test.Nil(cli.FinishHandshake(ps[0]))
+
+	// the old key is dropped after the client uses the new one
+	test.Nil(WrapL2(cli, []*Packet{New(test.RandBytes(24), DATA)}).Verify(svr))
+	_, err = svr.RepeatHandshake(hs)
+	test.NotNil(err)
+	l2 = WrapL2(NewSessionCli(1, token, VersionAEAD), []*Packet{hs})
+	test.NotNil(l2.Verify(svr))
+}
+
+func TestPacketL2Rekey(t *testing.T) {
+	defer test.New(t)
+
+	cli, svr := handshake(t, test.RandBytes(32))
+	cli.SetRekey(Rekey{Bytes: 64, Interval: DefaultRekey.Interval})
+	var delayed *PacketL2
+	for i := 0; i < 10; i++ {
+		l2 := WrapL2(cli, []*Packet{New(test.RandBytes(24), DATA)})
+		if i == 2 {
+			delayed = l2
+			continue
+		}
+		test.Nil(l2.Verify(svr))
+	}
+	test.True(cli.send.epoch > 1)
+	test.Equal(svr.recv.epoch, cli.send.epoch)
+
+	// sealed by an epoch which is dropped
+	test.NotNil(delayed.Verify(svr))
+
+	// the forged frames of the next epoch derive the key only once
+	cli.SetRekey(DefaultRekey)
+	epoch := svr.recv.epoch
+	var next *trafficKey
+	for i := 0; i < 2; i++ {
+		l2 := WrapL2(cli, []*Packet{New(test.RandBytes(24), DATA)})
+		l2.IV[8] = epoch + 1
+		test.NotNil(l2.Verify(svr))
+		test.Equal(svr.recv.epoch, epoch)
+		test.True(svr.recvNext != nil && (next == nil || svr.recvNext == next))
+		next = svr.recvNext
+	}
+}
@@ -0,0 +1,50 @@
+package packet
+
+// ReplayWindowSize is how many sequence numbers are remembered, the frames
+// older than that are dropped even they are never seen.
+const ReplayWindowSize = 1024
+
+// ReplayWindow is the sliding anti-replay window like IPsec(rfc 6479),
+// it's not thread-safe, each session owns one and use it in the read loop.
+type ReplayWindow struct {
+	last   uint64
+	bitmap [ReplayWindowSize / 64]uint64
+}
+
+func (w *ReplayWindow) bit(seq uint64) (word int, mask uint64) {
+	idx := seq % ReplayWindowSize
+	return int(idx / 64), 1 << (idx % 64)
+}
+
+// Accept reports whether seq is seen in the first time and marks it.
+// Sequence number starts from 1.
+func (w *ReplayWindow) Accept(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+
+	if seq > w.last {
+		if seq-w.last >= ReplayWindowSize {
+			w.bitmap = [ReplayWindowSize / 64]uint64{}
+		} else {
+			for i := w.last + 1; i < seq; i++ {
+				word, mask := w.bit(i)
+				w.bitmap[word] &= ^mask
+			}
+		}
+		w.last = seq
+		word, mask := w.bit(seq)
+		w.bitmap[word] |= mask
+		return true
+	}
+
+	if w.last-seq >= ReplayWindowSize {
+		return false
+	}
+	word, mask := w.bit(seq)
+	if w.bitmap[word]&mask > 0 {
+		return false
+	}
+	w.bitmap[word] |= mask
+	return true
+}
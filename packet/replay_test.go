@@ -0,0 +1,36 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/chzyer/test"
+)
+
+func TestReplayWindow(t *testing.T) {
+	defer test.New(t)
+
+	var w ReplayWindow
+	test.False(w.Accept(0))
+	test.True(w.Accept(1))
+	test.False(w.Accept(1))
+
+	// reordered
+	test.True(w.Accept(5))
+	test.True(w.Accept(3))
+	test.False(w.Accept(3))
+	test.True(w.Accept(2))
+	test.True(w.Accept(4))
+	test.False(w.Accept(5))
+
+	// slide
+	test.True(w.Accept(5 + ReplayWindowSize - 1))
+	test.False(w.Accept(5 + ReplayWindowSize - 1))
+	test.True(w.Accept(6))
+	test.False(w.Accept(5))
+	test.False(w.Accept(4))
+
+	// jump over the whole window
+	test.True(w.Accept(10 * ReplayWindowSize))
+	test.True(w.Accept(9*ReplayWindowSize + 1))
+	test.False(w.Accept(9 * ReplayWindowSize))
+}
@@ -1,58 +1,316 @@
 package packet
 
 import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+
 	"github.com/chzyer/logex"
 	"github.com/chzyer/next/crypto"
+	"github.com/chzyer/next/util"
 )
 
 var (
 	ErrUserNotMatch = logex.Define("user %v is not matched")
+	ErrReplayed     = logex.Define("frame %v is replayed")
+	ErrHandshake    = logex.Define("handshake failed: %v")
 )
 
+// Rekey is when the traffic key is rotated, after it sealed Bytes or
+// lived for Interval, the old one is dropped.
+type Rekey struct {
+	Bytes    int64
+	Interval time.Duration
+}
+
+var DefaultRekey = Rekey{Bytes: 1 << 30, Interval: 10 * time.Minute}
+
+// ParseRekey parses the size like "1G" and the interval, both of them
+// should be positive.
+func ParseRekey(size string, interval time.Duration) (Rekey, error) {
+	n, err := util.ParseSize(size)
+	if err != nil || n <= 0 {
+		return Rekey{}, fmt.Errorf("invalid rekey size: %v", size)
+	}
+	if interval <= 0 {
+		return Rekey{}, fmt.Errorf("invalid rekey interval: %v", interval)
+	}
+	return Rekey{Bytes: int64(n), Interval: interval}, nil
+}
+
 type AuthDelegate interface {
-	GetUserToken(userId int) ([]byte, error)
+	GetUserToken(userId int) ([]byte, Version, error)
 }
 
 type Session struct {
 	delegate AuthDelegate
 
-	userId int
-	token  []byte
+	userId  int
+	token   []byte
+	version Version
+
+	// only for VersionAEAD
+	seq        uint64
+	replay     ReplayWindow
+	send       *trafficKey
+	recv       *trafficKey
+	recvPrev   *trafficKey
+	recvNext   *trafficKey // derived once for each epoch
+	handshaked bool
+	rekey      Rekey
+
+	// client's ephemeral key pair before HANDSHAKE_R is received
+	ephemeral    []byte
+	ephemeralPub []byte
+
+	// server keeps the accepted HANDSHAKE and its reply until the client
+	// uses the new keys, the reply may be lost in datagram channels.
+	accepted      []byte
+	acceptedReply *PacketL2
+	acceptedRecv  *trafficKey
 }
 
 func NewSessionSvr(delegate AuthDelegate) *Session {
 	return &Session{
 		delegate: delegate,
 		userId:   -1,
+		rekey:    DefaultRekey,
 	}
 }
 
-func NewSessionCli(userId int, token []byte) *Session {
-	return &Session{
+func NewSessionCli(userId int, token []byte, version Version) *Session {
+	s := &Session{
 		userId: userId,
-		token:  token,
+		rekey:  DefaultRekey,
 	}
+	s.setToken(token, version)
+	return s
+}
+
+// SetRekey replaces the thresholds of rotating the sending key.
+func (s *Session) SetRekey(r Rekey) {
+	s.rekey = r
 }
 
+// Clone makes a session for a new data channel, the sequence number,
+// replay window and traffic keys are not shared.
 func (s *Session) Clone() *Session {
-	return &Session{
+	ret := &Session{
 		delegate: s.delegate,
 		userId:   s.userId,
-		token:    s.token,
+		rekey:    s.rekey,
+	}
+	if s.token != nil {
+		ret.setToken(s.token, s.version)
+	}
+	return ret
+}
+
+func (s *Session) setToken(token []byte, version Version) {
+	s.token = token
+	s.version = version
+	if version == VersionAEAD {
+		// only used to protect the handshake
+		s.send = newTrafficKey(token, 0)
+		s.recv = newTrafficKey(token, 0)
+	}
+}
+
+// Token is nil in server before the user is verified.
+func (s *Session) Token() []byte {
+	return s.token
+}
+
+func (s *Session) Version() Version {
+	return s.version
+}
+
+// NeedHandshake reports whether the traffic keys are not negotiated yet,
+// the data channel can't send anything except the HANDSHAKE before that.
+func (s *Session) NeedHandshake() bool {
+	return s.version == VersionAEAD && !s.handshaked
+}
+
+// StartHandshake is called by client, the returned packet should be sent
+// as the first frame of the data channel.
+func (s *Session) StartHandshake() *Packet {
+	s.ephemeral, s.ephemeralPub = crypto.NewX25519()
+	return New(s.ephemeralPub, HANDSHAKE)
+}
+
+// FinishHandshake is called by client when HANDSHAKE_R is received.
+func (s *Session) FinishHandshake(p *Packet) error {
+	if s.ephemeral == nil {
+		return ErrHandshake.Format("not started")
+	}
+	shared, err := crypto.X25519(s.ephemeral, p.Payload())
+	s.ephemeral = nil
+	if err != nil {
+		return ErrHandshake.Format(err)
+	}
+	c2s, s2c := s.deriveKeys(shared, s.ephemeralPub, p.Payload())
+	s.setTrafficKeys(c2s, s2c)
+	return nil
+}
+
+// AcceptHandshake is called by server when HANDSHAKE is received, the
+// reply is sealed by the old key, and then the traffic keys are switched.
+func (s *Session) AcceptHandshake(p *Packet) (*PacketL2, error) {
+	if p.Type != HANDSHAKE {
+		return nil, ErrHandshake.Format("unexpected " + p.Type.String())
+	}
+	private, public := crypto.NewX25519()
+	shared, err := crypto.X25519(private, p.Payload())
+	if err != nil {
+		return nil, ErrHandshake.Format(err)
+	}
+	reply := WrapL2(s, []*Packet{p.Reply(public)})
+	s.accepted, s.acceptedReply, s.acceptedRecv = p.Payload(), reply, s.recv
+	c2s, s2c := s.deriveKeys(shared, p.Payload(), public)
+	s.setTrafficKeys(s2c, c2s)
+	return reply, nil
+}
+
+// RepeatHandshake returns the reply of the accepted HANDSHAKE again if p
+// is its retransmission, it's only valid before the client uses the new
+// keys.
+func (s *Session) RepeatHandshake(p *Packet) (*PacketL2, error) {
+	if p.Type != HANDSHAKE || s.acceptedReply == nil {
+		return nil, ErrHandshake.Format("unexpected " + p.Type.String())
 	}
+	if !bytes.Equal(p.Payload(), s.accepted) {
+		return nil, ErrHandshake.Format("key not match")
+	}
+	return s.acceptedReply, nil
+}
+
+// the token is mixed in, so the handshake can't be done without it.
+func (s *Session) deriveKeys(shared, clientPub, serverPub []byte) (c2s, s2c []byte) {
+	info := []byte("next l2 handshake")
+	info = append(info, clientPub...)
+	info = append(info, serverPub...)
+	keys := crypto.HKDF(shared, s.token, info, 64)
+	return keys[:32], keys[32:]
 }
 
-func (s *Session) Verify(userId int, crc32 uint32, iv, payload []byte) error {
-	if err := s.VerifyUserId(userId); err != nil {
+func (s *Session) setTrafficKeys(send, recv []byte) {
+	s.send = newTrafficKey(send, 0)
+	s.recv = newTrafficKey(recv, 0)
+	s.recvPrev = nil
+	s.recvNext = nil
+	s.handshaked = true
+}
+
+// Seal encrypts the payload of p in place, and fills the iv and checksum.
+func (s *Session) Seal(p *PacketL2) {
+	if s.token == nil {
+		panic("session is not inited, token is nil")
+	}
+	rand.Read(p.IV)
+
+	switch s.version {
+	case VersionAEAD:
+		if s.handshaked && s.send.isExpired(s.rekey) {
+			s.send = s.send.next()
+		}
+		binary.BigEndian.PutUint64(p.IV[:8], atomic.AddUint64(&s.seq, 1))
+		p.IV[8] = s.send.epoch
+		p.Checksum = 0
+		p.Payload = s.send.aead.Seal(p.Payload[:0], nonce(p), p.Payload, additional(p))
+		s.send.size += int64(len(p.Payload))
+	default:
+		p.Checksum = crypto.Crc32(p.Payload)
+		s.Encode(p.IV, p.Payload, p.Payload)
+	}
+}
+
+// Verify checks the user of p and decrypts its payload in place,
+// forged or corrupted frames are rejected by ErrInvalidToken.
+func (s *Session) Verify(p *PacketL2) error {
+	if err := s.VerifyUserId(int(p.UserId)); err != nil {
 		return err
 	}
-	s.Decode(iv, payload, payload)
-	if crypto.Crc32(payload) != crc32 {
-		return ErrInvalidToken.Trace("checksum not match")
+
+	switch s.version {
+	case VersionAEAD:
+		if len(p.IV) != 16 {
+			return ErrInvalidToken.Trace("invalid iv")
+		}
+		key, isNext := s.recvKey(p.IV[8])
+		if key == nil {
+			return ErrInvalidToken.Trace("unknown key epoch")
+		}
+		dst := p.Payload[:0]
+		if s.acceptedRecv != nil {
+			// a failed Open may overwrite dst, keep the frame for retrying
+			dst = nil
+		}
+		payload, err := key.aead.Open(dst, nonce(p), p.Payload, additional(p))
+		if err != nil && s.acceptedRecv != nil {
+			// the HANDSHAKE is retransmitted
+			key, isNext = s.acceptedRecv, false
+			payload, err = key.aead.Open(dst, nonce(p), p.Payload, additional(p))
+		}
+		if err != nil {
+			return ErrInvalidToken.Trace(err)
+		}
+		if s.acceptedRecv != nil && key != s.acceptedRecv {
+			// the client has switched to the new keys
+			s.accepted, s.acceptedReply, s.acceptedRecv = nil, nil, nil
+		}
+		// only the authenticated sequence number can move the window
+		seq := p.Seq()
+		if !s.replay.Accept(seq) {
+			return ErrReplayed.Format(seq)
+		}
+		if isNext {
+			// remote is rekeyed
+			s.recvPrev, s.recv, s.recvNext = s.recv, key, nil
+		}
+		p.Payload = payload
+	default:
+		s.Decode(p.IV, p.Payload, p.Payload)
+		if crypto.Crc32(p.Payload) != p.Checksum {
+			return ErrInvalidToken.Trace("checksum not match")
+		}
 	}
 	return nil
 }
 
+func (s *Session) recvKey(epoch byte) (key *trafficKey, isNext bool) {
+	switch {
+	case epoch == s.recv.epoch:
+		return s.recv, false
+	case epoch == s.recv.epoch+1 && s.handshaked:
+		// the spoofed frames can't make us derive it again
+		if s.recvNext == nil {
+			s.recvNext = s.recv.next()
+		}
+		return s.recvNext, true
+	case s.recvPrev != nil && epoch == s.recvPrev.epoch:
+		// delayed frames which are sealed by the old key
+		return s.recvPrev, false
+	}
+	return nil, false
+}
+
+// low 4 bytes of sequence number + epoch(1) + random(7)
+func nonce(p *PacketL2) []byte {
+	return p.IV[4:]
+}
+
+// iv + userId, authenticated but not encrypted
+func additional(p *PacketL2) []byte {
+	ad := make([]byte, len(p.IV)+2)
+	copy(ad, p.IV)
+	binary.BigEndian.PutUint16(ad[len(p.IV):], p.UserId)
+	return ad
+}
+
 func (s *Session) UserId() int {
 	if s.userId < 0 {
 		panic("session is not inited")
@@ -69,12 +327,12 @@ func (s *Session) VerifyUserId(userId int) error {
 		return nil
 	}
 
-	token, err := s.delegate.GetUserToken(userId)
+	token, version, err := s.delegate.GetUserToken(userId)
 	if err != nil {
 		return err
 	}
 	s.userId = userId
-	s.token = token
+	s.setToken(token, version)
 	return nil
 }
 
@@ -88,3 +346,33 @@ func (s *Session) Encode(iv, dst, src []byte) {
 func (s *Session) Decode(iv []byte, dst, src []byte) {
 	crypto.DecodeAes(dst, src, s.token, iv)
 }
+
+// -----------------------------------------------------------------------------
+
+// key of one direction in the data channel
+type trafficKey struct {
+	key   []byte
+	aead  cipher.AEAD
+	epoch byte
+	size  int64
+	born  time.Time
+}
+
+func newTrafficKey(key []byte, epoch byte) *trafficKey {
+	return &trafficKey{
+		key:   key,
+		aead:  crypto.NewAesGcm(key),
+		epoch: epoch,
+		born:  time.Now(),
+	}
+}
+
+func (k *trafficKey) isExpired(r Rekey) bool {
+	return k.size >= r.Bytes || time.Since(k.born) >= r.Interval
+}
+
+// the next key can be derived by both side, but not the reverse
+func (k *trafficKey) next() *trafficKey {
+	key := crypto.HKDF(k.key, nil, []byte("next l2 rekey"), 32)
+	return newTrafficKey(key, k.epoch+1)
+}
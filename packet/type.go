@@ -16,8 +16,8 @@ const (
 	HEARTBEAT   // 5: payload: nil
 	HEARTBEAT_R // 6: payload: nil
 
-	NEWDC   // 7: payload: nil
-	NEWDC_R // 8: payload: json([port])
+	NEWDC   // 7: payload: nil or json({typed: true})
+	NEWDC_R // 8: payload: json([port]) or json([{type, port}]) if typed
 
 	// send bytes to remote
 	SPEED   // 9: payload: [4096]bytes in random
@@ -27,6 +27,15 @@ const (
 	SPEED_REQ   // 11: payload: byte size(uint64)
 	SPEED_REQ_R // 12:
 
+	// exchange the ephemeral key at the beginning of a data channel
+	HANDSHAKE   // 13: payload: x25519 public key
+	HANDSHAKE_R // 14: payload: x25519 public key
+
+	// a copy of DATA which is sent through several data channels, the
+	// receiver unwraps the first one and drops the others. Both sides must
+	// know it.
+	DATA_DUP // 15: payload: seq(uint32) + ip packet
+
 	InvalidType
 )
 
@@ -56,6 +65,12 @@ func (t Type) String() string {
 		return "NewDC"
 	case NEWDC_R:
 		return "NewDCResp"
+	case HANDSHAKE:
+		return "Handshake"
+	case HANDSHAKE_R:
+		return "HandshakeResp"
+	case DATA_DUP:
+		return "DataDup"
 	default:
 		return fmt.Sprintf("<unknown type>:%v", int(t))
 	}
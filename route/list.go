@@ -1,12 +1,11 @@
 package route
 
 import (
+	"bytes"
 	"container/list"
 	"net"
 	"sort"
 	"time"
-
-	"github.com/chzyer/next/ip"
 )
 
 type EphemeralItem struct {
@@ -96,9 +95,9 @@ func (is *Items) Len() int {
 }
 
 func (is Items) Less(i, j int) bool {
-	ni, _ := ip.ParseCIDR(is[i].CIDR)
-	nj, _ := ip.ParseCIDR(is[j].CIDR)
-	return ni.IP.Int() < nj.IP.Int()
+	ni, _, _ := net.ParseCIDR(is[i].CIDR)
+	nj, _, _ := net.ParseCIDR(is[j].CIDR)
+	return bytes.Compare(ni.To16(), nj.To16()) < 0
 }
 
 func (is Items) Swap(i, j int) {
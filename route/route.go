@@ -226,7 +226,11 @@ func (r *Route) Save(fp string) error {
 
 func FormatCIDR(cidr string) string {
 	if idx := strings.Index(cidr, "/"); idx < 0 {
-		cidr += "/32"
+		if IsIPv6(cidr) {
+			cidr += "/128"
+		} else {
+			cidr += "/32"
+		}
 	}
 
 	_, ipnet, err := net.ParseCIDR(cidr)
@@ -237,6 +241,17 @@ func FormatCIDR(cidr string) string {
 	return cidr
 }
 
+func IsIPv6(cidr string) bool {
+	return strings.Contains(cidr, ":")
+}
+
+// SetAddr6 adds the ipv6 address to the device, the route of its prefix
+// is added by system.
+func SetAddr6(devName string, ipnet *net.IPNet) error {
+	sh := genAddAddr6Cmd(devName, ipnet)
+	return logex.Trace(util.Shell(sh))
+}
+
 func checkValidCIDR(cidr string) error {
 	_, _, err := net.ParseCIDR(cidr)
 	if err != nil {
@@ -1,14 +1,31 @@
 package route
 
-import "fmt"
+import (
+	"fmt"
+	"net"
+)
 
 func genAddRouteCmd(devName, cidr string) string {
+	family := ""
+	if IsIPv6(cidr) {
+		family = "-inet6 "
+	}
 	return fmt.Sprintf(
-		"route add -net %v -interface %v",
-		FormatCIDR(cidr), devName,
+		"route add %v-net %v -interface %v",
+		family, FormatCIDR(cidr), devName,
 	)
 }
 
 func genRemoveRouteCmd(cidr string) string {
-	return fmt.Sprintf("route delete -net %v", FormatCIDR(cidr))
+	family := ""
+	if IsIPv6(cidr) {
+		family = "-inet6 "
+	}
+	return fmt.Sprintf("route delete %v-net %v", family, FormatCIDR(cidr))
+}
+
+func genAddAddr6Cmd(devName string, ipnet *net.IPNet) string {
+	ones, _ := ipnet.Mask.Size()
+	return fmt.Sprintf("ifconfig %v inet6 %v prefixlen %v",
+		devName, ipnet.IP, ones)
 }
@@ -1,6 +1,9 @@
 package route
 
-import "fmt"
+import (
+	"fmt"
+	"net"
+)
 
 func genAddRouteCmd(devName, cidr string) string {
 	return fmt.Sprintf(
@@ -12,3 +15,7 @@ func genAddRouteCmd(devName, cidr string) string {
 func genRemoveRouteCmd(cidr string) string {
 	return fmt.Sprintf("ip route delete %v", FormatCIDR(cidr))
 }
+
+func genAddAddr6Cmd(devName string, ipnet *net.IPNet) string {
+	return fmt.Sprintf("ip -6 addr add %v dev %v", ipnet, devName)
+}
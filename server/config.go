@@ -2,16 +2,21 @@ package server
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/chzyer/flagly"
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
 	"github.com/chzyer/next/dchan"
 	"github.com/chzyer/next/ip"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/next/uc"
 )
 
 func init() {
-	flagly.RegisterAll(ip.IPNet{})
+	flagly.RegisterAll(ip.IPNet{}, ip.IPNet6{})
 }
 
 type Config struct {
@@ -20,19 +25,35 @@ type Config struct {
 	DebugFlow  bool
 	DebugTun   bool
 
-	ChannelType string `name:"chantype" default:"tcp"`
+	ChannelType   string        `name:"chantype" desc:"channel types separated by comma, e.g. tcp,udp, the first one is used by the old clients" default:"tcp"`
+	FEC           string        `name:"fec" desc:"data,parity shards of forward error correction for udp channel, e.g. 10,3"`
+	KCP           string        `name:"kcp" desc:"tuning of udp channel, pushed to clients: normal, fast, or nodelay,interval,resend,nc"`
+	KCPWnd        string        `name:"kcpwnd" desc:"send,receive window of udp channel, e.g. 1024,1024"`
+	KCPMTU        int           `name:"kcpmtu" desc:"mtu of udp channel"`
+	KCPCrypt      bool          `name:"kcpcrypt" desc:"encrypt the kcp session of udp channel, the old clients don't support it"`
+	WSAddr        string        `name:"wsaddr" desc:"listen address of ws channel, e.g. 127.0.0.1:8080 behind a reverse proxy, random ports if empty"`
+	WSPath        string        `name:"wspath" desc:"http path of ws channel" default:"/"`
+	AllowCFB      bool          `name:"allowcfb" desc:"accept the old clients, which send the password to login and only know the aes-cfb data channel"`
+	Hub           bool          `desc:"forward packets between the users in a same group without tun"`
+	RekeySize     string        `name:"rekeysize" desc:"rotate the key of data channel after sending the bytes" default:"1G"`
+	RekeyInterval time.Duration `name:"rekeyinterval" desc:"rotate the key of data channel after the interval" default:"10m"`
+	Sched         string        `name:"sched" desc:"scheduler of data channels: flow, ready, rtt, wrr, redundant" default:"flow"`
 
-	HTTP     string    `desc:"listen http port" default:":11311"`
-	HTTPAes  string    `name:"key" desc:"http aes key; required"`
-	HTTPCert string    `desc:"https cert file path"`
-	HTTPKey  string    `desc:"https key file path"`
-	Sock     string    `desc:"unixsock for interactive with" default:"/tmp/next.sock"`
-	MTU      int       `default:"1500"`
-	Net      *ip.IPNet `default:"10.8.0.1/24"`
-	Pprof    string    `default:":10060"`
+	HTTP     string        `desc:"listen http port" default:":11311"`
+	HTTPAes  string        `name:"key" desc:"http aes key; required"`
+	HTTPCert string        `desc:"https cert file path, used by tls and quic channel too"`
+	HTTPKey  string        `desc:"https key file path, used by tls and quic channel too"`
+	Sock     string        `desc:"unixsock for interactive with" default:"/tmp/next.sock"`
+	MTU      int           `default:"1500"`
+	Net      *ip.IPNet     `default:"10.8.0.1/24"`
+	Net6     *ip.IPNet6    `name:"net6" desc:"enable ipv6 by the prefix, e.g. fd00:8::1/64"`
+	LeaseTTL time.Duration `name:"leasettl" desc:"release the ip of user after offline for a while" default:"24h"`
+	Pprof    string        `default:":10060"`
+	Metrics  string        `desc:"listen address of prometheus metrics, e.g. localhost:9100"`
 	DevId    int
 
 	DBPath string `desc:"filepath to persist user info" default:"nextuser"`
+	DBType string `name:"dbtype" desc:"store of user info: gob, bolt" default:"gob"`
 }
 
 func (c *Config) FlaglyVerify() error {
@@ -45,15 +66,56 @@ func (c *Config) FlaglyVerify() error {
 	if c.DBPath == "" {
 		return errors.New("dbpath is empty")
 	}
-	if err := dchan.CheckType(c.ChannelType); err != nil {
+	if err := uc.CheckStoreType(c.DBType); err != nil {
 		return logex.Trace(err)
 	}
+	if _, err := dchan.ParseTypes(c.ChannelType); err != nil {
+		return logex.Trace(err)
+	}
+	if _, err := c.GetRekey(); err != nil {
+		return logex.Trace(err)
+	}
+	if err := dchan.CheckScheduler(c.Sched); err != nil {
+		return logex.Trace(err)
+	}
+	if _, _, err := dchan.ParseFEC(c.FEC); err != nil {
+		return logex.Trace(err)
+	}
+	if _, err := dchan.ParseKCP(c.KCP, c.KCPWnd, c.KCPMTU); err != nil {
+		return logex.Trace(err)
+	}
+	if !strings.HasPrefix(c.WSPath, "/") {
+		return fmt.Errorf("invalid wspath: %v", c.WSPath)
+	}
+	if c.HasChannelType("tls") && (c.HTTPCert == "" || c.HTTPKey == "") {
+		return errors.New("tls channel requires the httpcert and httpkey")
+	}
 
 	flow.DefaultDebug = c.DebugFlow
 	logex.ShowCode = c.DebugStack
 	return nil
 }
 
+func (c *Config) GetRekey() (packet.Rekey, error) {
+	return packet.ParseRekey(c.RekeySize, c.RekeyInterval)
+}
+
+// ChannelTypes returns the channel types to listen, the first one is pushed
+// in login.
+func (c *Config) ChannelTypes() []string {
+	types, _ := dchan.ParseTypes(c.ChannelType)
+	return types
+}
+
+func (c *Config) HasChannelType(typ string) bool {
+	for _, t := range c.ChannelTypes() {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) FlaglyHandle(f *flow.Flow, h *flagly.Handler) error {
 	srv := New(c, f)
 	srv.Run()
@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/chzyer/flow"
+	"github.com/chzyer/next/dchan"
 	"github.com/chzyer/next/ip"
 	"github.com/chzyer/next/mchan"
 	"github.com/chzyer/next/uc"
@@ -15,15 +16,23 @@ type HttpApi struct {
 	users    *uc.Users
 	server   *mchan.Server
 	delegate HttpDelegate
+
+	challenges authChallenges
+	logins     loginThrottle
 }
 
 type HttpDelegate interface {
 	GetChannelType() string
-	AllocIP() *ip.IP
+	GetChannelOption() *dchan.ChannelOption
+	IsAllowCFB() bool
+	IsQuotaExceeded(u *uc.User) bool
+	AllocIP(u *uc.User) (*ip.IP, *ip.IP6)
 	GetGateway() *ip.IPNet
+	GetGateway6() *ip.IPNet6
 	GetMTU() int
 	GetDataChannel() int
 	OnNewUser(userId int)
+	SaveUsers() error
 }
 
 func NewHttpApi(f *flow.Flow, listen string, users *uc.Users, ct *clock.Clock, key []byte, cfg *mchan.SvrConf, delegate HttpDelegate) *HttpApi {
@@ -37,6 +46,7 @@ func NewHttpApi(f *flow.Flow, listen string, users *uc.Users, ct *clock.Clock, k
 }
 
 func (h *HttpApi) Run() error {
+	h.server.HandleFunc("/auth/challenge", h.AuthChallenge)
 	h.server.HandleFunc("/auth", h.Auth)
 	h.server.HandleFunc("/time", h.Time)
 	return h.server.Run()
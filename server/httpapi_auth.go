@@ -1,38 +1,208 @@
 package server
 
 import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
 	"github.com/chzyer/logex"
+	"github.com/chzyer/next/crypto"
 	"github.com/chzyer/next/mchan"
+	"github.com/chzyer/next/packet"
 	"github.com/chzyer/next/uc"
 )
 
 var (
 	ErrWrongUserPassword = logex.Define("wrong username or password")
 	ErrNotReady          = logex.Define("not ready")
+	ErrLegacyL2Version   = logex.Define("client is too old, aes-cfb data channel is not allowed")
+	ErrNoIPAvailable     = logex.Define("no ip is available")
+	ErrTooManyAttempts   = logex.Define("too many login attempts, try again later")
+)
+
+// the challenge is used only once, and expired if the proof is not
+// received in time.
+var ChallengeTimeout = 20 * time.Second
+
+// MaxChallenges is how many challenges are waiting for the proof, the
+// oldest one is dropped if it's full.
+var MaxChallenges = 4096
+
+type authChallenge struct {
+	id      string
+	userId  int // -1 if user is not found
+	srp     *crypto.SRPServer
+	expired time.Time
+}
+
+type authChallenges struct {
+	m     map[string]*list.Element // *authChallenge
+	order list.List                // the oldest is in the front
+	lock  sync.Mutex
+}
+
+func (a *authChallenges) Add(c *authChallenge, now time.Time) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.m == nil {
+		a.m = make(map[string]*list.Element)
+	}
+	for elem := a.order.Front(); elem != nil; elem = a.order.Front() {
+		old := elem.Value.(*authChallenge)
+		if !now.After(old.expired) && a.order.Len() < MaxChallenges {
+			break
+		}
+		a.removeLocked(elem)
+	}
+	c.id = uc.GenToken()
+	a.m[c.id] = a.order.PushBack(c)
+	return c.id
+}
+
+func (a *authChallenges) removeLocked(elem *list.Element) {
+	delete(a.m, elem.Value.(*authChallenge).id)
+	a.order.Remove(elem)
+}
+
+func (a *authChallenges) Pop(id string, now time.Time) *authChallenge {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	elem := a.m[id]
+	if elem == nil {
+		return nil
+	}
+	a.removeLocked(elem)
+	c := elem.Value.(*authChallenge)
+	if now.After(c.expired) {
+		return nil
+	}
+	return c
+}
+
+// the password of legacy login is hashed by argon2 in server, each user
+// can try LoginAttempts times in LoginInterval.
+var (
+	LoginAttempts = 5
+	LoginInterval = time.Minute
 )
 
+type loginAttempts struct {
+	count int
+	reset time.Time
+}
+
+type loginThrottle struct {
+	m    map[int]*loginAttempts
+	lock sync.Mutex
+}
+
+// Allow reports whether the user can try the password again.
+func (l *loginThrottle) Allow(userId int, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.m == nil {
+		l.m = make(map[int]*loginAttempts)
+	}
+	a := l.m[userId]
+	if a == nil || now.After(a.reset) {
+		a = &loginAttempts{reset: now.Add(LoginInterval)}
+		l.m[userId] = a
+	}
+	if a.count >= LoginAttempts {
+		return false
+	}
+	a.count++
+	return true
+}
+
+func (h *HttpApi) AuthChallenge(req *mchan.Req) interface{} {
+	var chReq *uc.AuthChallengeRequest
+	if err := req.Unmarshal(&chReq); err != nil {
+		return err
+	}
+
+	userId := -1
+	kdf := uc.KDFCurrent
+	salt, verifier := h.fakeVerifier(chReq.UserName)
+	if u := h.users.Find(chReq.UserName); u != nil && u.Verifier != nil {
+		userId = int(u.Id)
+		kdf, salt, verifier = u.KDF, u.Salt, u.Verifier
+	}
+
+	srp, err := crypto.NewSRPServer(verifier, chReq.Public)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	id := h.challenges.Add(&authChallenge{
+		userId:  userId,
+		srp:     srp,
+		expired: h.clock.Now().Add(ChallengeTimeout),
+	}, h.clock.Now())
+
+	return &uc.AuthChallenge{
+		Id:     id,
+		Salt:   salt,
+		KDF:    kdf,
+		Public: srp.B,
+	}
+}
+
+// unknown users get a stable salt, so they can't be told apart from others.
+func (h *HttpApi) fakeVerifier(name string) (salt, verifier []byte) {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(name))
+	salt = mac.Sum(nil)[:16]
+
+	pswd := make([]byte, 16)
+	rand.Read(pswd)
+	return salt, crypto.SRPVerifier(crypto.SRPPrivate(salt, string(pswd)))
+}
+
 func (h *HttpApi) Auth(req *mchan.Req) interface{} {
 	var authReq *uc.AuthRequest
 	if err := req.Unmarshal(&authReq); err != nil {
 		return err
 	}
 
-	authInfo, err := authReq.Decode(h.key, h.clock.Unix())
+	var (
+		u     *uc.User
+		proof []byte
+		err   error
+	)
+	if authReq.IsLegacy() {
+		u, err = h.authLegacy(authReq)
+	} else {
+		u, proof, err = h.authSRP(authReq)
+	}
 	if err != nil {
 		return err
 	}
-
-	u := h.users.LoginByName(authInfo.UserName, string(authInfo.Passcode))
-	if u == nil {
-		return ErrWrongUserPassword
+	if h.delegate.IsQuotaExceeded(u) {
+		return uc.ErrQuotaExceeded.Trace()
 	}
 
 	if h.delegate.GetDataChannel() == -1 {
 		return ErrNotReady
 	}
 
+	l2Version := packet.VersionAEAD
+	if authReq.L2Version < packet.VersionAEAD {
+		if !h.delegate.IsAllowCFB() {
+			return ErrLegacyL2Version
+		}
+		l2Version = packet.VersionCFB
+	}
+	u.L2Version = l2Version
+
 	if u.Net == nil {
-		u.Net = h.delegate.AllocIP()
+		u.Net, u.Net6 = h.delegate.AllocIP(u)
+		if u.Net == nil {
+			return ErrNoIPAvailable
+		}
 	}
 
 	logex.Info("login success, fetching datachannel")
@@ -44,11 +214,98 @@ func (h *HttpApi) Auth(req *mchan.Req) interface{} {
 		Token:       u.Token,
 		ChannelType: h.delegate.GetChannelType(),
 		DataChannel: h.delegate.GetDataChannel(),
+		L2Version:   l2Version,
+		Proof:       proof,
+	}
+	if opt := h.delegate.GetChannelOption(); opt != nil {
+		auth.ChannelOpt, err = json.Marshal(opt)
+		if err != nil {
+			return logex.Trace(err)
+		}
+	}
+	if gateway6 := h.delegate.GetGateway6(); gateway6 != nil && u.Net6 != nil {
+		auth.Gateway6 = gateway6.String()
+		auth.INet6 = u.Net6.String()
 	}
 	h.delegate.OnNewUser(int(u.Id))
 	return auth
 }
 
+func (h *HttpApi) authSRP(authReq *uc.AuthRequest) (*uc.User, []byte, error) {
+	challenge := h.challenges.Pop(authReq.Id, h.clock.Now())
+	if challenge == nil {
+		return nil, nil, uc.ErrInvalidAuthToken.Trace()
+	}
+	proof, err := challenge.srp.Verify(authReq.Proof)
+	if err != nil || challenge.userId < 0 {
+		return nil, nil, ErrWrongUserPassword
+	}
+	u := h.users.FindId(challenge.userId)
+	if u == nil {
+		return nil, nil, ErrWrongUserPassword
+	}
+	if u.Disabled {
+		return nil, nil, uc.ErrUserDisabled.Trace()
+	}
+	if u.NeedUpgrade() && authReq.Upgrade != nil {
+		h.upgradeVerifier(u, challenge.srp.K, authReq.Upgrade)
+	}
+	return u, proof, nil
+}
+
+// authLegacy accepts the password sent by the old clients, they only know
+// the aes-cfb data channel.
+func (h *HttpApi) authLegacy(authReq *uc.AuthRequest) (*uc.User, error) {
+	if !h.delegate.IsAllowCFB() {
+		return nil, ErrLegacyL2Version
+	}
+	pswd, err := authReq.DecodeLegacy(h.key, h.clock.Unix())
+	if err != nil {
+		return nil, err
+	}
+	found := h.users.Find(authReq.UserName)
+	if found == nil {
+		return nil, ErrWrongUserPassword
+	}
+	if found.Disabled {
+		return nil, uc.ErrUserDisabled.Trace()
+	}
+	if !h.logins.Allow(int(found.Id), h.clock.Now()) {
+		return nil, ErrTooManyAttempts.Trace()
+	}
+	needUpgrade := found.NeedUpgrade()
+	u := h.users.Login(int(found.Id), pswd)
+	if u == nil {
+		return nil, ErrWrongUserPassword
+	}
+	if needUpgrade {
+		// rehashed by Login
+		if err := h.delegate.SaveUsers(); err != nil {
+			logex.Error("save user info failed:", err)
+		}
+	}
+	authReq.L2Version = packet.VersionCFB
+	return u, nil
+}
+
+// the failure of upgrading doesn't affect the login
+func (h *HttpApi) upgradeVerifier(u *uc.User, key, sealed []byte) {
+	v, err := uc.OpenVerifierUpgrade(key, sealed)
+	if err != nil {
+		logex.Error("upgrade verifier of", u.Name, "failed:", err)
+		return
+	}
+	if err := h.users.Upgrade(int(u.Id), v); err != nil {
+		logex.Error("upgrade verifier of", u.Name, "failed:", err)
+		return
+	}
+	if err := h.delegate.SaveUsers(); err != nil {
+		logex.Error("save user info failed:", err)
+		return
+	}
+	logex.Infof("verifier of %v is upgraded to %v", u.Name, v.KDF)
+}
+
 func (h *HttpApi) Time(req *mchan.Req) interface{} {
 	return h.clock.Unix()
 }
@@ -1,29 +1,54 @@
 package server
 
 import (
-	"crypto/rand"
 	"testing"
 	"time"
 
-	"github.com/chzyer/next/uc"
 	"github.com/chzyer/test"
 )
 
-func TestAuthRequest(t *testing.T) {
+func TestAuthChallenges(t *testing.T) {
 	defer test.New(t)
 
-	authData := &uc.AuthData{
-		UserName: "hello",
-		Passcode: []byte("password"),
+	var cs authChallenges
+	now := time.Now()
+	id := cs.Add(&authChallenge{userId: 1, expired: now.Add(ChallengeTimeout)}, now)
+
+	// only used once
+	c := cs.Pop(id, now)
+	test.NotNil(c)
+	test.Equal(c.userId, 1)
+	test.Nil(cs.Pop(id, now))
+
+	id = cs.Add(&authChallenge{userId: 1, expired: now.Add(ChallengeTimeout)}, now)
+	test.Nil(cs.Pop(id, now.Add(ChallengeTimeout+time.Second)))
+
+	// expired ones are cleaned
+	cs.Add(&authChallenge{expired: now.Add(ChallengeTimeout)}, now)
+	cs.Add(&authChallenge{expired: now.Add(ChallengeTimeout)}, now.Add(2*ChallengeTimeout))
+	test.Equal(len(cs.m), 1)
+
+	// the oldest ones are dropped if it's full
+	old := MaxChallenges
+	MaxChallenges = 2
+	defer func() { MaxChallenges = old }()
+	first := cs.Add(&authChallenge{expired: now.Add(3 * ChallengeTimeout)}, now.Add(2*ChallengeTimeout))
+	cs.Add(&authChallenge{expired: now.Add(3 * ChallengeTimeout)}, now.Add(2*ChallengeTimeout))
+	id = cs.Add(&authChallenge{expired: now.Add(3 * ChallengeTimeout)}, now.Add(2*ChallengeTimeout))
+	test.Equal(len(cs.m), 2)
+	test.Nil(cs.Pop(first, now.Add(2*ChallengeTimeout)))
+	test.NotNil(cs.Pop(id, now.Add(2*ChallengeTimeout)))
+}
+
+func TestLoginThrottle(t *testing.T) {
+	defer test.New(t)
+
+	var l loginThrottle
+	now := time.Now()
+	for i := 0; i < LoginAttempts; i++ {
+		test.True(l.Allow(1, now))
 	}
-	timestamp := time.Now().Unix()
-	key := make([]byte, 32)
-	rand.Read(key)
-	authReq := uc.NewAuthRequest(authData.UserName, timestamp, authData.Passcode, key)
-	authData2, err := authReq.Decode(key, timestamp+21)
-	test.Nil(authData2)
-	test.Equal(err, uc.ErrInvalidAuthToken)
-	authData2, err = authReq.Decode(key, timestamp+19)
-	test.Nil(err)
-	test.Equal(authData2, authData)
+	test.False(l.Allow(1, now))
+	test.True(l.Allow(2, now))
+	test.True(l.Allow(1, now.Add(LoginInterval+time.Second)))
 }
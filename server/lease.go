@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/ip"
+	"github.com/chzyer/next/uc"
+)
+
+var LeaseCheckInterval = time.Minute
+
+// restoreLeases reserves the static ips and the unexpired leases in dhcp,
+// so users can get the same ip after server restarted.
+func (s *Server) restoreLeases() {
+	now := time.Now()
+	for _, u := range s.uc.Show() {
+		if u.StaticIP != nil && !s.dhcp.Reserve(*u.StaticIP) {
+			logex.Errorf("reserve static ip %v for %v failed", u.StaticIP, u.Name)
+		}
+		if u.Lease == nil {
+			continue
+		}
+		if u.Lease.IsExpired(now, s.cfg.LeaseTTL) {
+			logex.Infof("drop the expired lease of %v", u.Name)
+			s.uc.SetLease(int(u.Id), nil)
+			continue
+		}
+
+		lease := *u.Lease
+		if lease.HasIP() && !s.dhcp.Reserve(lease.IP) {
+			logex.Infof("drop the lease %v of %v", lease.IP, u.Name)
+			lease.IP = ip.IP{}
+		}
+		if lease.IP6 != nil && (s.dhcp6 == nil || !s.dhcp6.Reserve(*lease.IP6)) {
+			logex.Infof("drop the lease %v of %v", lease.IP6, u.Name)
+			lease.IP6 = nil
+		}
+		s.uc.SetLease(int(u.Id), &lease)
+	}
+}
+
+// AllocIP returns the ips of user, the ipv6 one is nil if it's not enabled.
+func (s *Server) AllocIP(u *uc.User) (*ip.IP, *ip.IP6) {
+	lease := &uc.Lease{}
+	if u.Lease != nil {
+		*lease = *u.Lease
+	}
+	lease.Seen = time.Now()
+
+	var addr *ip.IP
+	switch {
+	case u.StaticIP != nil:
+		addr = u.StaticIP
+	case lease.HasIP():
+		ret := lease.IP
+		addr = &ret
+	default:
+		addr = s.dhcp.Alloc()
+		if addr == nil {
+			return nil, nil
+		}
+		lease.IP = *addr
+	}
+
+	if s.dhcp6 != nil && lease.IP6 == nil {
+		// it's fine to be nil, the user is ipv4 only
+		lease.IP6 = s.dhcp6.Alloc()
+	}
+
+	s.uc.SetLease(int(u.Id), lease)
+	if err := s.SaveUsers(); err != nil {
+		logex.Error("save user info failed:", err)
+	}
+	return addr, lease.IP6
+}
+
+func (s *Server) releaseLease(l *uc.Lease) {
+	if l.HasIP() {
+		s.dhcp.Release(l.IP)
+	}
+	if l.IP6 != nil && s.dhcp6 != nil {
+		s.dhcp6.Release(*l.IP6)
+	}
+}
+
+// leaseLoop keeps the leases of online users, and releases the ones whose
+// user has been offline longer than LeaseTTL.
+func (s *Server) leaseLoop() {
+	s.flow.Add(1)
+	defer s.flow.DoneAndClose()
+
+	ticker := time.NewTicker(LeaseCheckInterval)
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			s.checkLeases(time.Now())
+			// the quota usage and traffic are saved too
+			s.foldUsages(s.cl.Now())
+			if !s.uc.IsDirty() {
+				continue
+			}
+			if err := s.SaveUsers(); err != nil {
+				logex.Error("save user info failed:", err)
+			}
+		case <-s.flow.IsClose():
+			break loop
+		}
+	}
+}
+
+func (s *Server) checkLeases(now time.Time) (changed bool) {
+	for _, u := range s.uc.Show() {
+		if u.Lease == nil {
+			continue
+		}
+		if s.dchanServer.IsOnline(int(u.Id)) {
+			// it's not written every time, so the lease may expire a
+			// quarter of LeaseTTL earlier after the user is offline.
+			if now.Sub(u.Lease.Seen) < s.cfg.LeaseTTL/4 {
+				continue
+			}
+			lease := *u.Lease
+			lease.Seen = now
+			s.uc.SetLease(int(u.Id), &lease)
+			changed = true
+			continue
+		}
+		if !u.Lease.IsExpired(now, s.cfg.LeaseTTL) {
+			continue
+		}
+
+		logex.Infof("lease of %v is expired", u.Name)
+		s.kickUser(&u)
+		s.releaseLease(u.Lease)
+		s.uc.SetLease(int(u.Id), nil)
+		s.uc.SetNet(int(u.Id), nil)
+		s.uc.SetNet6(int(u.Id), nil)
+		changed = true
+	}
+	return changed
+}
+
+// SetUserIP reserves the addr for user, or removes the reservation if addr
+// is nil. The user is kicked if its ip is changed.
+func (s *Server) SetUserIP(name string, addr *ip.IP) error {
+	u := s.uc.Find(name)
+	if u == nil {
+		return uc.ErrUserNotFound.Trace()
+	}
+
+	var owned []ip.IP
+	if u.StaticIP != nil {
+		owned = append(owned, *u.StaticIP)
+	}
+	if u.Lease != nil && u.Lease.HasIP() {
+		owned = append(owned, u.Lease.IP)
+	}
+
+	if addr != nil {
+		if !s.dhcp.IPNet.ToNet().Contains(addr.IP()) {
+			return fmt.Errorf("%v is not in %v", addr, s.dhcp.IPNet)
+		}
+		if !ipIn(*addr, owned) && !s.dhcp.Reserve(*addr) {
+			return fmt.Errorf("%v is in use", addr)
+		}
+	}
+	for _, old := range owned {
+		if addr == nil || old != *addr {
+			s.dhcp.Release(old)
+		}
+	}
+
+	if err := s.uc.SetStaticIP(name, addr); err != nil {
+		return err
+	}
+	if u.Lease != nil {
+		// the ipv6 one is kept
+		lease := *u.Lease
+		lease.IP = ip.IP{}
+		s.uc.SetLease(int(u.Id), &lease)
+	}
+	if u.Net != nil && (addr == nil || *u.Net != *addr) {
+		s.kickUser(u)
+		s.uc.SetNet(int(u.Id), nil)
+	}
+	return nil
+}
+
+func ipIn(addr ip.IP, ips []ip.IP) bool {
+	for _, i := range ips {
+		if i == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/controller"
+	"github.com/chzyer/next/dchan"
+	"github.com/chzyer/next/ip"
+	"github.com/chzyer/next/uc"
+	"github.com/chzyer/test"
+)
+
+// newLeaseServer forks the dchan server from f, it should be closed before
+// f, or the debug log of flow is written by both of them.
+func newLeaseServer(f *flow.Flow, users *uc.Users, dbPath string) *Server {
+	ipnet, _ := ip.ParseCIDR("10.8.0.1/24")
+	users.Open(uc.NewGobStore(dbPath))
+	s := &Server{
+		cfg:  &Config{LeaseTTL: time.Hour, DBPath: dbPath},
+		flow: f,
+		uc:   users,
+		dhcp: ip.NewDHCP(ipnet),
+	}
+	s.dchanServer = dchan.NewServer(f, s)
+	s.controllerGroup = controller.NewGroup(f, s, users, nil)
+	return s
+}
+
+func TestLease(t *testing.T) {
+	defer test.New(t)
+
+	dbPath := "/tmp/users_lease.tmp"
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+
+	f := flow.New()
+	defer f.Close()
+
+	s := newLeaseServer(f, uc.NewUsers(), dbPath)
+	defer s.dchanServer.Close()
+	alice, err := s.uc.Register("alice", "bye")
+	test.Nil(err)
+	bob, err := s.uc.Register("bob", "bye")
+	test.Nil(err)
+
+	addr := ip.ParseIP("10.8.0.50")
+	test.Nil(s.SetUserIP("alice", &addr))
+	test.NotNil(s.SetUserIP("bob", &addr))
+	outside := ip.ParseIP("10.9.0.2")
+	test.NotNil(s.SetUserIP("bob", &outside))
+
+	aliceIP, aliceIP6 := s.AllocIP(s.uc.FindId(int(alice.Id)))
+	test.Equal(*aliceIP, addr)
+	test.Nil(aliceIP6)
+	bobIP, _ := s.AllocIP(s.uc.FindId(int(bob.Id)))
+	test.Equal(*bobIP, ip.ParseIP("10.8.0.2"))
+	test.Nil(s.SaveUsers())
+	test.False(s.uc.IsDirty())
+
+	// restarted
+	users := uc.NewUsers()
+	test.Nil(users.Load(dbPath))
+	s = newLeaseServer(f, users, dbPath)
+	defer s.dchanServer.Close()
+	s.restoreLeases()
+	test.True(s.dhcp.IsExistIP(addr))
+	bobIP2, _ := s.AllocIP(s.uc.FindId(int(bob.Id)))
+	test.Equal(bobIP2, bobIP)
+	test.Equal(*s.dhcp.Alloc(), ip.ParseIP("10.8.0.3"))
+
+	// offline past the ttl
+	test.Nil(s.SaveUsers())
+	test.False(s.checkLeases(time.Now()))
+	test.False(s.uc.IsDirty())
+	test.True(s.checkLeases(time.Now().Add(2 * time.Hour)))
+	test.Nil(s.uc.FindId(int(bob.Id)).Lease)
+	test.False(s.dhcp.IsExistIP(*bobIP))
+	test.NotNil(s.uc.FindId(int(alice.Id)).StaticIP)
+
+	// remove the reservation
+	test.Nil(s.SetUserIP("alice", nil))
+	test.False(s.dhcp.IsExistIP(addr))
+}
+
+func TestLease6(t *testing.T) {
+	defer test.New(t)
+
+	dbPath := "/tmp/users_lease6.tmp"
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+
+	f := flow.New()
+	defer f.Close()
+
+	ipnet6, err := ip.ParseCIDR6("fd00:8::1/64")
+	test.Nil(err)
+	s := newLeaseServer(f, uc.NewUsers(), dbPath)
+	defer s.dchanServer.Close()
+	s.dhcp6 = ip.NewDHCP6(ipnet6)
+	alice, err := s.uc.Register("alice", "bye")
+	test.Nil(err)
+
+	addr, addr6 := s.AllocIP(s.uc.FindId(int(alice.Id)))
+	test.NotNil(addr)
+	test.Equal(*addr6, ip.ParseIP6("fd00:8::2"))
+
+	// the ipv6 is kept after a static ip is set
+	static := ip.ParseIP("10.8.0.50")
+	test.Nil(s.SetUserIP("alice", &static))
+	addr, addr6 = s.AllocIP(s.uc.FindId(int(alice.Id)))
+	test.Equal(*addr, static)
+	test.Equal(*addr6, ip.ParseIP6("fd00:8::2"))
+
+	// restarted
+	users := uc.NewUsers()
+	test.Nil(users.Load(dbPath))
+	s = newLeaseServer(f, users, dbPath)
+	defer s.dchanServer.Close()
+	s.dhcp6 = ip.NewDHCP6(ipnet6)
+	s.restoreLeases()
+	test.True(s.dhcp6.IsExistIP(ip.ParseIP6("fd00:8::2")))
+
+	test.True(s.checkLeases(time.Now().Add(2 * time.Hour)))
+	test.False(s.dhcp6.IsExistIP(ip.ParseIP6("fd00:8::2")))
+}
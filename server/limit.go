@@ -0,0 +1,167 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/dchan"
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/next/uc"
+	"github.com/chzyer/next/util"
+)
+
+// userUsage counts the traffic of user without locking Users, it's shared
+// by the limiters of user, and folded into UserInfo by foldUsages.
+type userUsage struct {
+	used    int64 // the quota usage after last fold
+	pending int64 // consumed from quota since last fold
+
+	// since last fold, indexed by upload or not
+	bytes   [2]int64
+	packets [2]int64
+}
+
+func (u *userUsage) count(upload bool, bytes, packets int) {
+	idx := 0
+	if upload {
+		idx = 1
+	}
+	atomic.AddInt64(&u.bytes[idx], int64(bytes))
+	atomic.AddInt64(&u.packets[idx], int64(packets))
+}
+
+func (s *Server) getUsage(u *uc.User) *userUsage {
+	usage := &userUsage{used: u.QuotaUsage(s.cl.Now())}
+	actual, _ := s.usages.LoadOrStore(int(u.Id), usage)
+	return actual.(*userUsage)
+}
+
+// foldUsages adds the counted traffic to users, it's called before they
+// are saved.
+func (s *Server) foldUsages(now time.Time) {
+	s.usages.Range(func(key, value interface{}) bool {
+		if !s.foldUsage(key.(int), value.(*userUsage), now) {
+			s.usages.Delete(key)
+		}
+		return true
+	})
+}
+
+// foldUsage returns false if the user is not found.
+func (s *Server) foldUsage(userId int, usage *userUsage, now time.Time) bool {
+	u := s.uc.FindId(userId)
+	if u == nil {
+		return false
+	}
+	if n := atomic.SwapInt64(&usage.pending, 0); n > 0 {
+		s.uc.Consume(userId, int(n), now)
+	}
+	for idx := range usage.bytes {
+		bytes := atomic.SwapInt64(&usage.bytes[idx], 0)
+		packets := atomic.SwapInt64(&usage.packets[idx], 0)
+		if bytes > 0 || packets > 0 {
+			s.uc.CountTraffic(userId, idx == 1, int(bytes), int(packets), now)
+		}
+	}
+	atomic.StoreInt64(&usage.used, u.QuotaUsage(now))
+	return true
+}
+
+// IsQuotaExceeded checks the quota of user after folding the usage which
+// is counted since last save.
+func (s *Server) IsQuotaExceeded(u *uc.User) bool {
+	now := s.cl.Now()
+	if usage, ok := s.usages.Load(int(u.Id)); ok {
+		s.foldUsage(int(u.Id), usage.(*userUsage), now)
+	}
+	return u.IsQuotaExceeded(now)
+}
+
+// userQuota consumes the monthly quota of user, the user is kicked once
+// the quota runs out.
+type userQuota struct {
+	svr    *Server
+	userId int
+	quota  int64
+	usage  *userUsage
+	once   sync.Once
+}
+
+func (q *userQuota) Consume(n int) bool {
+	used := atomic.LoadInt64(&q.usage.used) + atomic.LoadInt64(&q.usage.pending)
+	if used < q.quota {
+		atomic.AddInt64(&q.usage.pending, int64(n))
+		return true
+	}
+	q.once.Do(func() {
+		// it's called in the loop of group which is closed by kicking
+		go q.svr.onQuotaExceeded(q.userId)
+	})
+	return false
+}
+
+func (s *Server) onQuotaExceeded(userId int) {
+	u := s.uc.FindId(userId)
+	if u == nil {
+		return
+	}
+	logex.Infof("quota of user is exceeded: Id: %v, Name: %v", u.Id, u.Name)
+	if err := s.KickUser(u.Name); err != nil {
+		logex.Error("kick user failed:", err)
+	}
+}
+
+type userMeter struct {
+	usage *userUsage
+}
+
+func (m *userMeter) Count(upload bool, bytes, packets int) {
+	m.usage.count(upload, bytes, packets)
+}
+
+func (s *Server) GetUserLimiter(id int) *dchan.Limiter {
+	u := s.uc.FindId(id)
+	if u == nil {
+		return nil
+	}
+	usage := s.getUsage(u)
+	l := &dchan.Limiter{
+		Meter: &userMeter{usage: usage},
+	}
+	if u.UploadLimit > 0 {
+		l.Upload = util.NewTokenBucket(u.UploadLimit)
+	}
+	if u.DownloadLimit > 0 {
+		l.Download = util.NewTokenBucket(u.DownloadLimit)
+	}
+	if u.Quota > 0 {
+		l.Quota = &userQuota{
+			svr:    s,
+			userId: id,
+			quota:  u.Quota,
+			usage:  usage,
+		}
+	}
+	return l
+}
+
+func (s *Server) GetUserUpload(id int) (packet.SendChan, error) {
+	group, err := s.dchanServer.Group(id)
+	if err != nil {
+		return nil, err
+	}
+	return group.Upload(), nil
+}
+
+// SetUserLimit updates the limits of user, the online one is applied
+// immediately.
+func (s *Server) SetUserLimit(name string, upload, download, quota int64) error {
+	if err := s.uc.SetLimit(name, upload, download, quota); err != nil {
+		return err
+	}
+	u := s.uc.Find(name)
+	s.dchanServer.SetLimiter(int(u.Id), s.GetUserLimiter(int(u.Id)))
+	return nil
+}
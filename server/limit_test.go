@@ -0,0 +1,56 @@
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/uc"
+	"github.com/chzyer/test"
+)
+
+func TestUserUsage(t *testing.T) {
+	defer test.New(t)
+
+	dbPath := "/tmp/users_quota.tmp"
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+
+	f := flow.New()
+	defer f.Close()
+
+	s := newLeaseServer(f, uc.NewUsers(), dbPath)
+	defer s.dchanServer.Close()
+	alice, err := s.uc.Register("alice", "bye")
+	test.Nil(err)
+	test.Nil(s.uc.SetLimit("alice", 0, 0, 100))
+
+	l := s.GetUserLimiter(int(alice.Id))
+	test.True(l.Quota.Consume(60))
+	test.True(l.Quota.Consume(60))
+	// not folded yet
+	test.Equal(alice.QuotaUsage(s.cl.Now()), int64(0))
+	test.False(l.Quota.Consume(1))
+
+	// the login checks the usage which is not folded yet
+	test.True(s.IsQuotaExceeded(alice))
+	test.Equal(alice.QuotaUsage(s.cl.Now()), int64(120))
+	test.Nil(s.SaveUsers())
+	test.Equal(alice.QuotaUsage(s.cl.Now()), int64(120))
+
+	// the usage is shared by the new limiter
+	l = s.GetUserLimiter(int(alice.Id))
+	test.False(l.Quota.Consume(1))
+
+	l.Meter.Count(true, 100, 2)
+	l.Meter.Count(false, 1000, 3)
+	traffic, err := s.uc.GetTraffic("alice")
+	test.Nil(err)
+	test.Equal(traffic.Total, uc.TrafficCounter{})
+	test.Nil(s.SaveUsers())
+	traffic, err = s.uc.GetTraffic("alice")
+	test.Nil(err)
+	test.Equal(traffic.Total, uc.TrafficCounter{
+		UpBytes: 100, UpPackets: 2, DownBytes: 1000, DownPackets: 3,
+	})
+}
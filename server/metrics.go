@@ -0,0 +1,56 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/metrics"
+)
+
+func (s *Server) runMetrics() {
+	logex.Info("listen metrics at", s.cfg.Metrics)
+	if err := metrics.ListenAndServe(s.flow, s.cfg.Metrics, s); err != nil {
+		s.flow.Error(err)
+	}
+}
+
+func (s *Server) userName(userId int) string {
+	if u := s.uc.FindId(userId); u != nil {
+		return u.Name
+	}
+	return strconv.Itoa(userId)
+}
+
+func (s *Server) CollectMetrics(w *metrics.Writer) {
+	if s.controllerGroup != nil {
+		s.controllerGroup.WriteMetrics(w)
+	}
+	s.dchanServer.WriteMetrics(w, s.userName)
+
+	w.Gauge("next_dhcp_pool_size", "count of ips can be allocated",
+		float64(s.dhcp.IpSize), "family", "ipv4")
+	w.Gauge("next_dhcp_pool_used", "count of allocated ips",
+		float64(s.dhcp.Used()), "family", "ipv4")
+	if s.dhcp6 != nil {
+		w.Gauge("next_dhcp_pool_size", "count of ips can be allocated",
+			float64(s.dhcp6.IpSize), "family", "ipv6")
+		w.Gauge("next_dhcp_pool_used", "count of allocated ips",
+			float64(s.dhcp6.Used()), "family", "ipv6")
+	}
+
+	s.foldUsages(s.cl.Now())
+	for _, u := range s.uc.Show() {
+		traffic, err := s.uc.GetTraffic(u.Name)
+		if err != nil {
+			continue
+		}
+		w.Counter("next_user_bytes_total", "bytes transferred by user",
+			float64(traffic.Total.UpBytes), "user", u.Name, "direction", "up")
+		w.Counter("next_user_bytes_total", "bytes transferred by user",
+			float64(traffic.Total.DownBytes), "user", u.Name, "direction", "down")
+		w.Counter("next_user_packets_total", "packets transferred by user",
+			float64(traffic.Total.UpPackets), "user", u.Name, "direction", "up")
+		w.Counter("next_user_packets_total", "packets transferred by user",
+			float64(traffic.Total.DownPackets), "user", u.Name, "direction", "down")
+	}
+}
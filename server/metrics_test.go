@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/next/metrics"
+	"github.com/chzyer/next/uc"
+	"github.com/chzyer/test"
+)
+
+func TestCollectMetrics(t *testing.T) {
+	defer test.New(t)
+
+	dbPath := "/tmp/users_metrics.tmp"
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+
+	f := flow.New()
+	defer f.Close()
+
+	s := newLeaseServer(f, uc.NewUsers(), dbPath)
+	defer s.dchanServer.Close()
+	alice, err := s.uc.Register("alice", "bye")
+	test.Nil(err)
+	s.AllocIP(alice)
+	s.uc.CountTraffic(int(alice.Id), true, 100, 1, s.cl.Now())
+
+	w := metrics.NewWriter()
+	s.CollectMetrics(w)
+	buf := bytes.NewBuffer(nil)
+	_, err = w.WriteTo(buf)
+	test.Nil(err)
+	for _, line := range []string{
+		`next_online_users 0`,
+		`next_dhcp_pool_used{family="ipv4"} 1`,
+		`next_user_bytes_total{user="alice",direction="up"} 100`,
+	} {
+		test.True(strings.Contains(buf.String(), line+"\n"))
+	}
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"crypto/tls"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
@@ -23,11 +25,15 @@ type Server struct {
 	cl    *clock.Clock
 	shell *Shell
 	dhcp  *ip.DHCP
+	dhcp6 *ip.DHCP6 // nil if ipv6 is disabled
 	tun   *Tun
 
 	controllerGroup *controller.Group
 	dchanServer     *dchan.Server
-	dchanGroup      *dchan.ListenerGroup
+	dchanGroups     []*dchan.ListenerGroup // one for each channel type
+	cert            *tls.Certificate       // of tls and quic channel
+	certPin         string
+	usages          sync.Map // userId -> *userUsage
 }
 
 func New(cfg *Config, f *flow.Flow) *Server {
@@ -39,8 +45,14 @@ func New(cfg *Config, f *flow.Flow) *Server {
 	}
 	f.SetOnClose(svr.Close)
 	svr.dchanServer = dchan.NewServer(svr.flow, svr)
+	if err := svr.dchanServer.SetScheduler(cfg.Sched); err != nil {
+		logex.Error("set scheduler fail:", err)
+	}
 
-	err := svr.uc.Load(cfg.DBPath)
+	store, err := uc.OpenStore(cfg.DBType, cfg.DBPath)
+	if err == nil {
+		err = svr.uc.Open(store)
+	}
 	if err != nil {
 		logex.Error("load user info fail:", err)
 	} else {
@@ -49,10 +61,41 @@ func New(cfg *Config, f *flow.Flow) *Server {
 	dhcp := ip.NewDHCP(cfg.Net)
 	logex.Info("creating dhcp for", cfg.Net)
 	svr.dhcp = dhcp
+	if cfg.Net6 != nil {
+		logex.Info("creating dhcp for", cfg.Net6)
+		svr.dhcp6 = ip.NewDHCP6(cfg.Net6)
+	}
+	svr.restoreLeases()
+
+	if err := svr.loadCert(); err != nil {
+		logex.Error("load cert of data channel fail:", err)
+	}
 
 	return svr
 }
 
+// loadCert loads the cert of http api for tls and quic channel, a self-signed
+// one is generated for quic if it's not provided.
+func (s *Server) loadCert() (err error) {
+	switch {
+	case s.cfg.HasChannelType("tls"):
+		s.cert, err = dchan.LoadCert(s.cfg.HTTPCert, s.cfg.HTTPKey)
+	case s.cfg.HasChannelType("quic"):
+		if s.cfg.HTTPCert != "" {
+			s.cert, err = dchan.LoadCert(s.cfg.HTTPCert, s.cfg.HTTPKey)
+		} else {
+			s.cert, err = dchan.NewSelfSignedCert()
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.certPin = dchan.CertFingerprint(s.cert.Certificate[0])
+	return nil
+}
+
 func (s *Server) runShell() {
 	shell, err := NewShell(s, s.cfg.Sock)
 	if err != nil {
@@ -76,8 +119,20 @@ func (s *Server) runHttp() {
 }
 
 func (s *Server) loadDataChannel() {
-	s.dchanGroup = dchan.NewListenerGroup(s.flow, s.cfg.ChannelType, s)
-	go s.dchanGroup.Run(4)
+	types := s.cfg.ChannelTypes()
+	groups := make([]*dchan.ListenerGroup, len(types))
+	for idx, typ := range types {
+		groups[idx] = dchan.NewListenerGroup(s.flow, typ, s.channelOption(typ), s)
+	}
+	s.dchanGroups = groups
+	for idx, typ := range types {
+		listeners := 4
+		if typ == "ws" && s.cfg.WSAddr != "" {
+			// the address is fixed
+			listeners = 1
+		}
+		go groups[idx].Run(listeners)
+	}
 }
 
 func (s *Server) initAndRunTun() error {
@@ -113,9 +168,13 @@ func (s *Server) Run() {
 	}
 	s.initControllerGroup() // after tun
 	go s.runPprof()
+	if s.cfg.Metrics != "" {
+		go s.runMetrics()
+	}
 	go s.runHttp()
 	go s.runShell()
 	go s.loadDataChannel()
+	go s.leaseLoop()
 }
 
 // -----------------------------------------------------------------------------
@@ -129,10 +188,53 @@ func (s *Server) OnNewUser(userId int) {
 	}
 	logex.Debug("notify controller new user is logined")
 	s.controllerGroup.UserLogin(u)
+	// the redundant copies of new session start from 1
+	s.dchanServer.ResetDedup(userId)
 
 	logex.Infof("new user is coming: Id: %v, Name: %v", u.Id, u.Name)
 }
 
+// KickUser closes the data channels and controller of the user, the token
+// is reset so the client need to login again.
+func (s *Server) KickUser(name string) error {
+	u := s.uc.Find(name)
+	if u == nil {
+		return uc.ErrUserNotFound.Trace()
+	}
+	s.kickUser(u)
+	return s.uc.ResetToken(name)
+}
+
+func (s *Server) kickUser(u *uc.User) {
+	s.dchanServer.CloseGroup(int(u.Id))
+	s.controllerGroup.UserLogout(u.Id)
+	logex.Infof("user is kicked: Id: %v, Name: %v", u.Id, u.Name)
+}
+
+// DeleteUser kicks the user and releases its ip.
+func (s *Server) DeleteUser(name string) error {
+	u, err := s.uc.Delete(name)
+	if err != nil {
+		return err
+	}
+	s.kickUser(u)
+	if u.Net != nil {
+		s.dhcp.Release(*u.Net)
+	}
+	if u.StaticIP != nil {
+		s.dhcp.Release(*u.StaticIP)
+	}
+	if u.Lease != nil {
+		s.releaseLease(u.Lease)
+	}
+	return nil
+}
+
+func (s *Server) SaveUsers() error {
+	s.foldUsages(s.cl.Now())
+	return s.uc.Flush()
+}
+
 // controller -> user -> datachannel
 func (s *Server) GetUserChannelFromDataChannel(id int) (
 	fromUser packet.RecvChan, toUser packet.SendChan, err error) {
@@ -145,52 +247,129 @@ func (s *Server) GetUserChannelFromDataChannel(id int) (
 	return
 }
 
-func (s *Server) GetUserToken(id int) ([]byte, error) {
+func (s *Server) GetUserToken(id int) ([]byte, packet.Version, error) {
 	u := s.uc.FindId(id)
 	if u == nil {
-		return nil, uc.ErrUserNotFound.Trace()
+		return nil, 0, uc.ErrUserNotFound.Trace()
+	}
+	if u.Disabled {
+		return nil, 0, uc.ErrUserDisabled.Trace()
 	}
-	return []byte(u.Token), nil
+	return []byte(u.Token), u.L2Version, nil
 }
 
-func (s *Server) OnDChanUpdate(port []int) {
-	s.controllerGroup.OnDchanPortUpdate(port)
+func (s *Server) GetRekey() packet.Rekey {
+	rekey, err := s.cfg.GetRekey()
+	if err != nil {
+		return packet.DefaultRekey
+	}
+	return rekey
+}
+
+func (s *Server) OnDChanUpdate() {
+	s.controllerGroup.OnDchanPortUpdate(s.GetAllDataChannel())
 }
 
 func (s *Server) Close() {
 	if s.shell != nil {
 		s.shell.Close()
 	}
+	if err := s.SaveUsers(); err != nil {
+		logex.Error("save user info failed:", err)
+	}
+	if err := s.uc.Close(); err != nil {
+		logex.Error("close user store failed:", err)
+	}
 }
 
 // -----------------------------------------------------------------------------
 // HTTP
 
+// GetChannelType returns the first channel type, the others are advertised
+// to the new clients by the controller.
 func (s *Server) GetChannelType() string {
-	return s.cfg.ChannelType
+	return s.cfg.ChannelTypes()[0]
+}
+
+// GetChannelOption returns the merged option of all channel types, nil if
+// none of them can be tuned.
+func (s *Server) GetChannelOption() *dchan.ChannelOption {
+	var opts []*dchan.ChannelOption
+	for _, typ := range s.cfg.ChannelTypes() {
+		opts = append(opts, s.channelOption(typ))
+	}
+	return dchan.MergeOption(opts...)
 }
 
-func (s *Server) AllocIP() *ip.IP {
-	return s.dhcp.Alloc()
+// channelOption returns nil if the channel type can't be tuned.
+func (s *Server) channelOption(typ string) *dchan.ChannelOption {
+	switch typ {
+	case "udp":
+	case "ws":
+		return &dchan.ChannelOption{
+			WSPath:     s.cfg.WSPath,
+			ListenAddr: s.cfg.WSAddr,
+		}
+	case "tls", "quic":
+		// the login is encrypted by the http key, so the clients can trust
+		// the pushed fingerprint
+		return &dchan.ChannelOption{
+			Fingerprint: s.certPin,
+			Cert:        s.cert,
+		}
+	default:
+		return nil
+	}
+	data, parity, _ := dchan.ParseFEC(s.cfg.FEC)
+	kcp, _ := dchan.ParseKCP(s.cfg.KCP, s.cfg.KCPWnd, s.cfg.KCPMTU)
+	return &dchan.ChannelOption{
+		DataShards:   data,
+		ParityShards: parity,
+		KCP:          kcp,
+		Crypt:        s.cfg.KCPCrypt,
+	}
+}
+
+func (s *Server) IsAllowCFB() bool {
+	return s.cfg.AllowCFB
 }
 
 func (s *Server) GetGateway() *ip.IPNet {
 	return s.dhcp.IPNet
 }
 
+func (s *Server) GetGateway6() *ip.IPNet6 {
+	if s.dhcp6 == nil {
+		return nil
+	}
+	return s.dhcp6.IPNet
+}
+
 func (s *Server) GetMTU() int {
 	return s.cfg.MTU
 }
 
+// GetDataChannel returns a port of the first channel type.
 func (s *Server) GetDataChannel() int {
-	return s.dchanGroup.GetDataChannel()
+	if len(s.dchanGroups) == 0 {
+		return -1
+	}
+	return s.dchanGroups[0].GetDataChannel()
 }
 
 // -----------------------------------------------------------------------------
 // controller
 
-func (s *Server) GetAllDataChannel() []int {
-	return s.dchanGroup.GetAllDataChannel()
+func (s *Server) GetAllDataChannel() []dchan.Endpoint {
+	var ret []dchan.Endpoint
+	for _, group := range s.dchanGroups {
+		ret = append(ret, group.GetEndpoints()...)
+	}
+	return ret
+}
+
+func (s *Server) IsHub() bool {
+	return s.cfg.Hub
 }
 
 func (s *Server) OnNewChannel(ch dchan.Channel) {
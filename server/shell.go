@@ -7,6 +7,7 @@ import (
 
 	"github.com/chzyer/flagly"
 	"github.com/chzyer/logex"
+	"github.com/chzyer/next/util/shellout"
 	"github.com/chzyer/readline"
 	"github.com/google/shlex"
 )
@@ -57,8 +58,11 @@ func (s *Shell) handleConn(conn net.Conn) {
 	}
 	defer rl.Close()
 
-	fset.Context(rl, s.svr)
-	fmt.Fprintln(rl, Slogan)
+	out := shellout.New(rl, rl.Stderr())
+	fset.Context(rl, s.svr, out)
+	if rl.Config.FuncIsTerminal() {
+		fmt.Fprintln(rl, Slogan)
+	}
 	for {
 		command, err := rl.Readline()
 		if err == readline.ErrInterrupt {
@@ -76,10 +80,9 @@ func (s *Shell) handleConn(conn net.Conn) {
 			continue
 		}
 
-		if err := fset.Run(args); err != nil {
-			fmt.Fprintln(rl.Stderr(), err)
-			continue
-		}
+		args, isJSON := shellout.ParseArgs(args)
+		out.Reset(isJSON)
+		out.Done(fset.Run(args))
 	}
 }
 
@@ -101,6 +104,7 @@ func (s *Shell) Close() {
 type ShellCLI struct {
 	Help  flagly.CmdHelp `flagly:"handler"`
 	User  ShellUser      `flagly:"handler"`
+	ACL   *ShellACL      `flagly:"handler" name:"acl"`
 	Debug *ShellDebug    `flagly:"handler"`
 	Dchan *Dchan         `flagly:"handler"`
 }
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/chzyer/flagly"
+	"github.com/chzyer/next/uc"
+	"github.com/chzyer/next/util/shellout"
+)
+
+// the target of acl is a user name, or a group name prefixed by '@'
+type ShellACL struct {
+	Show *ShellACLShow `flagly:"handler"`
+	Add  *ShellACLAdd  `flagly:"handler"`
+	Del  *ShellACLDel  `flagly:"handler"`
+}
+
+type ShellACLShow struct {
+	Target string `type:"[0]"`
+}
+
+type aclRuleView struct {
+	Rule string `json:"rule"`
+	Hits uint64 `json:"hits"`
+}
+
+type aclView struct {
+	Target string        `json:"target"`
+	Rules  []aclRuleView `json:"rules"`
+	Drops  uint64        `json:"drops"`
+}
+
+func (c *ShellACLShow) FlaglyHandle(s *Server, out *shellout.Output) error {
+	targets := []string{c.Target}
+	if c.Target == "" {
+		targets = targets[:0]
+		for _, g := range s.uc.ACLGroups() {
+			targets = append(targets, "@"+g)
+		}
+		for _, u := range s.uc.Show() {
+			if len(u.ACL) > 0 {
+				targets = append(targets, u.Name)
+			}
+		}
+	}
+
+	views := make([]*aclView, 0, len(targets))
+	buf := bytes.NewBuffer(nil)
+	for _, target := range targets {
+		view, err := showACL(s, buf, target)
+		if err != nil {
+			return err
+		}
+		views = append(views, view)
+	}
+	return out.Result(views, buf.String())
+}
+
+func showACL(s *Server, w io.Writer, target string) (*aclView, error) {
+	rules, err := s.uc.ACL(target)
+	if err != nil {
+		return nil, err
+	}
+	view := &aclView{Target: target, Rules: make([]aclRuleView, 0, len(rules))}
+	fmt.Fprintf(w, "%v:\n", target)
+	for idx, r := range rules {
+		view.Rules = append(view.Rules, aclRuleView{r.String(), r.Hits()})
+		fmt.Fprintf(w, "  #%v %v, hits: %v\n", idx, r, r.Hits())
+		if r.Deny {
+			view.Drops += r.Hits()
+		}
+	}
+	fmt.Fprintf(w, "  drops: %v\n", view.Drops)
+	return view, nil
+}
+
+type ShellACLAdd struct {
+	Deny   bool   `desc:"deny the matched packets"`
+	Proto  string `desc:"tcp, udp, icmp, icmpv6, or any"`
+	Port   string `desc:"port or range like 8000-9000, requires tcp or udp"`
+	Target string `type:"[0]"`
+	CIDR   string `type:"[1]"`
+}
+
+func (c *ShellACLAdd) FlaglyHandle(s *Server) error {
+	if c.Target == "" || c.CIDR == "" {
+		return flagly.Error("missing target or cidr")
+	}
+	r, err := uc.NewACLRule(c.Deny, c.CIDR, c.Proto, c.Port)
+	if err != nil {
+		return err
+	}
+	if err := s.uc.AddACL(c.Target, r); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+type ShellACLDel struct {
+	Target string `type:"[0]"`
+	Index  string `type:"[1]"`
+}
+
+func (c *ShellACLDel) FlaglyHandle(s *Server) error {
+	if c.Target == "" || c.Index == "" {
+		return flagly.Error("missing target or index")
+	}
+	idx, err := strconv.Atoi(c.Index)
+	if err != nil {
+		return flagly.Error("invalid index")
+	}
+	if err := s.uc.DelACL(c.Target, idx); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
@@ -1,4 +1,36 @@
 package server
 
+import (
+	"strings"
+
+	"github.com/chzyer/flagly"
+	"github.com/chzyer/next/dchan"
+	"github.com/chzyer/next/util/shellout"
+)
+
 type Dchan struct {
+	Sched *ShellDchanSched `flagly:"handler"`
+}
+
+type ShellDchanSched struct {
+	Name string `type:"[0]"`
+}
+
+type dchanSched struct {
+	Name string `json:"name"`
+}
+
+func (sh *ShellDchanSched) FlaglyDesc() string {
+	return "show or set the scheduler of all users: " + strings.Join(dchan.SchedulerNames, ", ")
+}
+
+func (sh *ShellDchanSched) FlaglyHandle(s *Server, out *shellout.Output) error {
+	if sh.Name == "" {
+		name := s.dchanServer.GetScheduler()
+		return out.Result(&dchanSched{name}, "scheduler: "+name)
+	}
+	if err := s.dchanServer.SetScheduler(sh.Name); err != nil {
+		return flagly.Error(err.Error())
+	}
+	return out.Message("scheduler is set to '%v'", sh.Name)
 }
@@ -1,16 +1,44 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
-	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/chzyer/flagly"
+	"github.com/chzyer/next/ip"
+	"github.com/chzyer/next/uc"
+	"github.com/chzyer/next/util"
+	"github.com/chzyer/next/util/shellout"
 	"github.com/chzyer/readline"
 )
 
 type ShellUser struct {
-	Show *ShellUserShow `flagly:"handler"`
-	Add  *ShellUserAdd  `flagly:"handler"`
+	Show       *ShellUserShow       `flagly:"handler"`
+	Add        *ShellUserAdd        `flagly:"handler"`
+	Del        *ShellUserDel        `flagly:"handler"`
+	Disable    *ShellUserDisable    `flagly:"handler"`
+	Enable     *ShellUserEnable     `flagly:"handler"`
+	Rename     *ShellUserRename     `flagly:"handler"`
+	Passwd     *ShellUserPasswd     `flagly:"handler"`
+	Kick       *ShellUserKick       `flagly:"handler"`
+	SetAdmin   *ShellUserSetAdmin   `flagly:"handler" name:"set-admin"`
+	SetIP      *ShellUserSetIP      `flagly:"handler" name:"set-ip"`
+	SetGroup   *ShellUserSetGroup   `flagly:"handler" name:"set-group"`
+	SetLimit   *ShellUserSetLimit   `flagly:"handler" name:"set-limit"`
+	ResetQuota *ShellUserResetQuota `flagly:"handler" name:"reset-quota"`
+	Stats      *ShellUserStats      `flagly:"handler"`
+	Import     *ShellUserImport     `flagly:"handler"`
+}
+
+func saveUsers(s *Server) error {
+	if err := s.SaveUsers(); err != nil {
+		return fmt.Errorf("save user info failed: %v", err.Error())
+	}
+	return nil
 }
 
 type ShellUserAdd struct {
@@ -30,24 +58,366 @@ func (c *ShellUserAdd) FlaglyHandle(s *Server, rl *readline.Instance) error {
 	if err != nil {
 		return fmt.Errorf("aborted")
 	}
-	s.uc.Register(c.Name, string(pasw))
-	err = s.uc.Save(s.cfg.DBPath)
+	if _, err := s.uc.Register(c.Name, string(pasw)); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+type ShellUserDel struct {
+	Name string `type:"[0]"`
+}
+
+func (c *ShellUserDel) FlaglyHandle(s *Server) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	if err := s.DeleteUser(c.Name); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+// the disabled user is kicked, and can't login until it's enabled
+type ShellUserDisable struct {
+	Name string `type:"[0]"`
+}
+
+func (c *ShellUserDisable) FlaglyHandle(s *Server) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	if err := s.uc.SetDisabled(c.Name, true); err != nil {
+		return err
+	}
+	if err := s.KickUser(c.Name); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+type ShellUserEnable struct {
+	Name string `type:"[0]"`
+}
+
+func (c *ShellUserEnable) FlaglyHandle(s *Server) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	if err := s.uc.SetDisabled(c.Name, false); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+type ShellUserRename struct {
+	Name    string `type:"[0]"`
+	NewName string `type:"[1]"`
+}
+
+func (c *ShellUserRename) FlaglyHandle(s *Server) error {
+	if c.Name == "" || c.NewName == "" {
+		return flagly.Error("missing name")
+	}
+	if err := s.uc.Rename(c.Name, c.NewName); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+type ShellUserPasswd struct {
+	Name string `type:"[0]"`
+}
+
+func (c *ShellUserPasswd) FlaglyHandle(s *Server, rl *readline.Instance) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	if s.uc.Find(c.Name) == nil {
+		return flagly.Error(fmt.Sprintf("user '%s' not found", c.Name))
+	}
+	pasw, err := rl.ReadPassword("new password: ")
 	if err != nil {
-		err = fmt.Errorf("save user info failed: %v", err.Error())
+		return fmt.Errorf("aborted")
 	}
-	return err
+	if err := s.uc.SetPassword(c.Name, string(pasw)); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+type ShellUserKick struct {
+	Name string `type:"[0]"`
+}
+
+func (c *ShellUserKick) FlaglyHandle(s *Server) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	return s.KickUser(c.Name)
+}
+
+// the reservation is removed if ip is empty
+type ShellUserSetIP struct {
+	Name string `type:"[0]"`
+	IP   string `type:"[1]"`
+}
+
+func (c *ShellUserSetIP) FlaglyHandle(s *Server) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	var addr *ip.IP
+	if c.IP != "" {
+		netIP := net.ParseIP(c.IP).To4()
+		if netIP == nil {
+			return flagly.Error(fmt.Sprintf("invalid ip: %v", c.IP))
+		}
+		ret := ip.CopyIP(netIP)
+		addr = &ret
+	}
+	if err := s.SetUserIP(c.Name, addr); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+type ShellUserSetAdmin struct {
+	Name string `type:"[0]"`
+	Off  bool   `name:"off" desc:"revoke the admin"`
+}
+
+func (c *ShellUserSetAdmin) FlaglyHandle(s *Server) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	if err := s.uc.SetAdmin(c.Name, !c.Off); err != nil {
+		return err
+	}
+	return saveUsers(s)
 }
 
 type ShellUserShow struct {
 	All bool `name:"a"`
 }
 
-func (su ShellUserShow) FlaglyHandle(s *Server, rl *readline.Instance) error {
+// userView is the user in json, the secrets are omitted.
+type userView struct {
+	Id            uint16   `json:"id"`
+	Name          string   `json:"name"`
+	Net           string   `json:"net,omitempty"`
+	Net6          string   `json:"net6,omitempty"`
+	StaticIP      string   `json:"static_ip,omitempty"`
+	IsAdmin       bool     `json:"admin"`
+	Disabled      bool     `json:"disabled"`
+	ResetPassword bool     `json:"reset_password,omitempty"`
+	Groups        []string `json:"groups"`
+	UploadLimit   int64    `json:"upload_limit"`
+	DownloadLimit int64    `json:"download_limit"`
+	Quota         int64    `json:"quota"`
+	QuotaUsed     int64    `json:"quota_used"`
+}
+
+func newUserView(u *uc.User, now time.Time) *userView {
+	v := &userView{
+		Id:            u.Id,
+		Name:          u.Name,
+		IsAdmin:       u.IsAdmin,
+		Disabled:      u.Disabled,
+		ResetPassword: u.ResetPassword,
+		Groups:        append([]string{}, u.Groups...),
+		UploadLimit:   u.UploadLimit,
+		DownloadLimit: u.DownloadLimit,
+		Quota:         u.Quota,
+		QuotaUsed:     u.QuotaUsage(now),
+	}
+	if u.Net != nil {
+		v.Net = u.Net.String()
+	}
+	if u.Net6 != nil {
+		v.Net6 = u.Net6.String()
+	}
+	if u.StaticIP != nil {
+		v.StaticIP = u.StaticIP.String()
+	}
+	return v
+}
+
+func (su ShellUserShow) FlaglyHandle(s *Server, out *shellout.Output) error {
+	views := []*userView{}
+	buf := bytes.NewBuffer(nil)
 	for _, u := range s.uc.Show() {
 		if u.Net == nil && !su.All {
 			continue
 		}
-		io.WriteString(rl, u.String()+"\n")
+		views = append(views, newUserView(&u, s.cl.Now()))
+		buf.WriteString(u.String() + "\n")
 	}
-	return nil
+	return out.Result(views, buf.String())
+}
+
+// set the groups of user, separated by comma, remove all groups if empty
+type ShellUserSetGroup struct {
+	Name   string `type:"[0]"`
+	Groups string `type:"[1]"`
+}
+
+func (c *ShellUserSetGroup) FlaglyHandle(s *Server) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	var groups []string
+	for _, g := range strings.Split(c.Groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	if err := s.uc.SetGroups(c.Name, groups); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+// set the rates and monthly quota in bytes like 512K, 10M, 100G, the
+// missing or zero ones are unlimited
+type ShellUserSetLimit struct {
+	Up    string `desc:"upload bytes per second"`
+	Down  string `desc:"download bytes per second"`
+	Quota string `desc:"bytes per month of both directions"`
+	Name  string `type:"[0]"`
+}
+
+func (c *ShellUserSetLimit) FlaglyHandle(s *Server) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	var limits [3]int64
+	for idx, val := range []string{c.Up, c.Down, c.Quota} {
+		if val == "" {
+			continue
+		}
+		size, err := util.ParseSize(val)
+		if err != nil {
+			return flagly.Error(fmt.Sprintf("invalid size %v: %v", val, err))
+		}
+		limits[idx] = int64(size)
+	}
+	if err := s.SetUserLimit(c.Name, limits[0], limits[1], limits[2]); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+// clear the quota usage of current month, the user can login again
+type ShellUserResetQuota struct {
+	Name string `type:"[0]"`
+}
+
+func (c *ShellUserResetQuota) FlaglyHandle(s *Server) error {
+	if c.Name == "" {
+		return flagly.Error("missing name")
+	}
+	if err := s.uc.ResetQuota(c.Name); err != nil {
+		return err
+	}
+	return saveUsers(s)
+}
+
+// show the traffic of user, all of the days are exported by -json
+type ShellUserStats struct {
+	Days int    `desc:"show the last n days" default:"7"`
+	Name string `type:"[0]"`
+}
+
+func (c *ShellUserStats) FlaglyHandle(s *Server, out *shellout.Output) error {
+	s.foldUsages(s.cl.Now())
+	stats := make(map[string]*uc.Traffic)
+	if c.Name != "" {
+		traffic, err := s.uc.GetTraffic(c.Name)
+		if err != nil {
+			return err
+		}
+		stats[c.Name] = traffic
+	} else {
+		for _, u := range s.uc.Show() {
+			traffic, err := s.uc.GetTraffic(u.Name)
+			if err != nil {
+				return err
+			}
+			stats[u.Name] = traffic
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		traffic := stats[name]
+		fmt.Fprintf(buf, "%v: %v\n", name, traffic.Total)
+		days := traffic.Days
+		if c.Days >= 0 && len(days) > c.Days {
+			days = days[len(days)-c.Days:]
+		}
+		for _, day := range days {
+			fmt.Fprintf(buf, "  %v: %v\n", day.Day, day.TrafficCounter)
+		}
+	}
+	return out.Result(stats, buf.String())
+}
+
+// import the users which are not exists from a htpasswd or json file, the
+// path is opened by server.
+type ShellUserImport struct {
+	Format string `name:"f" desc:"htpasswd, json" default:"htpasswd"`
+	Path   string `type:"[0]"`
+}
+
+type importResult struct {
+	Imported []string `json:"imported"`
+	// disabled until the password is set by `user passwd`
+	ResetPassword []string          `json:"reset_password,omitempty"`
+	Failed        map[string]string `json:"failed,omitempty"`
+}
+
+func (c *ShellUserImport) FlaglyHandle(s *Server, out *shellout.Output) error {
+	if c.Path == "" {
+		return flagly.Error("missing path")
+	}
+	store, err := uc.NewImportStore(c.Format, c.Path)
+	if err != nil {
+		return err
+	}
+	added, err := s.uc.Import(store)
+	if err != nil {
+		return err
+	}
+	ret := &importResult{Imported: []string{}}
+	buf := bytes.NewBuffer(nil)
+	for _, u := range added {
+		ret.Imported = append(ret.Imported, u.Name)
+		if u.ResetPassword {
+			ret.ResetPassword = append(ret.ResetPassword, u.Name)
+			fmt.Fprintf(buf, "%v is disabled until the password is set\n", u.Name)
+		}
+		if u.StaticIP != nil {
+			// reserve it in dhcp
+			addr := u.StaticIP
+			s.uc.SetStaticIP(u.Name, nil)
+			if err := s.SetUserIP(u.Name, addr); err != nil {
+				if ret.Failed == nil {
+					ret.Failed = make(map[string]string)
+				}
+				ret.Failed[u.Name] = err.Error()
+				fmt.Fprintf(buf, "set ip of %v failed: %v\n", u.Name, err)
+			}
+		}
+	}
+	fmt.Fprintf(buf, "%v users imported\n", len(added))
+	if err := saveUsers(s); err != nil {
+		return err
+	}
+	return out.Result(ret, buf.String())
 }
@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
+	"github.com/chzyer/next/route"
 	"github.com/chzyer/tunnel"
 )
 
@@ -23,6 +24,12 @@ func newTun(f *flow.Flow, cfg *Config) (*Tun, error) {
 	if err != nil {
 		return nil, logex.Trace(err)
 	}
+	if cfg.Net6 != nil {
+		if err := route.SetAddr6(tun.Name, cfg.Net6.ToNet()); err != nil {
+			tun.Close()
+			return nil, logex.Trace(err)
+		}
+	}
 	t := &Tun{
 		tun: tun,
 		in:  make(chan []byte),
@@ -0,0 +1,41 @@
+package statistic
+
+import "sync/atomic"
+
+type DropInfo struct {
+	Replay  int64
+	Invalid int64
+}
+
+func (d *DropInfo) Merge(d2 *DropInfo) *DropInfo {
+	d.Replay += d2.Replay
+	d.Invalid += d2.Invalid
+	return d
+}
+
+// Drop counts the frames which are dropped by the data channel.
+type Drop struct {
+	replay  int64
+	invalid int64
+}
+
+func NewDrop() *Drop {
+	return &Drop{}
+}
+
+func (d *Drop) Replay() {
+	atomic.AddInt64(&d.replay, 1)
+}
+
+// Invalid counts the malformed or forged frames, only the datagram channel
+// drops them, the stream ones are closed instead.
+func (d *Drop) Invalid() {
+	atomic.AddInt64(&d.invalid, 1)
+}
+
+func (d *Drop) GetDrop() *DropInfo {
+	return &DropInfo{
+		Replay:  atomic.LoadInt64(&d.replay),
+		Invalid: atomic.LoadInt64(&d.invalid),
+	}
+}
@@ -118,17 +118,36 @@ func (s *HeartBeat) lifeTime() time.Duration {
 	return time.Now().Round(time.Second).Sub(s.start.Round(time.Second))
 }
 
-func (s HeartBeat) String() string {
-	min1 := s.getMin(1)
-	min5 := s.getMin(5)
-	min15 := s.getMin(15)
+// HeartBeatSummary is the round trip time in the last 15, 5 and 1 minutes,
+// the durations are in nanoseconds in json.
+type HeartBeatSummary struct {
+	RTT15      time.Duration `json:"rtt_15m"`
+	RTT5       time.Duration `json:"rtt_5m"`
+	RTT1       time.Duration `json:"rtt_1m"`
+	LastCommit time.Duration `json:"last_commit"`
+	LifeTime   time.Duration `json:"life_time"`
+}
+
+func (s HeartBeatSummary) String() string {
 	return fmt.Sprintf("RTT: %v %v %v, LC: %v, LT: %v",
-		min15.rtt(), min5.rtt(), min1.rtt(),
-		(time.Second * time.Duration(time.Now().Unix()-s.lastCommit)).String(),
-		s.lifeTime(),
+		s.RTT15, s.RTT5, s.RTT1, s.LastCommit, s.LifeTime,
 	)
 }
 
+func (s HeartBeat) Summary() HeartBeatSummary {
+	return HeartBeatSummary{
+		RTT15:      s.getMin(15).rtt(),
+		RTT5:       s.getMin(5).rtt(),
+		RTT1:       s.getMin(1).rtt(),
+		LastCommit: time.Second * time.Duration(time.Now().Unix()-s.lastCommit),
+		LifeTime:   s.lifeTime(),
+	}
+}
+
+func (s HeartBeat) String() string {
+	return s.Summary().String()
+}
+
 type heartBeatItem struct {
 	reqid uint32
 	time  time.Time
@@ -141,6 +160,7 @@ type HeartBeatStage struct {
 	addChan     chan *packet.Packet
 	timeout     time.Duration
 	delegate    CleanDelegate
+	rtt         atomic.Value // func() time.Duration
 
 	stat HeartBeat
 }
@@ -164,6 +184,13 @@ func NewHeartBeatStage(f *flow.Flow, timeout time.Duration, d CleanDelegate) *He
 	return hbs
 }
 
+// UseRTT makes the stage submit the rtt returned by f instead of the
+// measured one when a heartbeat is replied, it's for the transports which
+// know the rtt of path better, e.g. quic. Zero means unknown.
+func (h *HeartBeatStage) UseRTT(f func() time.Duration) {
+	h.rtt.Store(f)
+}
+
 func (h *HeartBeatStage) New() *packet.Packet {
 	return packet.New(h.Now(), packet.HEARTBEAT)
 }
@@ -258,7 +285,13 @@ loop:
 			logex.Debugf("two time: mem - payload = %v",
 				h.item(elem).time.Sub(timeStart),
 			)
-			h.stat.submitDuration(time.Now().Sub(timeStart))
+			d := time.Now().Sub(timeStart)
+			if f, ok := h.rtt.Load().(func() time.Duration); ok {
+				if rtt := f(); rtt > 0 {
+					d = rtt
+				}
+			}
+			h.stat.submitDuration(d)
 			h.staging.Remove(elem)
 		case iv := <-h.addChan:
 			h.staging.PushBack(heartBeatItem{iv.ReqId, time.Now()})
@@ -11,11 +11,17 @@ import (
 type SpeedInfo struct {
 	Download util.Unit
 	Upload   util.Unit
+
+	// since the channel is created
+	TotalDownload util.Unit
+	TotalUpload   util.Unit
 }
 
 func (s *SpeedInfo) Merge(si *SpeedInfo) *SpeedInfo {
 	s.Download += si.Download
 	s.Upload += si.Upload
+	s.TotalDownload += si.TotalDownload
+	s.TotalUpload += si.TotalUpload
 	return s
 }
 
@@ -26,6 +32,9 @@ type Speed struct {
 
 	uploadLastTime   int64
 	downloadLastTime int64
+
+	totalUpload   int64
+	totalDownload int64
 	sync.Mutex
 }
 
@@ -53,16 +62,21 @@ func (s *Speed) checkOutdated() {
 func (s *Speed) Upload(n int) {
 	s.checkOutdated()
 	atomic.AddInt64(&s.upload, int64(n))
+	atomic.AddInt64(&s.totalUpload, int64(n))
 }
 
 func (s *Speed) Download(n int) {
 	s.checkOutdated()
 	atomic.AddInt64(&s.download, int64(n))
+	atomic.AddInt64(&s.totalDownload, int64(n))
 }
 
 func (s *Speed) GetSpeed() *SpeedInfo {
 	return &SpeedInfo{
 		Download: util.Unit(atomic.LoadInt64(&s.downloadLastTime)),
 		Upload:   util.Unit(atomic.LoadInt64(&s.uploadLastTime)),
+
+		TotalDownload: util.Unit(atomic.LoadInt64(&s.totalDownload)),
+		TotalUpload:   util.Unit(atomic.LoadInt64(&s.totalUpload)),
 	}
 }
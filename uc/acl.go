@@ -0,0 +1,285 @@
+package uc
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/packet"
+)
+
+var (
+	ErrInvalidACLRule = logex.Define("invalid acl rule: %v")
+	ErrACLNotFound    = logex.Define("acl rule not found")
+)
+
+// ACLRule matches the destination of the packets which are sent by user.
+type ACLRule struct {
+	Deny    bool
+	CIDR    string
+	Proto   uint8  // 0 matches all protocols
+	PortMin uint16 // 0 matches all ports, only for tcp and udp
+	PortMax uint16
+
+	ipnet *net.IPNet
+	hits  uint64
+}
+
+var aclProtos = map[string]uint8{
+	"":       0,
+	"any":    0,
+	"icmp":   packet.ProtoICMP,
+	"tcp":    packet.ProtoTCP,
+	"udp":    packet.ProtoUDP,
+	"icmpv6": packet.ProtoICMPv6,
+}
+
+// NewACLRule parses the rule, the port can be a single port or a range like
+// "8000-9000".
+func NewACLRule(deny bool, cidr, proto, port string) (*ACLRule, error) {
+	r := &ACLRule{Deny: deny, CIDR: cidr}
+	p, ok := aclProtos[strings.ToLower(proto)]
+	if !ok {
+		return nil, ErrInvalidACLRule.Format("unknown proto " + proto)
+	}
+	r.Proto = p
+	if port != "" {
+		if p != packet.ProtoTCP && p != packet.ProtoUDP {
+			return nil, ErrInvalidACLRule.Format("port requires tcp or udp")
+		}
+		ports := strings.SplitN(port, "-", 2)
+		min, err := strconv.ParseUint(ports[0], 10, 16)
+		if err != nil {
+			return nil, ErrInvalidACLRule.Format("invalid port " + port)
+		}
+		max := min
+		if len(ports) == 2 {
+			max, err = strconv.ParseUint(ports[1], 10, 16)
+			if err != nil || max < min {
+				return nil, ErrInvalidACLRule.Format("invalid port " + port)
+			}
+		}
+		r.PortMin, r.PortMax = uint16(min), uint16(max)
+	}
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *ACLRule) compile() error {
+	_, ipnet, err := net.ParseCIDR(r.CIDR)
+	if err != nil {
+		return ErrInvalidACLRule.Format("invalid cidr " + r.CIDR)
+	}
+	r.ipnet = ipnet
+	return nil
+}
+
+func (r *ACLRule) Match(d *packet.DataPacket) bool {
+	if r.ipnet == nil || !r.ipnet.Contains(d.DestNetIP()) {
+		return false
+	}
+	if r.Proto != 0 && r.Proto != d.Proto() {
+		return false
+	}
+	if r.PortMin != 0 {
+		port, ok := d.DestPort()
+		if !ok || port < r.PortMin || port > r.PortMax {
+			return false
+		}
+	}
+	return true
+}
+
+// Hits returns how many packets are matched since server started.
+func (r *ACLRule) Hits() uint64 {
+	return atomic.LoadUint64(&r.hits)
+}
+
+func (r *ACLRule) String() string {
+	action := "allow"
+	if r.Deny {
+		action = "deny"
+	}
+	proto := "any"
+	for name, p := range aclProtos {
+		if name != "" && name != "any" && p == r.Proto {
+			proto = name
+		}
+	}
+	if r.Proto != 0 && proto == "any" {
+		proto = strconv.Itoa(int(r.Proto))
+	}
+	ret := fmt.Sprintf("%v %v %v", action, proto, r.CIDR)
+	if r.PortMin != 0 {
+		ret += fmt.Sprintf(" port %v", r.PortMin)
+		if r.PortMax != r.PortMin {
+			ret += fmt.Sprintf("-%v", r.PortMax)
+		}
+	}
+	return ret
+}
+
+// ACLStore persists the rules of groups, the rules of user are kept in
+// UserInfo.
+type ACLStore interface {
+	LoadACL() (map[string][]*ACLRule, error)
+	SaveACL(map[string][]*ACLRule) error
+}
+
+// IsGroupTarget reports whether the target of acl is a group, which is
+// prefixed by '@'.
+func IsGroupTarget(target string) bool {
+	return strings.HasPrefix(target, "@")
+}
+
+func compileACL(rules []*ACLRule) {
+	for _, r := range rules {
+		if err := r.compile(); err != nil {
+			logex.Error(err)
+		}
+	}
+}
+
+// Allow evaluates the rules of user and then its groups, the first matched
+// rule decides, and the packet is allowed if nothing is matched.
+func (us *Users) Allow(u *User, d *packet.DataPacket) bool {
+	return u.Allow(d)
+}
+
+// Allow is called for each packet, so it reads the snapshot of rules
+// without locking Users.
+func (u *User) Allow(d *packet.DataPacket) bool {
+	if u.acl == nil {
+		return true
+	}
+	rules, _ := u.acl.Load().([][]*ACLRule)
+	for _, rs := range rules {
+		if r := matchACL(rs, d); r != nil {
+			return !r.Deny
+		}
+	}
+	return true
+}
+
+// refreshACL replaces the snapshot of rules of user and its groups in
+// order, it's called with Users locked after the rules or groups changed.
+func (us *Users) refreshACL(u *User) {
+	if u.acl == nil {
+		return
+	}
+	var rules [][]*ACLRule
+	if len(u.ACL) > 0 {
+		rules = append(rules, u.ACL)
+	}
+	for _, g := range u.Groups {
+		if rs := us.groupACL[g]; len(rs) > 0 {
+			rules = append(rules, rs)
+		}
+	}
+	u.acl.Store(rules)
+}
+
+func (us *Users) refreshAllACL() {
+	for idx := range us.user {
+		us.refreshACL(&us.user[idx])
+	}
+}
+
+func matchACL(rules []*ACLRule, d *packet.DataPacket) *ACLRule {
+	for _, r := range rules {
+		if r.Match(d) {
+			atomic.AddUint64(&r.hits, 1)
+			return r
+		}
+	}
+	return nil
+}
+
+// ACL returns the rules of user or group (prefixed by '@').
+func (us *Users) ACL(target string) ([]*ACLRule, error) {
+	us.m.RLock()
+	defer us.m.RUnlock()
+
+	if IsGroupTarget(target) {
+		return us.groupACL[target[1:]], nil
+	}
+	u := us.Find(target)
+	if u == nil {
+		return nil, ErrUserNotFound.Trace()
+	}
+	return u.ACL, nil
+}
+
+// AddACL appends the rule to user or group (prefixed by '@').
+func (us *Users) AddACL(target string, r *ACLRule) error {
+	return us.updateACL(target, func(rules []*ACLRule) ([]*ACLRule, error) {
+		return append(rules, r), nil
+	})
+}
+
+func (us *Users) DelACL(target string, idx int) error {
+	return us.updateACL(target, func(rules []*ACLRule) ([]*ACLRule, error) {
+		if idx < 0 || idx >= len(rules) {
+			return nil, ErrACLNotFound.Trace()
+		}
+		return append(rules[:idx:idx], rules[idx+1:]...), nil
+	})
+}
+
+// updateACL replaces the rules instead of modifying them in place, so the
+// ones returned by ACL are not changed.
+func (us *Users) updateACL(target string, f func([]*ACLRule) ([]*ACLRule, error)) error {
+	us.m.Lock()
+	defer us.m.Unlock()
+
+	if IsGroupTarget(target) {
+		group := target[1:]
+		rules, err := f(us.groupACL[group])
+		if err != nil {
+			return err
+		}
+		if us.groupACL == nil {
+			us.groupACL = make(map[string][]*ACLRule)
+		}
+		if len(rules) == 0 {
+			delete(us.groupACL, group)
+		} else {
+			us.groupACL[group] = rules
+		}
+		us.aclDirty = true
+		us.refreshAllACL()
+		return nil
+	}
+
+	u := us.Find(target)
+	if u == nil {
+		return ErrUserNotFound.Trace()
+	}
+	rules, err := f(u.ACL)
+	if err != nil {
+		return err
+	}
+	u.ACL = rules
+	us.markDirty(u.Id)
+	us.refreshACL(u)
+	return nil
+}
+
+// ACLGroups returns the groups which have rules.
+func (us *Users) ACLGroups() []string {
+	us.m.RLock()
+	defer us.m.RUnlock()
+
+	groups := make([]string, 0, len(us.groupACL))
+	for g := range us.groupACL {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	return groups
+}
@@ -0,0 +1,96 @@
+package uc
+
+import (
+	"os"
+	"testing"
+
+	"github.com/chzyer/next/packet"
+	"github.com/chzyer/test"
+)
+
+func newTCPPacket(dest [4]byte, port uint16) *packet.DataPacket {
+	payload := make([]byte, 24)
+	payload[0] = 0x45
+	payload[9] = packet.ProtoTCP
+	copy(payload[16:20], dest[:])
+	payload[22], payload[23] = byte(port>>8), byte(port)
+	return packet.NewDataPacket(payload)
+}
+
+func TestACLRule(t *testing.T) {
+	defer test.New(t)
+
+	r, err := NewACLRule(true, "10.0.0.0/8", "tcp", "8000-9000")
+	test.Nil(err)
+	test.Equal(r.String(), "deny tcp 10.0.0.0/8 port 8000-9000")
+	test.True(r.Match(newTCPPacket([4]byte{10, 1, 2, 3}, 8080)))
+	test.False(r.Match(newTCPPacket([4]byte{10, 1, 2, 3}, 80)))
+	test.False(r.Match(newTCPPacket([4]byte{192, 168, 1, 1}, 8080)))
+
+	r, err = NewACLRule(false, "::/0", "", "")
+	test.Nil(err)
+	test.Equal(r.String(), "allow any ::/0")
+	test.False(r.Match(newTCPPacket([4]byte{10, 1, 2, 3}, 80)))
+
+	_, err = NewACLRule(false, "10.0.0.0/33", "", "")
+	test.Equal(err, ErrInvalidACLRule)
+	_, err = NewACLRule(false, "10.0.0.0/8", "sctp", "")
+	test.Equal(err, ErrInvalidACLRule)
+	_, err = NewACLRule(false, "10.0.0.0/8", "icmp", "80")
+	test.Equal(err, ErrInvalidACLRule)
+	_, err = NewACLRule(false, "10.0.0.0/8", "tcp", "90-80")
+	test.Equal(err, ErrInvalidACLRule)
+}
+
+func TestUsersACL(t *testing.T) {
+	defer test.New(t)
+
+	for _, typ := range []string{StoreGob, StoreBolt} {
+		dbPath := "/tmp/users_acl.tmp"
+		os.Remove(dbPath)
+		os.Remove(dbPath + ".acl")
+
+		store, err := OpenStore(typ, dbPath)
+		test.Nil(err)
+		us := NewUsers()
+		test.Nil(us.Open(store))
+		alice, err := us.Register("alice", "bye")
+		test.Nil(err)
+		test.Nil(us.SetGroups("alice", []string{"dev"}))
+
+		ssh, _ := NewACLRule(false, "10.0.0.1/32", "tcp", "22")
+		denyAll, _ := NewACLRule(true, "0.0.0.0/0", "", "")
+		test.Nil(us.AddACL("alice", ssh))
+		test.Nil(us.AddACL("@dev", denyAll))
+		test.Equal(us.AddACL("bob", ssh), ErrUserNotFound)
+
+		test.True(us.Allow(alice, newTCPPacket([4]byte{10, 0, 0, 1}, 22)))
+		test.False(us.Allow(alice, newTCPPacket([4]byte{10, 0, 0, 1}, 80)))
+		test.False(us.Allow(alice, newTCPPacket([4]byte{8, 8, 8, 8}, 53)))
+		test.Equal(ssh.Hits(), uint64(1))
+		test.Equal(denyAll.Hits(), uint64(2))
+		test.Equal(us.ACLGroups(), []string{"dev"})
+
+		test.Nil(us.Flush())
+		test.Nil(us.Close())
+
+		store, err = OpenStore(typ, dbPath)
+		test.Nil(err)
+		us2 := NewUsers()
+		test.Nil(us2.Open(store))
+		alice = us2.Find("alice")
+		test.True(us2.Allow(alice, newTCPPacket([4]byte{10, 0, 0, 1}, 22)))
+		test.False(us2.Allow(alice, newTCPPacket([4]byte{10, 0, 0, 1}, 80)))
+
+		test.Equal(us2.DelACL("@dev", 1), ErrACLNotFound)
+		test.Nil(us2.DelACL("@dev", 0))
+		test.True(us2.Allow(alice, newTCPPacket([4]byte{10, 0, 0, 1}, 80)))
+		rules, err := us2.ACL("@dev")
+		test.Nil(err)
+		test.Equal(len(rules), 0)
+		test.Nil(us2.Close())
+
+		os.Remove(dbPath)
+		os.Remove(dbPath + ".acl")
+	}
+}
@@ -0,0 +1,122 @@
+package uc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/crypto"
+)
+
+var (
+	ErrInvalidUpgrade = logex.Define("invalid verifier upgrade")
+)
+
+// KDF is how the SRP private key is derived from the password.
+type KDF int
+
+const (
+	KDFSHA256   KDF = iota // H(salt + H(password)), the verifiers before argon2id
+	KDFArgon2id            // argon2id(password, salt)
+)
+
+// the kdf which is used by new passwords
+const KDFCurrent = KDFArgon2id
+
+func (k KDF) Private(salt []byte, pswd string) []byte {
+	switch k {
+	case KDFArgon2id:
+		return crypto.SRPPrivateArgon2(salt, pswd)
+	default:
+		return crypto.SRPPrivate(salt, pswd)
+	}
+}
+
+func (k KDF) String() string {
+	switch k {
+	case KDFSHA256:
+		return "sha256"
+	case KDFArgon2id:
+		return "argon2id"
+	default:
+		return fmt.Sprintf("<unknown kdf>:%v", int(k))
+	}
+}
+
+// NewVerifier generates a random salt and the verifier of pswd by KDFCurrent.
+func NewVerifier(pswd string) (salt, verifier []byte) {
+	salt = make([]byte, 16)
+	rand.Read(salt)
+	return salt, crypto.SRPVerifier(KDFCurrent.Private(salt, pswd))
+}
+
+func (ui *UserInfo) SetPassword(pswd string) {
+	ui.Salt, ui.Verifier = NewVerifier(pswd)
+	ui.KDF = KDFCurrent
+	ui.Password = ""
+	ui.ResetPassword = false
+}
+
+func (ui *UserInfo) CheckPassword(pswd string) bool {
+	if ui.Verifier == nil {
+		return false
+	}
+	verifier := crypto.SRPVerifier(ui.KDF.Private(ui.Salt, pswd))
+	return subtle.ConstantTimeCompare(verifier, ui.Verifier) == 1
+}
+
+func (ui *UserInfo) NeedUpgrade() bool {
+	return ui.KDF != KDFCurrent
+}
+
+// VerifierUpgrade is sent by client after the challenge says the verifier
+// is derived by an old kdf, since the server never sees the password.
+type VerifierUpgrade struct {
+	KDF      KDF    `json:"kdf"`
+	Salt     []byte `json:"salt"`
+	Verifier []byte `json:"verifier"`
+}
+
+func NewVerifierUpgrade(pswd string) *VerifierUpgrade {
+	salt, verifier := NewVerifier(pswd)
+	return &VerifierUpgrade{
+		KDF:      KDFCurrent,
+		Salt:     salt,
+		Verifier: verifier,
+	}
+}
+
+// Seal encrypts the upgrade by the SRP session key, so only the server
+// which verified the proof can accept it.
+func (v *VerifierUpgrade) Seal(key []byte) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	aead := crypto.NewAesGcm(key)
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, data, nil)
+}
+
+func OpenVerifierUpgrade(key, sealed []byte) (*VerifierUpgrade, error) {
+	aead := crypto.NewAesGcm(key)
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidUpgrade.Trace()
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrInvalidUpgrade.Trace(err)
+	}
+	var v *VerifierUpgrade
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, ErrInvalidUpgrade.Trace(err)
+	}
+	if v.KDF != KDFCurrent || len(v.Salt) == 0 || len(v.Verifier) == 0 {
+		return nil, ErrInvalidUpgrade.Trace()
+	}
+	return v, nil
+}
@@ -1,60 +1,73 @@
 package uc
 
 import (
-	"crypto/rand"
 	"encoding/binary"
+	"encoding/json"
 
 	"github.com/chzyer/logex"
 	"github.com/chzyer/next/crypto"
+	"github.com/chzyer/next/packet"
 )
 
 var (
 	ErrInvalidAuthToken = logex.Define("invalid auth token")
 )
 
-type AuthData struct {
-	UserName string
-	Passcode []byte
-}
+// The login is a SRP-6a handshake, server only keeps the salt and verifier
+// of a user, and the password is not sent.
+//
+//   client -> AuthChallengeRequest(username, A) -> server
+//   client <- AuthChallenge(id, salt, kdf, B)   <- server
+//   client -> AuthRequest(id, M1, upgrade)      -> server
+//   client <- AuthResponse(..., M2)             <- server
+//
+// The old clients send the password in AuthRequest(username, token, iv)
+// instead, it's only accepted if the server allows the legacy aes-cfb.
 
-// Token = aes_256_cfb(md5(pswd) + ":" + timestamp, key, iv)
-// key = specified key by config
-// iv = base64(rand(16))
-type AuthRequest struct {
+type AuthChallengeRequest struct {
 	UserName string `json:"username"`
-	Token    []byte `json:"token"`
-	IV       []byte `json:"iv"`
+	Public   []byte `json:"public"`
 }
 
-// passcode: sha1(password + salt)
-func NewAuthRequest(userName string, timestamp int64, passcode, key []byte) *AuthRequest {
-	iv := make([]byte, 16)
-	rand.Read(iv)
+type AuthChallenge struct {
+	Id     string `json:"id"`
+	Salt   []byte `json:"salt"`
+	KDF    KDF    `json:"kdf"`
+	Public []byte `json:"public"`
+}
 
-	// token = aes(passcode + timestamp)
-	token := make([]byte, len(passcode)+8) // timestamp
-	copy(token, passcode)
-	binary.BigEndian.PutUint64(token[len(passcode):], uint64(timestamp))
-	crypto.EncodeAes(token, token, key, iv)
+type AuthRequest struct {
+	Id    string `json:"id"`
+	Proof []byte `json:"proof"`
+	// sealed VerifierUpgrade, if the challenge's kdf is not KDFCurrent
+	Upgrade []byte `json:"upgrade,omitempty"`
 
-	return &AuthRequest{
-		UserName: userName,
-		Token:    token,
-		IV:       iv,
-	}
+	// the highest L2 version client supported, it's empty in old clients
+	L2Version packet.Version `json:"l2version,omitempty"`
+
+	// legacy, token = aes_256_cfb(password + timestamp, key, iv)
+	UserName string `json:"username,omitempty"`
+	Token    []byte `json:"token,omitempty"`
+	IV       []byte `json:"iv,omitempty"`
+}
+
+// IsLegacy tells the request is sent by the old clients.
+func (a *AuthRequest) IsLegacy() bool {
+	return a.Id == "" && a.Token != nil
 }
 
-func (a *AuthRequest) Decode(key []byte, nowTime int64) (*AuthData, error) {
+// DecodeLegacy returns the password in the legacy token.
+func (a *AuthRequest) DecodeLegacy(key []byte, nowTime int64) (string, error) {
+	if len(a.Token) < 8 || len(a.IV) != 16 {
+		return "", ErrInvalidAuthToken.Trace()
+	}
 	token := make([]byte, len(a.Token))
 	crypto.DecodeAes(token, a.Token, key, a.IV)
 	timeSent := int64(binary.BigEndian.Uint64(token[len(token)-8:]))
 	if nowTime-timeSent > 20 || timeSent > nowTime { // 20s expired time
-		return nil, ErrInvalidAuthToken.Trace()
+		return "", ErrInvalidAuthToken.Trace()
 	}
-	return &AuthData{
-		UserName: a.UserName,
-		Passcode: token[:len(token)-8],
-	}, nil
+	return string(token[:len(token)-8]), nil
 }
 
 type AuthResponse struct {
@@ -65,4 +78,15 @@ type AuthResponse struct {
 	Token       string `json:"token"`
 	DataChannel int    `json:"datachannel"`
 	ChannelType string `json:"channeltype"`
+	// the json of dchan.ChannelOption, empty if the channel type is used
+	// by default
+	ChannelOpt json.RawMessage `json:"channelopt,omitempty"`
+	// empty if the server only knows the legacy one
+	L2Version packet.Version `json:"l2version,omitempty"`
+	// proves the server knows the verifier of the user
+	Proof []byte `json:"proof"`
+
+	// empty if ipv6 is not enabled in server
+	Gateway6 string `json:"gateway6,omitempty"`
+	INet6    string `json:"inet6,omitempty"`
 }
@@ -0,0 +1,39 @@
+package uc
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/chzyer/next/crypto"
+	"github.com/chzyer/test"
+)
+
+func TestAuthRequestLegacy(t *testing.T) {
+	defer test.New(t)
+
+	key := test.RandBytes(32)
+	iv := test.RandBytes(16)
+	newReq := func(pswd string, ts int64) *AuthRequest {
+		token := make([]byte, len(pswd)+8)
+		copy(token, pswd)
+		binary.BigEndian.PutUint64(token[len(pswd):], uint64(ts))
+		crypto.EncodeAes(token, token, key, iv)
+		return &AuthRequest{UserName: "bob", Token: token, IV: iv}
+	}
+
+	req := newReq("pswd", 100)
+	test.True(req.IsLegacy())
+	pswd, err := req.DecodeLegacy(key, 110)
+	test.Nil(err)
+	test.Equal(pswd, "pswd")
+
+	// expired or from the future
+	_, err = req.DecodeLegacy(key, 121)
+	test.NotNil(err)
+	_, err = req.DecodeLegacy(key, 99)
+	test.NotNil(err)
+
+	_, err = (&AuthRequest{Token: []byte{1}, IV: iv}).DecodeLegacy(key, 100)
+	test.NotNil(err)
+	test.True(!(&AuthRequest{Id: "id", Proof: []byte{1}}).IsLegacy())
+}
@@ -0,0 +1,402 @@
+package uc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chzyer/logex"
+	"github.com/chzyer/next/ip"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrNoStore          = logex.Define("user store is not opened")
+	ErrReadOnlyStore    = logex.Define("user store is read-only")
+	ErrUnknownStoreType = logex.Define("unknown user store type: %v")
+	ErrInvalidImport    = logex.Define("invalid import line %v: %v")
+)
+
+// UserStore persists the users, the index of user in the slice is its id.
+type UserStore interface {
+	Load() ([]User, error)
+	Save(users []User) error
+	Close() error
+}
+
+// IncrementalStore can save a single user, only the changed users are
+// written in Users.Flush.
+type IncrementalStore interface {
+	UserStore
+	Put(ui *UserInfo) error
+}
+
+const (
+	StoreGob  = "gob"
+	StoreBolt = "bolt"
+)
+
+func CheckStoreType(typ string) error {
+	switch typ {
+	case StoreGob, StoreBolt:
+		return nil
+	}
+	return ErrUnknownStoreType.Format(typ)
+}
+
+func OpenStore(typ, path string) (UserStore, error) {
+	switch typ {
+	case StoreGob:
+		return NewGobStore(path), nil
+	case StoreBolt:
+		return OpenBoltStore(path)
+	}
+	return nil, ErrUnknownStoreType.Format(typ)
+}
+
+// -----------------------------------------------------------------------------
+// gob
+
+// GobStore keeps all users in one gob file, which is rewritten in each save.
+type GobStore struct {
+	path string
+}
+
+func NewGobStore(path string) *GobStore {
+	return &GobStore{path: path}
+}
+
+// Load returns no users if the file is not exists.
+func (s *GobStore) Load() ([]User, error) {
+	fh, err := os.OpenFile(s.path, os.O_RDONLY, 0600)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, logex.Trace(err)
+	}
+	defer fh.Close()
+
+	var users []User
+	if err := gob.NewDecoder(fh).Decode(&users); err != nil {
+		return nil, logex.Trace(err)
+	}
+	return users, nil
+}
+
+func (s *GobStore) Save(users []User) error {
+	fh, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	defer fh.Close()
+
+	return logex.Trace(gob.NewEncoder(fh).Encode(users))
+}
+
+func (s *GobStore) aclPath() string {
+	return s.path + ".acl"
+}
+
+// LoadACL reads the rules of groups from the file besides the users.
+func (s *GobStore) LoadACL() (map[string][]*ACLRule, error) {
+	fh, err := os.Open(s.aclPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, logex.Trace(err)
+	}
+	defer fh.Close()
+
+	var acl map[string][]*ACLRule
+	if err := gob.NewDecoder(fh).Decode(&acl); err != nil {
+		return nil, logex.Trace(err)
+	}
+	return acl, nil
+}
+
+func (s *GobStore) SaveACL(acl map[string][]*ACLRule) error {
+	fh, err := os.OpenFile(s.aclPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	defer fh.Close()
+
+	return logex.Trace(gob.NewEncoder(fh).Encode(acl))
+}
+
+func (s *GobStore) Close() error {
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// bolt
+
+var (
+	boltBucket    = []byte("users")
+	boltACLBucket = []byte("acl")
+)
+
+// BoltStore keeps each user in its own key, so a change only writes the
+// changed users.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltACLBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, logex.Trace(err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func boltKey(id uint16) []byte {
+	key := make([]byte, 2)
+	binary.BigEndian.PutUint16(key, id)
+	return key
+}
+
+func (s *BoltStore) Load() ([]User, error) {
+	var users []User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// keys are the big endian ids, so they are iterated in order
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			var ui *UserInfo
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&ui); err != nil {
+				return err
+			}
+			ui.Id = binary.BigEndian.Uint16(k)
+			for len(users) < int(ui.Id) {
+				// fill the hole as a tombstone
+				users = append(users, *NewUser(&UserInfo{
+					Id: uint16(len(users)), Deleted: true,
+				}))
+			}
+			users = append(users, *NewUser(ui))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	return users, nil
+}
+
+func (s *BoltStore) Save(users []User) error {
+	return logex.Trace(s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(boltBucket)
+		if err != nil {
+			return err
+		}
+		for idx := range users {
+			if err := boltPut(b, users[idx].UserInfo); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func (s *BoltStore) Put(ui *UserInfo) error {
+	return logex.Trace(s.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx.Bucket(boltBucket), ui)
+	}))
+}
+
+func boltPut(b *bolt.Bucket, ui *UserInfo) error {
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(ui); err != nil {
+		return err
+	}
+	return b.Put(boltKey(ui.Id), buf.Bytes())
+}
+
+// LoadACL reads the rules of groups, which are keyed by the group name.
+func (s *BoltStore) LoadACL() (map[string][]*ACLRule, error) {
+	acl := make(map[string][]*ACLRule)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltACLBucket).ForEach(func(k, v []byte) error {
+			var rules []*ACLRule
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&rules); err != nil {
+				return err
+			}
+			acl[string(k)] = rules
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	return acl, nil
+}
+
+func (s *BoltStore) SaveACL(acl map[string][]*ACLRule) error {
+	return logex.Trace(s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltACLBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(boltACLBucket)
+		if err != nil {
+			return err
+		}
+		for group, rules := range acl {
+			buf := bytes.NewBuffer(nil)
+			if err := gob.NewEncoder(buf).Encode(rules); err != nil {
+				return err
+			}
+			if err := b.Put([]byte(group), buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func (s *BoltStore) Close() error {
+	return logex.Trace(s.db.Close())
+}
+
+// -----------------------------------------------------------------------------
+// import
+
+const (
+	ImportHtpasswd = "htpasswd"
+	ImportJSON     = "json"
+)
+
+// ImportStore reads users from a htpasswd or json file, it's read-only and
+// only used to import users into another store.
+//
+// The verifiers can't be derived from the hashed entries of htpasswd, the
+// bcrypt, md5 and sha1 ones are imported as disabled users which need their
+// passwords to be reset, so are the users without password in json. The
+// legacy crypt(3) ones can't be told from plaintext, don't import them.
+//
+// The json file is an array of ImportUser.
+type ImportStore struct {
+	format string
+	path   string
+}
+
+type ImportUser struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	IsAdmin  bool   `json:"admin"`
+	Disabled bool   `json:"disabled"`
+	StaticIP string `json:"static_ip"`
+}
+
+func NewImportStore(format, path string) (*ImportStore, error) {
+	switch format {
+	case ImportHtpasswd, ImportJSON:
+	default:
+		return nil, ErrUnknownStoreType.Format(format)
+	}
+	return &ImportStore{format: format, path: path}, nil
+}
+
+// Load returns the users with the passwords, which are not hashed yet.
+func (s *ImportStore) Load() ([]User, error) {
+	fh, err := os.Open(s.path)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	defer fh.Close()
+
+	var ius []ImportUser
+	if s.format == ImportJSON {
+		if err := json.NewDecoder(fh).Decode(&ius); err != nil {
+			return nil, logex.Trace(err)
+		}
+	} else {
+		ius, err = readHtpasswd(fh)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	users := make([]User, 0, len(ius))
+	for idx, iu := range ius {
+		if iu.Name == "" {
+			return nil, ErrInvalidImport.Format(idx+1, "missing name")
+		}
+		ui := &UserInfo{
+			Id:       uint16(idx),
+			Name:     iu.Name,
+			Password: iu.Password,
+			IsAdmin:  iu.IsAdmin,
+			Disabled: iu.Disabled,
+		}
+		if iu.Password == "" {
+			ui.Disabled = true
+			ui.ResetPassword = true
+		}
+		if iu.StaticIP != "" {
+			addr := net.ParseIP(iu.StaticIP).To4()
+			if addr == nil {
+				return nil, ErrInvalidImport.Format(idx+1, "invalid static ip")
+			}
+			staticIP := ip.CopyIP(addr)
+			ui.StaticIP = &staticIP
+		}
+		users = append(users, *NewUser(ui))
+	}
+	return users, nil
+}
+
+func readHtpasswd(fh *os.File) ([]ImportUser, error) {
+	var ius []ImportUser
+	scanner := bufio.NewScanner(fh)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx <= 0 {
+			return nil, ErrInvalidImport.Format(lineNo, "missing ':'")
+		}
+		pswd := line[idx+1:]
+		if strings.HasPrefix(pswd, "$") || strings.HasPrefix(pswd, "{SHA}") {
+			// hashed, the password should be reset after imported
+			pswd = ""
+		}
+		ius = append(ius, ImportUser{Name: line[:idx], Password: pswd})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, logex.Trace(err)
+	}
+	return ius, nil
+}
+
+func (s *ImportStore) Save([]User) error {
+	return ErrReadOnlyStore.Trace()
+}
+
+func (s *ImportStore) Close() error {
+	return nil
+}
@@ -0,0 +1,131 @@
+package uc
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/chzyer/test"
+)
+
+func TestBoltStore(t *testing.T) {
+	defer test.New(t)
+
+	dbPath := "/tmp/users_bolt.tmp"
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+
+	store, err := OpenStore(StoreBolt, dbPath)
+	test.Nil(err)
+	us := NewUsers()
+	test.Nil(us.Open(store))
+	_, err = us.Register("alice", "bye")
+	test.Nil(err)
+	_, err = us.Register("bob", "bye")
+	test.Nil(err)
+	_, err = us.Register("carol", "bye")
+	test.Nil(err)
+	test.Equal(len(us.dirty), 3)
+	test.Nil(us.Flush())
+	test.Equal(len(us.dirty), 0)
+
+	_, err = us.Delete("bob")
+	test.Nil(err)
+	test.Nil(us.SetAdmin("carol", true))
+	test.Equal(len(us.dirty), 2)
+	test.Nil(us.Flush())
+	test.Nil(us.Close())
+
+	store, err = OpenStore(StoreBolt, dbPath)
+	test.Nil(err)
+	us2 := NewUsers()
+	test.Nil(us2.Open(store))
+	defer us2.Close()
+	test.Equal(len(us2.user), 3)
+	test.Nil(us2.Find("bob"))
+	test.NotNil(us2.LoginByName("alice", "bye"))
+	carol := us2.Find("carol")
+	test.Equal(carol.Id, uint16(2))
+	test.True(carol.IsAdmin)
+
+	// the ids are kept after a full save
+	test.Nil(store.Save(us2.user))
+	users, err := store.Load()
+	test.Nil(err)
+	test.Equal(len(users), 3)
+	test.True(users[1].Deleted)
+	test.Equal(users[2].Name, "carol")
+}
+
+func TestGobStoreNotExists(t *testing.T) {
+	defer test.New(t)
+
+	dbPath := "/tmp/users_gob.tmp"
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+
+	us := NewUsers()
+	test.Equal(us.Flush(), ErrNoStore)
+	test.Nil(us.Open(NewGobStore(dbPath)))
+	_, err := us.Register("alice", "bye")
+	test.Nil(err)
+	test.Nil(us.Flush())
+
+	us2 := NewUsers()
+	test.Nil(us2.Open(NewGobStore(dbPath)))
+	test.NotNil(us2.LoginByName("alice", "bye"))
+}
+
+func TestImportStore(t *testing.T) {
+	defer test.New(t)
+
+	path := "/tmp/users_import.tmp"
+	defer os.Remove(path)
+
+	test.Nil(ioutil.WriteFile(path, []byte("# comment\nalice:bye\n\nbob:bye2\n"), 0600))
+	store, err := NewImportStore(ImportHtpasswd, path)
+	test.Nil(err)
+	test.Equal(store.Save(nil), ErrReadOnlyStore)
+
+	us := NewUsers()
+	_, err = us.Register("alice", "old")
+	test.Nil(err)
+	added, err := us.Import(store)
+	test.Nil(err)
+	test.Equal(len(added), 1)
+	test.Equal(added[0].Id, uint16(1))
+	test.Nil(us.LoginByName("alice", "bye"))
+	test.NotNil(us.LoginByName("bob", "bye2"))
+	test.Equal(us.Find("bob").Password, "")
+
+	// the hashed ones need a new password
+	test.Nil(ioutil.WriteFile(path, []byte("erin:$apr1$xxx\nfrank:{SHA}xxx\n"), 0600))
+	added, err = us.Import(store)
+	test.Nil(err)
+	test.Equal(len(added), 2)
+	erin := us.Find("erin")
+	test.True(erin.Disabled && erin.ResetPassword)
+	test.Nil(erin.Verifier)
+	test.Equal(us.SetDisabled("erin", false), ErrNeedPassword)
+	test.Nil(us.SetPassword("erin", "bye"))
+	test.Nil(us.SetDisabled("erin", false))
+	test.NotNil(us.LoginByName("erin", "bye"))
+
+	test.Nil(ioutil.WriteFile(path, []byte(`[
+		{"name": "carol", "password": "bye", "admin": true, "static_ip": "10.8.0.9"},
+		{"name": "dave", "disabled": true}
+	]`), 0600))
+	store, err = NewImportStore(ImportJSON, path)
+	test.Nil(err)
+	added, err = us.Import(store)
+	test.Nil(err)
+	test.Equal(len(added), 2)
+	carol := us.LoginByName("carol", "bye")
+	test.NotNil(carol)
+	test.True(carol.IsAdmin)
+	test.Equal(carol.StaticIP.String(), "10.8.0.9")
+	test.True(us.Find("dave").Disabled)
+
+	_, err = NewImportStore("csv", path)
+	test.Equal(err, ErrUnknownStoreType)
+}
@@ -0,0 +1,96 @@
+package uc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chzyer/next/util"
+)
+
+// TrafficDays is how many days of rollups are kept.
+var TrafficDays = 400
+
+type TrafficCounter struct {
+	UpBytes     int64 `json:"up_bytes"`
+	DownBytes   int64 `json:"down_bytes"`
+	UpPackets   int64 `json:"up_packets"`
+	DownPackets int64 `json:"down_packets"`
+}
+
+func (c *TrafficCounter) Add(upload bool, bytes, packets int) {
+	if upload {
+		c.UpBytes += int64(bytes)
+		c.UpPackets += int64(packets)
+	} else {
+		c.DownBytes += int64(bytes)
+		c.DownPackets += int64(packets)
+	}
+}
+
+func (c TrafficCounter) String() string {
+	return fmt.Sprintf("up: %v (%v packets), down: %v (%v packets)",
+		util.Unit(c.UpBytes), c.UpPackets, util.Unit(c.DownBytes), c.DownPackets)
+}
+
+type DayTraffic struct {
+	Day string `json:"day"` // 2006-01-02
+	TrafficCounter
+}
+
+// Traffic is the cumulative traffic of user with the daily rollups, the
+// newest day is the last.
+type Traffic struct {
+	Total TrafficCounter `json:"total"`
+	Days  []DayTraffic   `json:"days"`
+}
+
+func (t *Traffic) Add(upload bool, bytes, packets int, now time.Time) {
+	t.Total.Add(upload, bytes, packets)
+	day := now.Format("2006-01-02")
+	if len(t.Days) == 0 || t.Days[len(t.Days)-1].Day != day {
+		t.Days = append(t.Days, DayTraffic{Day: day})
+		if len(t.Days) > TrafficDays {
+			t.Days = append(t.Days[:0], t.Days[len(t.Days)-TrafficDays:]...)
+		}
+	}
+	t.Days[len(t.Days)-1].Add(upload, bytes, packets)
+}
+
+// Clone copies the traffic so it can be read without lock.
+func (t *Traffic) Clone() *Traffic {
+	if t == nil {
+		return &Traffic{}
+	}
+	ret := &Traffic{Total: t.Total}
+	ret.Days = append(ret.Days, t.Days...)
+	return ret
+}
+
+// CountTraffic accounts the traffic of user, it locks Users, the server
+// counts the traffic of each user and adds it periodically.
+func (us *Users) CountTraffic(userId int, upload bool, bytes, packets int, now time.Time) {
+	us.m.Lock()
+	defer us.m.Unlock()
+
+	u := us.FindId(userId)
+	if u == nil {
+		return
+	}
+	if u.Traffic == nil {
+		u.Traffic = &Traffic{}
+	}
+	u.Traffic.Add(upload, bytes, packets, now)
+	us.markDirty(u.Id)
+}
+
+// GetTraffic returns a copy of the traffic of user.
+func (us *Users) GetTraffic(name string) (*Traffic, error) {
+	us.m.RLock()
+	defer us.m.RUnlock()
+
+	u := us.Find(name)
+	if u == nil {
+		return nil, ErrUserNotFound.Trace()
+	}
+	return u.Traffic.Clone(), nil
+}
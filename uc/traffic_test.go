@@ -0,0 +1,61 @@
+package uc
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chzyer/test"
+)
+
+func TestTraffic(t *testing.T) {
+	defer test.New(t)
+
+	savePath := "/tmp/users_traffic.tmp"
+	os.Remove(savePath)
+	defer os.Remove(savePath)
+
+	us := NewUsers()
+	test.Nil(us.Open(NewGobStore(savePath)))
+	u, err := us.Register("hello", "bye")
+	test.Nil(err)
+
+	now := time.Date(2016, 5, 31, 23, 0, 0, 0, time.UTC)
+	us.CountTraffic(int(u.Id), true, 100, 2, now)
+	us.CountTraffic(int(u.Id), false, 1000, 3, now)
+	us.CountTraffic(int(u.Id), true, 10, 1, now.Add(2*time.Hour))
+	test.Nil(us.Flush())
+
+	us2 := NewUsers()
+	test.Nil(us2.Open(NewGobStore(savePath)))
+	traffic, err := us2.GetTraffic("hello")
+	test.Nil(err)
+	test.Equal(traffic.Total, TrafficCounter{
+		UpBytes: 110, UpPackets: 3, DownBytes: 1000, DownPackets: 3,
+	})
+	test.Equal(len(traffic.Days), 2)
+	test.Equal(traffic.Days[0].Day, "2016-05-31")
+	test.Equal(traffic.Days[0].DownBytes, int64(1000))
+	test.Equal(traffic.Days[1].Day, "2016-06-01")
+	test.Equal(traffic.Days[1].UpBytes, int64(10))
+
+	_, err = us2.GetTraffic("bye")
+	test.Equal(err, ErrUserNotFound)
+}
+
+func TestTrafficDays(t *testing.T) {
+	defer test.New(t)
+
+	old := TrafficDays
+	TrafficDays = 3
+	defer func() { TrafficDays = old }()
+
+	var traffic Traffic
+	now := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		traffic.Add(true, 1, 1, now.AddDate(0, 0, i))
+	}
+	test.Equal(traffic.Total.UpBytes, int64(5))
+	test.Equal(len(traffic.Days), 3)
+	test.Equal(traffic.Days[0].Day, "2016-05-03")
+}
@@ -5,53 +5,79 @@ import (
 	"encoding/gob"
 	"fmt"
 	"math/rand"
-	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chzyer/logex"
 	"github.com/chzyer/next/ip"
 	"github.com/chzyer/next/packet"
+	"github.com/chzyer/next/util"
 )
 
 var (
-	ErrUserNotFound = logex.Define("user not found")
+	ErrUserNotFound  = logex.Define("user not found")
+	ErrUserExists    = logex.Define("user '%v' already exists")
+	ErrUserDisabled  = logex.Define("user is disabled")
+	ErrNeedPassword  = logex.Define("user '%v' has no password, set it before enabling")
+	ErrQuotaExceeded = logex.Define("the monthly quota of user is exceeded")
 )
 
 type Users struct {
-	user []User
-	m    sync.RWMutex
+	user     []User
+	groupACL map[string][]*ACLRule
+	store    UserStore
+	dirty    map[uint16]struct{} // changed after last flush
+	aclDirty bool
+	m        sync.RWMutex
 }
 
 func NewUsers() *Users {
 	return &Users{}
 }
 
-func (us *Users) Register(name string, pswd string) *User {
+// markDirty must be called with lock held.
+func (us *Users) markDirty(id uint16) {
+	if us.dirty == nil {
+		us.dirty = make(map[uint16]struct{})
+	}
+	us.dirty[id] = struct{}{}
+}
+
+// Register adds the user with password, the name should not be used by
+// others.
+func (us *Users) Register(name string, pswd string) (*User, error) {
 	ui := &UserInfo{
-		Name:     name,
-		Password: pswd,
+		Name: name,
 	}
+	ui.SetPassword(pswd)
 	return us.AddUser(ui)
 }
 
 func (us *Users) LoginByName(name string, pswd string) *User {
-	for idx, u := range us.user {
-		if u.Name == name {
-			return us.Login(idx, pswd)
-		}
+	u := us.Find(name)
+	if u == nil {
+		return nil
 	}
-	return nil
+	return us.Login(int(u.Id), pswd)
 }
 
+// Show returns all users except the deleted ones
 func (us *Users) Show() []User {
-	return us.user
+	ret := make([]User, 0, len(us.user))
+	for _, u := range us.user {
+		if !u.Deleted {
+			ret = append(ret, u)
+		}
+	}
+	return ret
 }
 
 func (us *Users) Find(username string) *User {
-	for _, u := range us.user {
-		if u.Name == username {
-			return &u
+	for idx := range us.user {
+		u := &us.user[idx]
+		if !u.Deleted && u.Name == username {
+			return u
 		}
 	}
 	return nil
@@ -59,51 +85,357 @@ func (us *Users) Find(username string) *User {
 
 func (us *Users) Login(userId int, pswd string) *User {
 	u := us.FindId(userId)
-	if u == nil {
+	if u == nil || u.Disabled {
 		return nil
 	}
-	if u.Password != pswd {
+	if !u.CheckPassword(pswd) {
 		return nil
 	}
+	if u.NeedUpgrade() {
+		// the password is known now, rehash it by the current kdf,
+		// the caller should save the users.
+		us.m.Lock()
+		u.SetPassword(pswd)
+		us.markDirty(u.Id)
+		us.m.Unlock()
+	}
 	return u
 }
 
-func (us *Users) AddUser(ui *UserInfo) *User {
+// Upgrade replaces the old verifier of the user, which is sent by client
+// after login.
+func (us *Users) Upgrade(userId int, v *VerifierUpgrade) error {
+	u := us.FindId(userId)
+	if u == nil {
+		return ErrUserNotFound.Trace()
+	}
+	us.m.Lock()
+	u.KDF, u.Salt, u.Verifier = v.KDF, v.Salt, v.Verifier
+	us.markDirty(u.Id)
+	us.m.Unlock()
+	return nil
+}
+
+// Delete keeps the slot of user as a tombstone, so the ids of others are
+// not changed, the deleted user is returned.
+func (us *Users) Delete(name string) (*User, error) {
 	us.m.Lock()
+	defer us.m.Unlock()
+
+	u := us.Find(name)
+	if u == nil {
+		return nil, ErrUserNotFound.Trace()
+	}
+	old := *u
+	*u = *NewUser(&UserInfo{Id: u.Id, Deleted: true})
+	us.markDirty(u.Id)
+	return &old, nil
+}
+
+func (us *Users) Rename(name, newName string) error {
+	us.m.Lock()
+	defer us.m.Unlock()
+
+	u := us.Find(name)
+	if u == nil {
+		return ErrUserNotFound.Trace()
+	}
+	if us.Find(newName) != nil {
+		return ErrUserExists.Format(newName)
+	}
+	u.Name = newName
+	us.markDirty(u.Id)
+	return nil
+}
+
+func (us *Users) SetDisabled(name string, disabled bool) error {
+	us.m.Lock()
+	defer us.m.Unlock()
+
+	u := us.Find(name)
+	if u == nil {
+		return ErrUserNotFound.Trace()
+	}
+	if !disabled && u.ResetPassword {
+		return ErrNeedPassword.Format(name)
+	}
+	u.Disabled = disabled
+	us.markDirty(u.Id)
+	return nil
+}
+
+func (us *Users) SetAdmin(name string, isAdmin bool) error {
+	return us.update(name, func(u *User) {
+		u.IsAdmin = isAdmin
+	})
+}
+
+// ResetToken makes the data channels which are using the old token can't
+// reconnect, the user need to login again.
+func (us *Users) ResetToken(name string) error {
+	return us.update(name, func(u *User) {
+		u.Token = GenToken()
+	})
+}
+
+func (us *Users) SetGroups(name string, groups []string) error {
+	return us.update(name, func(u *User) {
+		u.Groups = groups
+		us.refreshACL(u)
+	})
+}
+
+// SetLimit sets the rates and monthly quota of user in bytes, 0 means
+// unlimited.
+func (us *Users) SetLimit(name string, upload, download, quota int64) error {
+	return us.update(name, func(u *User) {
+		u.UploadLimit, u.DownloadLimit, u.Quota = upload, download, quota
+	})
+}
+
+// ResetQuota clears the usage of current month.
+func (us *Users) ResetQuota(name string) error {
+	return us.update(name, func(u *User) {
+		u.QuotaUsed = 0
+	})
+}
+
+// Consume accounts n bytes to the quota of user, and returns false if the
+// quota runs out. The usage is reset in a new month. It locks Users, the
+// server counts the bytes of each user and consumes them periodically.
+func (us *Users) Consume(userId int, n int, now time.Time) bool {
+	us.m.Lock()
+	defer us.m.Unlock()
+
+	u := us.FindId(userId)
+	if u == nil {
+		return false
+	}
+	if month := quotaMonth(now); u.QuotaMonth != month {
+		u.QuotaMonth, u.QuotaUsed = month, 0
+	}
+	if u.IsQuotaExceeded(now) {
+		return false
+	}
+	u.QuotaUsed += int64(n)
+	us.markDirty(u.Id)
+	return true
+}
+
+// SetStaticIP reserves the ip for the user, nil to remove the reservation.
+func (us *Users) SetStaticIP(name string, addr *ip.IP) error {
+	return us.update(name, func(u *User) {
+		u.StaticIP = addr
+	})
+}
+
+func (us *Users) SetLease(userId int, l *Lease) error {
+	return us.updateId(userId, func(u *User) {
+		u.Lease = l
+	})
+}
+
+func (us *Users) SetNet(userId int, addr *ip.IP) error {
+	return us.updateId(userId, func(u *User) {
+		u.Net = addr
+	})
+}
+
+func (us *Users) SetNet6(userId int, addr *ip.IP6) error {
+	return us.updateId(userId, func(u *User) {
+		u.Net6 = addr
+	})
+}
+
+func (us *Users) updateId(userId int, f func(*User)) error {
+	u := us.FindId(userId)
+	if u == nil {
+		return ErrUserNotFound.Trace()
+	}
+	us.m.Lock()
+	f(u)
+	us.markDirty(u.Id)
+	us.m.Unlock()
+	return nil
+}
+
+func (us *Users) update(name string, f func(*User)) error {
+	us.m.Lock()
+	defer us.m.Unlock()
+
+	u := us.Find(name)
+	if u == nil {
+		return ErrUserNotFound.Trace()
+	}
+	f(u)
+	us.markDirty(u.Id)
+	return nil
+}
+
+func (us *Users) SetPassword(name string, pswd string) error {
+	return us.update(name, func(u *User) {
+		u.SetPassword(pswd)
+	})
+}
+
+func (us *Users) AddUser(ui *UserInfo) (*User, error) {
+	us.m.Lock()
+	defer us.m.Unlock()
+	if us.Find(ui.Name) != nil {
+		return nil, ErrUserExists.Format(ui.Name)
+	}
 	u := NewUser(ui)
 	u.Id = uint16(len(us.user))
+	us.refreshACL(u)
 	us.user = append(us.user, *u)
-	us.m.Unlock()
-	return u
+	us.markDirty(u.Id)
+	return u, nil
 }
 
-func (u *Users) Load(fp string) error {
-	u.m.Lock()
-	defer u.m.Unlock()
-
-	fh, err := os.OpenFile(fp, os.O_RDONLY, 0600)
+// Open loads the users from the store, the later changes are written to it
+// by Flush.
+func (us *Users) Open(store UserStore) error {
+	users, err := store.Load()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return logex.NewError(fp + " is not exists.")
+		return logex.Trace(err)
+	}
+	var groupACL map[string][]*ACLRule
+	if as, ok := store.(ACLStore); ok {
+		groupACL, err = as.LoadACL()
+		if err != nil {
+			return logex.Trace(err)
+		}
+	}
+	for _, rules := range groupACL {
+		compileACL(rules)
+	}
+	for idx := range users {
+		// FindId is called without lock, the id is the index
+		users[idx].Id = uint16(idx)
+		compileACL(users[idx].ACL)
+	}
+
+	us.m.Lock()
+	defer us.m.Unlock()
+	us.user = users
+	us.groupACL = groupACL
+	us.store = store
+	us.dirty = nil
+	us.aclDirty = false
+	us.refreshAllACL()
+
+	// the passwords in old db are replaced by the verifiers
+	migrated := 0
+	for idx := range us.user {
+		ui := us.user[idx].UserInfo
+		if ui.Password != "" {
+			ui.SetPassword(ui.Password)
+			us.markDirty(ui.Id)
+			migrated++
 		}
+	}
+	if migrated > 0 {
+		logex.Infof("migrate %v passwords to verifiers", migrated)
+		return logex.Trace(us.flush())
+	}
+	return nil
+}
+
+// IsDirty tells whether there are changes not flushed yet.
+func (us *Users) IsDirty() bool {
+	us.m.RLock()
+	defer us.m.RUnlock()
+	return len(us.dirty) > 0 || us.aclDirty
+}
+
+// Flush writes the changed users to the store.
+func (us *Users) Flush() error {
+	us.m.Lock()
+	defer us.m.Unlock()
+	return us.flush()
+}
+
+func (us *Users) flush() error {
+	if us.store == nil {
+		return ErrNoStore.Trace()
+	}
+	if us.aclDirty {
+		as, ok := us.store.(ACLStore)
+		if !ok {
+			return ErrReadOnlyStore.Trace()
+		}
+		if err := as.SaveACL(us.groupACL); err != nil {
+			return logex.Trace(err)
+		}
+		us.aclDirty = false
+	}
+	if len(us.dirty) == 0 {
+		return nil
+	}
+	if is, ok := us.store.(IncrementalStore); ok {
+		for id := range us.dirty {
+			if err := is.Put(us.user[id].UserInfo); err != nil {
+				return logex.Trace(err)
+			}
+			delete(us.dirty, id)
+		}
+		return nil
+	}
+	if err := us.store.Save(us.user); err != nil {
 		return logex.Trace(err)
 	}
-	defer fh.Close()
+	us.dirty = nil
+	return nil
+}
+
+// Close closes the store.
+func (us *Users) Close() error {
+	us.m.Lock()
+	defer us.m.Unlock()
+	if us.store == nil {
+		return nil
+	}
+	err := us.store.Close()
+	us.store = nil
+	return logex.Trace(err)
+}
 
-	return logex.Trace(gob.NewDecoder(fh).Decode(&u.user))
+// Load opens the gob file as the store.
+func (us *Users) Load(fp string) error {
+	return us.Open(NewGobStore(fp))
 }
 
-func (u *Users) Save(fp string) error {
-	u.m.Lock()
-	defer u.m.Unlock()
+// Save writes all users to the gob file.
+func (us *Users) Save(fp string) error {
+	us.m.Lock()
+	defer us.m.Unlock()
+	return NewGobStore(fp).Save(us.user)
+}
 
-	fh, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+// Import adds the users which are not exists from the store, returns the
+// added users. The passwords of imported users are hashed.
+func (us *Users) Import(store UserStore) ([]*User, error) {
+	users, err := store.Load()
 	if err != nil {
-		return logex.Trace(err)
+		return nil, logex.Trace(err)
 	}
-	defer fh.Close()
 
-	return logex.Trace(gob.NewEncoder(fh).Encode(u.user))
+	var added []*User
+	for idx := range users {
+		ui := users[idx].UserInfo
+		if ui.Deleted || us.Find(ui.Name) != nil {
+			continue
+		}
+		if ui.Password != "" {
+			ui.SetPassword(ui.Password)
+		}
+		u, err := us.AddUser(ui)
+		if err != nil {
+			return added, logex.Trace(err)
+		}
+		added = append(added, u)
+	}
+	return added, nil
 }
 
 func (us *Users) FindByIP(addr ip.IP) *User {
@@ -111,7 +443,7 @@ func (us *Users) FindByIP(addr ip.IP) *User {
 	defer us.m.RUnlock()
 
 	for idx := range us.user {
-		if us.user[idx].Net == nil {
+		if us.user[idx].Net == nil || us.user[idx].Deleted {
 			continue
 		}
 		if us.user[idx].Net.Equal(addr) {
@@ -121,34 +453,57 @@ func (us *Users) FindByIP(addr ip.IP) *User {
 	return nil
 }
 
+func (us *Users) FindByIP6(addr ip.IP6) *User {
+	us.m.RLock()
+	defer us.m.RUnlock()
+
+	for idx := range us.user {
+		if us.user[idx].Net6 == nil || us.user[idx].Deleted {
+			continue
+		}
+		if us.user[idx].Net6.Equal(addr) {
+			return &us.user[idx]
+		}
+	}
+	return nil
+}
+
 func (us *Users) FindId(id int) *User {
-	if id >= len(us.user) {
+	if id < 0 || id >= len(us.user) {
 		return nil
 	}
 	u := &us.user[id]
-	if u.Id == 0 {
-		u.Id = uint16(id)
+	if u.Deleted {
+		return nil
 	}
 	return u
 }
 
 type User struct {
 	*UserInfo
-	Net   *ip.IP
-	Token string
-	chan1 packet.Chan
-	chan2 packet.Chan
+	Net       *ip.IP
+	Net6      *ip.IP6
+	Token     string
+	L2Version packet.Version
+	chan1     packet.Chan
+	chan2     packet.Chan
+
+	// [][]*ACLRule, shared by the copies of user
+	acl *atomic.Value
 }
 
 func NewUser(ui *UserInfo) *User {
 	return &User{
 		UserInfo: ui,
 		Token:    GenToken(),
+		acl:      new(atomic.Value),
 	}
 }
 
-// controller -> user -> datachannel
-//            <-      <-
+// ensureChannel creates the chans between them:
+//
+//	controller -> user -> datachannel
+//	           <-      <-
 func (u *User) ensureChannel() {
 	if u.chan1 == nil {
 		u.chan1 = make(packet.Chan)
@@ -175,8 +530,17 @@ func (u *User) GetFromDataChannel() (
 }
 
 func (u User) String() string {
-	return fmt.Sprintf(`{Id: %v, Name: %v, Token: %v, Net: %v, IsAdmin: %v}`,
-		u.Id, u.Name, u.Token, u.Net, u.IsAdmin)
+	ret := fmt.Sprintf(`{Id: %v, Name: %v, Token: %v, Net: %v, Net6: %v, StaticIP: %v, IsAdmin: %v, Disabled: %v, Groups: %v`,
+		u.Id, u.Name, u.Token, u.Net, u.Net6, u.StaticIP, u.IsAdmin, u.Disabled, u.Groups)
+	if u.UploadLimit > 0 || u.DownloadLimit > 0 {
+		ret += fmt.Sprintf(", Limit: %v/s up, %v/s down",
+			util.Unit(u.UploadLimit), util.Unit(u.DownloadLimit))
+	}
+	if u.Quota > 0 {
+		ret += fmt.Sprintf(", Quota: %v/%v",
+			util.Unit(u.QuotaUsage(time.Now())), util.Unit(u.Quota))
+	}
+	return ret + "}"
 }
 
 // directly encode UserInfo to ignore other temporary variables
@@ -206,8 +570,88 @@ func (u *User) IsOnline() bool {
 type UserInfo struct {
 	Id       uint16
 	Name     string
-	Password string
+	Password string // only exists in old db, it's migrated in loading
 	IsAdmin  bool
+	Disabled bool
+	Deleted  bool
+	// imported without a usable password, it's disabled until the
+	// password is set
+	ResetPassword bool
+
+	Groups []string   // users in the same group can reach each other in hub mode
+	ACL    []*ACLRule // evaluated before the rules of groups
+
+	// bytes per second, 0 means unlimited
+	UploadLimit   int64
+	DownloadLimit int64
+	// bytes per month of both directions, 0 means unlimited
+	Quota      int64
+	QuotaUsed  int64
+	QuotaMonth string // the month of QuotaUsed, e.g. 2006-01
+
+	Traffic *Traffic
+
+	StaticIP *ip.IP // reserved by admin
+	Lease    *Lease // allocated by dhcp
+
+	// SRP-6a, the password is not stored
+	KDF      KDF
+	Salt     []byte
+	Verifier []byte
+}
+
+func (ui *UserInfo) InGroup(group string) bool {
+	for _, g := range ui.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// ShareGroup reports whether the users have a common group.
+func (ui *UserInfo) ShareGroup(ui2 *UserInfo) bool {
+	for _, g := range ui.Groups {
+		if ui2.InGroup(g) {
+			return true
+		}
+	}
+	return false
+}
+
+func quotaMonth(now time.Time) string {
+	return now.Format("2006-01")
+}
+
+// QuotaUsage returns the used bytes in the month of now.
+func (ui *UserInfo) QuotaUsage(now time.Time) int64 {
+	if ui.QuotaMonth != quotaMonth(now) {
+		return 0
+	}
+	return ui.QuotaUsed
+}
+
+func (ui *UserInfo) IsQuotaExceeded(now time.Time) bool {
+	return ui.Quota > 0 && ui.QuotaUsage(now) >= ui.Quota
+}
+
+// Lease is the ip which is allocated to user dynamically, it's persisted
+// so the user can get the same ip after server restarted, and released
+// after the user has been offline for a while.
+type Lease struct {
+	IP   ip.IP
+	IP6  *ip.IP6 // nil if ipv6 is not enabled
+	Seen time.Time
+}
+
+// HasIP reports whether the ipv4 is allocated in the lease, it's empty if
+// the user has a static ip.
+func (l *Lease) HasIP() bool {
+	return l.IP != ip.IP{}
+}
+
+func (l *Lease) IsExpired(now time.Time, ttl time.Duration) bool {
+	return now.Sub(l.Seen) > ttl
 }
 
 func init() {
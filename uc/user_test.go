@@ -3,7 +3,10 @@ package uc
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/chzyer/next/crypto"
+	"github.com/chzyer/next/ip"
 	"github.com/chzyer/test"
 )
 
@@ -11,7 +14,8 @@ func TestUsers(t *testing.T) {
 	defer test.New(t)
 
 	us := NewUsers()
-	u1 := us.Register("hello", "bye")
+	u1, err := us.Register("hello", "bye")
+	test.Nil(err)
 	u2 := us.LoginByName("hello", "bye1")
 	test.Nil(u2)
 	u2 = us.LoginByName("hello", "bye")
@@ -20,10 +24,182 @@ func TestUsers(t *testing.T) {
 	savePath := "/tmp/users.tmp"
 	os.Remove(savePath)
 	defer os.Remove(savePath)
-	err := us.Save(savePath)
+	err = us.Save(savePath)
 	test.Nil(err)
 	us2 := NewUsers()
 	us2.Load(savePath)
 	u3 := us2.LoginByName("hello", "bye")
 	test.Equal(u1.UserInfo, u3.UserInfo)
 }
+
+func TestUsersMigratePassword(t *testing.T) {
+	defer test.New(t)
+
+	savePath := "/tmp/users_migrate.tmp"
+	os.Remove(savePath)
+	defer os.Remove(savePath)
+
+	us := NewUsers()
+	_, err := us.AddUser(&UserInfo{Name: "hello", Password: "bye"})
+	test.Nil(err)
+	test.Nil(us.Save(savePath))
+
+	us2 := NewUsers()
+	test.Nil(us2.Load(savePath))
+	u := us2.LoginByName("hello", "bye")
+	test.NotNil(u)
+	test.Equal(u.Password, "")
+	test.Nil(us2.LoginByName("hello", "bye1"))
+
+	// the migrated one is saved
+	us3 := NewUsers()
+	test.Nil(us3.Load(savePath))
+	test.Equal(us3.Find("hello").Password, "")
+	test.NotNil(us3.LoginByName("hello", "bye"))
+}
+
+func TestUsersUpgradeKDF(t *testing.T) {
+	defer test.New(t)
+
+	us := NewUsers()
+	salt := test.RandBytes(16)
+	us.AddUser(&UserInfo{
+		Name:     "hello",
+		KDF:      KDFSHA256,
+		Salt:     salt,
+		Verifier: crypto.SRPVerifier(KDFSHA256.Private(salt, "bye")),
+	})
+	test.True(us.Find("hello").NeedUpgrade())
+	test.Nil(us.LoginByName("hello", "bye1"))
+	test.True(us.Find("hello").NeedUpgrade())
+
+	u := us.LoginByName("hello", "bye")
+	test.NotNil(u)
+	test.Equal(u.KDF, KDFArgon2id)
+	test.NotEqual(u.Salt, salt)
+	test.NotNil(us.LoginByName("hello", "bye"))
+
+	test.Nil(us.SetPassword("hello", "bye2"))
+	test.Nil(us.LoginByName("hello", "bye"))
+	test.NotNil(us.LoginByName("hello", "bye2"))
+	test.NotNil(us.SetPassword("hello2", "bye2"))
+}
+
+func TestVerifierUpgrade(t *testing.T) {
+	defer test.New(t)
+
+	key := test.RandBytes(32)
+	v := NewVerifierUpgrade("bye")
+	sealed := v.Seal(key)
+
+	_, err := OpenVerifierUpgrade(test.RandBytes(32), sealed)
+	test.NotNil(err)
+	v2, err := OpenVerifierUpgrade(key, sealed)
+	test.Nil(err)
+	test.Equal(v, v2)
+
+	us := NewUsers()
+	u, err := us.AddUser(&UserInfo{Name: "hello"})
+	test.Nil(err)
+	test.Nil(us.Upgrade(int(u.Id), v2))
+	test.NotNil(us.LoginByName("hello", "bye"))
+}
+
+func TestUsersLifecycle(t *testing.T) {
+	defer test.New(t)
+
+	us := NewUsers()
+	u1, err := us.Register("hello", "bye")
+	test.Nil(err)
+	u2, err := us.Register("world", "bye")
+	test.Nil(err)
+	_, err = us.Register("world", "bye2")
+	test.NotNil(err)
+	test.Equal(len(us.Show()), 2)
+
+	test.NotNil(us.Rename("hello", "world"))
+	test.Nil(us.Rename("hello", "hello2"))
+	test.Nil(us.Find("hello"))
+	test.NotNil(us.LoginByName("hello2", "bye"))
+
+	test.Nil(us.SetDisabled("hello2", true))
+	test.Nil(us.LoginByName("hello2", "bye"))
+	test.Nil(us.SetDisabled("hello2", false))
+	test.NotNil(us.LoginByName("hello2", "bye"))
+
+	test.Nil(us.SetAdmin("world", true))
+	test.True(us.Find("world").IsAdmin)
+
+	token := us.Find("world").Token
+	test.Nil(us.ResetToken("world"))
+	test.NotEqual(us.Find("world").Token, token)
+
+	// the id of others is not changed after deleted
+	deleted, err := us.Delete("hello2")
+	test.Nil(err)
+	test.Equal(deleted.Id, u1.Id)
+	test.Nil(us.FindId(int(u1.Id)))
+	test.Nil(us.LoginByName("hello2", "bye"))
+	test.Equal(us.FindId(int(u2.Id)).Name, "world")
+	test.Equal(len(us.Show()), 1)
+	_, err = us.Delete("hello2")
+	test.NotNil(err)
+
+	u3, err := us.Register("hello2", "bye")
+	test.Nil(err)
+	test.NotEqual(u3.Id, u1.Id)
+	test.NotNil(us.LoginByName("hello2", "bye"))
+
+	savePath := "/tmp/users_lifecycle.tmp"
+	os.Remove(savePath)
+	defer os.Remove(savePath)
+	test.Nil(us.Save(savePath))
+	us2 := NewUsers()
+	test.Nil(us2.Load(savePath))
+	test.Nil(us2.FindId(int(u1.Id)))
+	test.True(us2.Find("world").IsAdmin)
+	test.Equal(len(us2.Show()), 2)
+}
+
+func TestUsersFindByIP6(t *testing.T) {
+	defer test.New(t)
+
+	us := NewUsers()
+	u, err := us.Register("hello", "bye")
+	test.Nil(err)
+	addr := ip.ParseIP6("fd00::2")
+	test.Nil(us.FindByIP6(addr))
+	test.Nil(us.SetNet6(int(u.Id), &addr))
+	test.Equal(us.FindByIP6(addr).Name, "hello")
+	test.Nil(us.FindByIP6(ip.ParseIP6("fd00::3")))
+}
+
+func TestUsersQuota(t *testing.T) {
+	defer test.New(t)
+
+	us := NewUsers()
+	u, err := us.Register("hello", "bye")
+	test.Nil(err)
+	test.Nil(us.SetLimit("hello", 0, 0, 100))
+
+	now := time.Date(2016, 5, 31, 0, 0, 0, 0, time.UTC)
+	test.True(us.Consume(int(u.Id), 60, now))
+	test.True(us.Consume(int(u.Id), 60, now))
+	test.True(u.IsQuotaExceeded(now))
+	test.False(us.Consume(int(u.Id), 1, now))
+	test.Equal(u.QuotaUsage(now), int64(120))
+
+	// reset in a new month
+	next := now.AddDate(0, 0, 1)
+	test.False(u.IsQuotaExceeded(next))
+	test.True(us.Consume(int(u.Id), 1, next))
+	test.Equal(u.QuotaUsage(next), int64(1))
+
+	test.Nil(us.ResetQuota("hello"))
+	test.Equal(u.QuotaUsage(next), int64(0))
+
+	// unlimited
+	test.Nil(us.SetLimit("hello", 0, 0, 0))
+	test.True(us.Consume(int(u.Id), 1000, next))
+	test.False(u.IsQuotaExceeded(next))
+}
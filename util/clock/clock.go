@@ -22,7 +22,11 @@ func New() *Clock {
 	return &Clock{offset: time.Duration(offset) * time.Second}
 }
 
+// Now returns the local time if c is nil.
 func (c *Clock) Now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
 	return time.Now().Add(time.Duration(c.offset))
 }
 
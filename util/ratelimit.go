@@ -0,0 +1,44 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket limits the rate of bytes, it allows a burst of one second.
+type TokenBucket struct {
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+	m      sync.Mutex
+}
+
+func NewTokenBucket(rate int64) *TokenBucket {
+	return &TokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// Take takes n tokens and returns how long the caller should wait for them,
+// the bucket can be owed so a packet larger than the burst can pass.
+func (b *TokenBucket) Take(n int) time.Duration {
+	return b.take(n, time.Now())
+}
+
+func (b *TokenBucket) take(n int, now time.Time) time.Duration {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,27 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chzyer/test"
+)
+
+func TestTokenBucket(t *testing.T) {
+	defer test.New(t)
+
+	now := time.Now()
+	b := NewTokenBucket(1000)
+	b.last = now
+	test.Equal(b.take(600, now), time.Duration(0))
+	test.Equal(b.take(600, now), 200*time.Millisecond)
+
+	// refilled after wait
+	now = now.Add(200 * time.Millisecond)
+	test.Equal(b.take(0, now), time.Duration(0))
+
+	// the burst is one second
+	now = now.Add(time.Hour)
+	test.Equal(b.take(1000, now), time.Duration(0))
+	test.Equal(b.take(500, now), 500*time.Millisecond)
+}
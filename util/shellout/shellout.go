@@ -0,0 +1,101 @@
+// Package shellout writes the results of shell commands as text for human,
+// or as json for scripts.
+package shellout
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSONFlag is prefixed to the command line to get the result in json.
+const JSONFlag = "-json"
+
+// Output is reset before each command, the handlers report their results
+// by Result.
+type Output struct {
+	w      io.Writer
+	errW   io.Writer
+	json   bool
+	result interface{}
+}
+
+func New(w, errW io.Writer) *Output {
+	return &Output{w: w, errW: errW}
+}
+
+// Reset prepares for a new command.
+func (o *Output) Reset(json bool) {
+	o.json = json
+	o.result = nil
+}
+
+func (o *Output) IsJSON() bool {
+	return o.json
+}
+
+// Result records the result of command, the text is printed immediately if
+// it's not in json mode.
+func (o *Output) Result(v interface{}, text string) error {
+	if o.json {
+		o.result = v
+		return nil
+	}
+	if text != "" {
+		if text[len(text)-1] != '\n' {
+			text += "\n"
+		}
+		_, err := io.WriteString(o.w, text)
+		return err
+	}
+	return nil
+}
+
+type message struct {
+	Message string `json:"message"`
+}
+
+// Message reports a result which is only a message.
+func (o *Output) Message(format string, obj ...interface{}) error {
+	msg := fmt.Sprintf(format, obj...)
+	return o.Result(message{msg}, msg)
+}
+
+type jsonResult struct {
+	Result interface{} `json:"result"`
+}
+
+type jsonError struct {
+	Error string `json:"error"`
+}
+
+// Done writes the result or the error of command after it's finished, they
+// are one json object per line in json mode.
+func (o *Output) Done(err error) {
+	if !o.json {
+		if err != nil {
+			fmt.Fprintln(o.errW, err)
+		}
+		return
+	}
+	if err != nil {
+		ret, _ := json.Marshal(jsonError{err.Error()})
+		fmt.Fprintln(o.errW, string(ret))
+		return
+	}
+	ret, err := json.Marshal(jsonResult{Result: o.result})
+	if err != nil {
+		ret, _ = json.Marshal(jsonError{err.Error()})
+		fmt.Fprintln(o.errW, string(ret))
+		return
+	}
+	fmt.Fprintln(o.w, string(ret))
+}
+
+// ParseArgs strips the json flag from the args of command.
+func ParseArgs(args []string) ([]string, bool) {
+	if len(args) > 0 && args[0] == JSONFlag {
+		return args[1:], true
+	}
+	return args, false
+}
@@ -0,0 +1,43 @@
+package shellout
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/chzyer/test"
+)
+
+func TestOutput(t *testing.T) {
+	defer test.New(t)
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	o := New(stdout, stderr)
+
+	args, isJSON := ParseArgs([]string{"dchan", "list"})
+	test.False(isJSON)
+	test.Equal(args, []string{"dchan", "list"})
+	o.Reset(isJSON)
+	o.Result([]int{1}, "one")
+	o.Done(nil)
+	o.Done(errors.New("failed"))
+	test.Equal(stdout.String(), "one\n")
+	test.Equal(stderr.String(), "failed\n")
+
+	stdout.Reset()
+	stderr.Reset()
+	args, isJSON = ParseArgs([]string{"-json", "dchan", "list"})
+	test.True(isJSON)
+	test.Equal(args, []string{"dchan", "list"})
+	o.Reset(isJSON)
+	o.Result([]int{1}, "one")
+	o.Done(nil)
+	o.Reset(isJSON)
+	o.Message("user %v added", "a")
+	o.Done(nil)
+	o.Reset(isJSON)
+	o.Done(errors.New("failed"))
+	test.Equal(stdout.String(), "{\"result\":[1]}\n{\"result\":{\"message\":\"user a added\"}}\n")
+	test.Equal(stderr.String(), "{\"error\":\"failed\"}\n")
+}